@@ -0,0 +1,637 @@
+/*
+	go-swarm is a Go library and ccommand-line tool for managing the creation
+	and maintenance of Docker Swarm cluster.
+
+    Copyright (C) 2021 Sovereign Cloud Australia Pty Ltd
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as published
+    by the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package swarm
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// APIClient is a minimal client for the parts of the Docker Engine API used
+// to manage Swarm clusters. Requests are made over any transport that can be
+// dialed (e.g: a local UNIX socket or a UNIX socket forwarded over SSH).
+type APIClient struct {
+	client *http.Client
+	scheme string
+	host   string
+}
+
+// DialFunc dials a connection to the Docker Engine API
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// NewAPIClient constructs a new APIClient that connects to the Docker Engine
+// API with the given dial function
+func NewAPIClient(dial DialFunc, timeout time.Duration) *APIClient {
+	return &APIClient{
+		client: &http.Client{
+			Transport: &http.Transport{DialContext: dial},
+			Timeout:   timeout,
+		},
+		scheme: "http",
+		// The host is ignored by the dial function but is required for
+		// well-formed request URLs
+		host: "docker",
+	}
+}
+
+// NewUnixAPIClient constructs a new APIClient that connects to the Docker
+// Engine API on the local UNIX socket given by sockPath
+func NewUnixAPIClient(sockPath string, timeout time.Duration) *APIClient {
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", sockPath)
+	}
+	return NewAPIClient(dial, timeout)
+}
+
+// NewTLSAPIClient constructs a new APIClient that connects to the Docker
+// Engine API exposed over TCP at addr (host:port) protected by TLS
+func NewTLSAPIClient(addr string, tlsConfig *tls.Config, timeout time.Duration) *APIClient {
+	return &APIClient{
+		client: &http.Client{
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+			Timeout:   timeout,
+		},
+		scheme: "https",
+		host:   addr,
+	}
+}
+
+// APIError is an error response returned by the Docker Engine API
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("docker api error (status %d): %s", e.StatusCode, e.Message)
+}
+
+func (c *APIClient) do(ctx context.Context, method, path string, query url.Values, in, out interface{}) error {
+	var body io.Reader
+
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("error encoding request: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	u := url.URL{Scheme: c.scheme, Host: c.host, Path: path, RawQuery: query.Encode()}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error making request %s %s: %w", method, path, err)
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("error reading response: %w", err)
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		var e struct{ Message string }
+		if err := json.Unmarshal(data, &e); err != nil || e.Message == "" {
+			e.Message = strings.TrimSpace(string(data))
+		}
+		return &APIError{StatusCode: res.StatusCode, Message: e.Message}
+	}
+
+	if out != nil {
+		if err := json.Unmarshal(data, out); err != nil {
+			return fmt.Errorf("error parsing json data: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// Ping checks the Docker Engine API is reachable
+func (c *APIClient) Ping(ctx context.Context) error {
+	return c.do(ctx, http.MethodGet, "/_ping", nil, nil, nil)
+}
+
+// Info returns information about the node
+func (c *APIClient) Info(ctx context.Context) (NodeInfo, error) {
+	var info NodeInfo
+	err := c.do(ctx, http.MethodGet, "/info", nil, nil, &info)
+	return info, err
+}
+
+// NodeList returns all nodes in the cluster
+func (c *APIClient) NodeList(ctx context.Context) ([]NodeDetails, error) {
+	var nodes []NodeDetails
+	err := c.do(ctx, http.MethodGet, "/nodes", nil, nil, &nodes)
+	return nodes, err
+}
+
+// NodeInspect returns a node in the cluster given by its ID or hostname
+func (c *APIClient) NodeInspect(ctx context.Context, node string) (NodeDetails, error) {
+	var details NodeDetails
+	err := c.do(ctx, http.MethodGet, "/nodes/"+url.PathEscape(node), nil, nil, &details)
+	return details, err
+}
+
+// NodeUpdate replaces the spec of the node given by its ID. The version must
+// be the current version of the node as returned by NodeInspect.
+func (c *APIClient) NodeUpdate(ctx context.Context, id string, version uint64, spec NodeSpec) error {
+	query := url.Values{"version": []string{strconv.FormatUint(version, 10)}}
+	return c.do(ctx, http.MethodPost, "/nodes/"+url.PathEscape(id)+"/update", query, spec, nil)
+}
+
+// NodeRemove removes the node given by its ID or hostname from the cluster
+func (c *APIClient) NodeRemove(ctx context.Context, node string, force bool) error {
+	query := url.Values{"force": []string{strconv.FormatBool(force)}}
+	return c.do(ctx, http.MethodDelete, "/nodes/"+url.PathEscape(node), query, nil, nil)
+}
+
+// TaskList returns the tasks in the cluster matching the given filters
+func (c *APIClient) TaskList(ctx context.Context, filters map[string][]string) ([]Task, error) {
+	data, err := json.Marshal(filters)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding filters: %w", err)
+	}
+
+	var tasks []Task
+	err = c.do(ctx, http.MethodGet, "/tasks", url.Values{"filters": []string{string(data)}}, nil, &tasks)
+	return tasks, err
+}
+
+// SwarmInspect returns information about the cluster including join tokens
+func (c *APIClient) SwarmInspect(ctx context.Context) (SwarmDetails, error) {
+	var details SwarmDetails
+	err := c.do(ctx, http.MethodGet, "/swarm", nil, nil, &details)
+	return details, err
+}
+
+// SwarmUpdate updates the cluster's spec. The version must be the version of
+// the cluster the spec was inspected at. Additional options (e.g: rotating
+// join tokens) are given by query.
+func (c *APIClient) SwarmUpdate(ctx context.Context, version uint64, spec json.RawMessage, query url.Values) error {
+	if query == nil {
+		query = url.Values{}
+	}
+	query.Set("version", strconv.FormatUint(version, 10))
+	return c.do(ctx, http.MethodPost, "/swarm/update", query, spec, nil)
+}
+
+// SwarmUnlockKey returns the key used to unlock managers of an autolocked
+// cluster
+func (c *APIClient) SwarmUnlockKey(ctx context.Context) (string, error) {
+	var res struct{ UnlockKey string }
+	err := c.do(ctx, http.MethodGet, "/swarm/unlockkey", nil, nil, &res)
+	return res.UnlockKey, err
+}
+
+// SwarmUnlock unlocks a locked manager with the given key
+func (c *APIClient) SwarmUnlock(ctx context.Context, key string) error {
+	req := struct{ UnlockKey string }{UnlockKey: key}
+	return c.do(ctx, http.MethodPost, "/swarm/unlock", nil, req, nil)
+}
+
+// SwarmInitRequest is the request to initialize a new cluster
+type SwarmInitRequest struct {
+	ListenAddr      string
+	AdvertiseAddr   string
+	DataPathAddr    string   `json:",omitempty"`
+	DataPathPort    uint32   `json:",omitempty"`
+	DefaultAddrPool []string `json:",omitempty"`
+	SubnetSize      uint32   `json:",omitempty"`
+	Availability    string   `json:",omitempty"`
+	Spec            ClusterSpec
+}
+
+// SwarmInit initializes a new cluster and returns the ID of the node
+func (c *APIClient) SwarmInit(ctx context.Context, req SwarmInitRequest) (string, error) {
+	var nodeID string
+	err := c.do(ctx, http.MethodPost, "/swarm/init", nil, req, &nodeID)
+	return nodeID, err
+}
+
+// SwarmJoinRequest is the request to join an existing cluster
+type SwarmJoinRequest struct {
+	ListenAddr    string
+	AdvertiseAddr string
+	DataPathAddr  string `json:",omitempty"`
+	RemoteAddrs   []string
+	JoinToken     string
+}
+
+// SwarmJoin joins the node to an existing cluster
+func (c *APIClient) SwarmJoin(ctx context.Context, req SwarmJoinRequest) error {
+	return c.do(ctx, http.MethodPost, "/swarm/join", nil, req, nil)
+}
+
+// SwarmLeave makes the node leave the cluster
+func (c *APIClient) SwarmLeave(ctx context.Context, force bool) error {
+	query := url.Values{"force": []string{strconv.FormatBool(force)}}
+	return c.do(ctx, http.MethodPost, "/swarm/leave", query, nil, nil)
+}
+
+// ServiceList returns all services in the cluster
+func (c *APIClient) ServiceList(ctx context.Context) ([]Service, error) {
+	var services []Service
+	err := c.do(ctx, http.MethodGet, "/services", nil, nil, &services)
+	return services, err
+}
+
+// ServiceRemove removes a service given by its ID or name
+func (c *APIClient) ServiceRemove(ctx context.Context, service string) error {
+	return c.do(ctx, http.MethodDelete, "/services/"+url.PathEscape(service), nil, nil, nil)
+}
+
+// apiEngine implements engine with the Docker Engine API
+type apiEngine struct {
+	client *APIClient
+}
+
+func (e *apiEngine) Info(ctx context.Context) (NodeInfo, error) {
+	return e.client.Info(ctx)
+}
+
+func (e *apiEngine) NodeList(ctx context.Context) ([]NodeStatus, error) {
+	nodes, err := e.client.NodeList(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []NodeStatus
+	for _, node := range nodes {
+		res = append(res, node.NodeStatus())
+	}
+
+	return res, nil
+}
+
+func (e *apiEngine) NodeInspect(ctx context.Context, node string) (NodeDetails, error) {
+	return e.client.NodeInspect(ctx, node)
+}
+
+func (e *apiEngine) NodeUpdate(ctx context.Context, node string, update NodeUpdate) error {
+	details, err := e.client.NodeInspect(ctx, node)
+	if err != nil {
+		return fmt.Errorf("error inspecting node: %w", err)
+	}
+
+	spec := details.Spec
+
+	if update.Availability != "" {
+		spec.Availability = update.Availability
+	}
+	if update.Role != "" {
+		spec.Role = update.Role
+	}
+
+	if len(update.AddLabels) > 0 || len(update.RemoveLabels) > 0 {
+		labels := make(map[string]string)
+		for key, value := range spec.Labels {
+			labels[key] = value
+		}
+		for _, label := range update.AddLabels {
+			tokens := strings.SplitN(label, "=", 2)
+			if len(tokens) == 2 {
+				labels[tokens[0]] = tokens[1]
+			} else {
+				labels[tokens[0]] = ""
+			}
+		}
+		for _, key := range update.RemoveLabels {
+			delete(labels, key)
+		}
+		spec.Labels = labels
+	}
+
+	return e.client.NodeUpdate(ctx, details.ID, details.Version.Index, spec)
+}
+
+func (e *apiEngine) NodeRemove(ctx context.Context, node string) error {
+	return e.client.NodeRemove(ctx, node, false)
+}
+
+func (e *apiEngine) NodeTasks(ctx context.Context, node string) (Tasks, error) {
+	// Resolve hostnames to node IDs as tasks are filtered by node ID
+	details, err := e.client.NodeInspect(ctx, node)
+	if err != nil {
+		return nil, fmt.Errorf("error inspecting node: %w", err)
+	}
+
+	tasks, err := e.client.TaskList(ctx, map[string][]string{"node": {details.ID}})
+	if err != nil {
+		return nil, err
+	}
+
+	var res Tasks
+	for _, task := range tasks {
+		res = append(res, task.TaskStatus())
+	}
+
+	return res, nil
+}
+
+func (e *apiEngine) SwarmInit(ctx context.Context, advertiseAddr, listenAddr string, cfg SwarmConfig) error {
+	_, err := e.client.SwarmInit(ctx, SwarmInitRequest{
+		ListenAddr:      listenAddr,
+		AdvertiseAddr:   advertiseAddr,
+		DataPathAddr:    cfg.DataPathAddr,
+		DataPathPort:    cfg.DataPathPort,
+		DefaultAddrPool: cfg.DefaultAddrPool,
+		SubnetSize:      cfg.DefaultAddrPoolMaskLength,
+		Availability:    cfg.Availability,
+		Spec: ClusterSpec{
+			Orchestration: OrchestrationConfig{TaskHistoryRetentionLimit: cfg.TaskHistoryLimit},
+			Dispatcher:    DispatcherConfig{HeartbeatPeriod: time.Duration(cfg.DispatcherHeartbeat)},
+			CAConfig:      CAConfig{NodeCertExpiry: time.Duration(cfg.CertExpiry)},
+		},
+	})
+	return err
+}
+
+func (e *apiEngine) SwarmUpdate(ctx context.Context, update SwarmUpdate) error {
+	return e.updateSpec(ctx, func(spec map[string]json.RawMessage) error {
+		if update.TaskHistoryLimit != nil {
+			if err := setSpecField(spec, "Orchestration", "TaskHistoryRetentionLimit", *update.TaskHistoryLimit); err != nil {
+				return err
+			}
+		}
+		if update.DispatcherHeartbeat != 0 {
+			if err := setSpecField(spec, "Dispatcher", "HeartbeatPeriod", update.DispatcherHeartbeat); err != nil {
+				return err
+			}
+		}
+		if update.CertExpiry != 0 {
+			if err := setSpecField(spec, "CAConfig", "NodeCertExpiry", update.CertExpiry); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (e *apiEngine) SwarmJoin(ctx context.Context, advertiseAddr, listenAddr, dataPathAddr, token, remoteAddr string) error {
+	return e.client.SwarmJoin(ctx, SwarmJoinRequest{
+		ListenAddr:    listenAddr,
+		AdvertiseAddr: advertiseAddr,
+		DataPathAddr:  dataPathAddr,
+		RemoteAddrs:   []string{remoteAddr},
+		JoinToken:     token,
+	})
+}
+
+func (e *apiEngine) SwarmLeave(ctx context.Context, force bool) error {
+	return e.client.SwarmLeave(ctx, force)
+}
+
+func (e *apiEngine) JoinToken(ctx context.Context, tokenType string) (string, error) {
+	details, err := e.client.SwarmInspect(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	switch tokenType {
+	case managerToken:
+		return details.JoinTokens.Manager, nil
+	case workerToken:
+		return details.JoinTokens.Worker, nil
+	default:
+		return "", fmt.Errorf("error unknown token type %q", tokenType)
+	}
+}
+
+func (e *apiEngine) ServiceList(ctx context.Context) ([]Service, error) {
+	return e.client.ServiceList(ctx)
+}
+
+func (e *apiEngine) ServiceRemove(ctx context.Context, service string) error {
+	return e.client.ServiceRemove(ctx, service)
+}
+
+func (e *apiEngine) RotateJoinToken(ctx context.Context, tokenType string) (string, error) {
+	details, err := e.client.SwarmInspect(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{}
+	switch tokenType {
+	case managerToken:
+		query.Set("rotateManagerToken", "true")
+	case workerToken:
+		query.Set("rotateWorkerToken", "true")
+	default:
+		return "", fmt.Errorf("error unknown token type %q", tokenType)
+	}
+
+	if err := e.client.SwarmUpdate(ctx, details.Version.Index, details.Spec, query); err != nil {
+		return "", fmt.Errorf("error updating swarm: %w", err)
+	}
+
+	return e.JoinToken(ctx, tokenType)
+}
+
+// updateSpec updates the cluster's spec with fn which may change any of its
+// top-level fields. Fields not changed by fn are sent back as is.
+func (e *apiEngine) updateSpec(ctx context.Context, fn func(spec map[string]json.RawMessage) error) error {
+	details, err := e.client.SwarmInspect(ctx)
+	if err != nil {
+		return err
+	}
+
+	var spec map[string]json.RawMessage
+	if err := json.Unmarshal(details.Spec, &spec); err != nil {
+		return fmt.Errorf("error decoding swarm spec: %w", err)
+	}
+	if spec == nil {
+		spec = make(map[string]json.RawMessage)
+	}
+
+	if err := fn(spec); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return fmt.Errorf("error encoding swarm spec: %w", err)
+	}
+
+	if err := e.client.SwarmUpdate(ctx, details.Version.Index, data, nil); err != nil {
+		return fmt.Errorf("error updating swarm: %w", err)
+	}
+
+	return nil
+}
+
+// setSpecField sets a field of a section (e.g: "Dispatcher") of the spec
+// leaving the section's other fields as they are
+func setSpecField(spec map[string]json.RawMessage, section, field string, value interface{}) error {
+	var fields map[string]json.RawMessage
+	if data, ok := spec[section]; ok {
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return fmt.Errorf("error decoding %s: %w", section, err)
+		}
+	}
+	if fields == nil {
+		fields = make(map[string]json.RawMessage)
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("error encoding %s.%s: %w", section, field, err)
+	}
+	fields[field] = data
+
+	if spec[section], err = json.Marshal(fields); err != nil {
+		return fmt.Errorf("error encoding %s: %w", section, err)
+	}
+
+	return nil
+}
+
+// updateCAConfig is like updateSpec but fn changes the fields of the spec's
+// CA config
+func (e *apiEngine) updateCAConfig(ctx context.Context, fn func(ca map[string]json.RawMessage) error) error {
+	return e.updateSpec(ctx, func(spec map[string]json.RawMessage) error {
+		var ca map[string]json.RawMessage
+		if data, ok := spec["CAConfig"]; ok {
+			if err := json.Unmarshal(data, &ca); err != nil {
+				return fmt.Errorf("error decoding CA config: %w", err)
+			}
+		}
+		if ca == nil {
+			ca = make(map[string]json.RawMessage)
+		}
+
+		if err := fn(ca); err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(ca)
+		if err != nil {
+			return fmt.Errorf("error encoding CA config: %w", err)
+		}
+		spec["CAConfig"] = data
+
+		return nil
+	})
+}
+
+func (e *apiEngine) SwarmAutolock(ctx context.Context, enable bool) error {
+	return e.updateSpec(ctx, func(spec map[string]json.RawMessage) error {
+		data, err := json.Marshal(EncryptionConfig{AutoLockManagers: enable})
+		if err != nil {
+			return fmt.Errorf("error encoding encryption config: %w", err)
+		}
+		spec["EncryptionConfig"] = data
+		return nil
+	})
+}
+
+func (e *apiEngine) UnlockKey(ctx context.Context) (string, error) {
+	return e.client.SwarmUnlockKey(ctx)
+}
+
+func (e *apiEngine) RotateUnlockKey(ctx context.Context) (string, error) {
+	details, err := e.client.SwarmInspect(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{"rotateManagerUnlockKey": []string{"true"}}
+	if err := e.client.SwarmUpdate(ctx, details.Version.Index, details.Spec, query); err != nil {
+		return "", fmt.Errorf("error updating swarm: %w", err)
+	}
+
+	return e.client.SwarmUnlockKey(ctx)
+}
+
+func (e *apiEngine) SwarmUnlock(ctx context.Context, key string) error {
+	return e.client.SwarmUnlock(ctx, key)
+}
+
+func (e *apiEngine) RootCA(ctx context.Context) (string, error) {
+	details, err := e.client.SwarmInspect(ctx)
+	if err != nil {
+		return "", err
+	}
+	return details.TLSInfo.TrustRoot, nil
+}
+
+// RotateCA forces the rotation of the root CA. Unlike `docker swarm ca
+// --rotate` the API does not wait for the rotation to complete so the root CA
+// returned may still be the previous one.
+func (e *apiEngine) RotateCA(ctx context.Context) (string, error) {
+	err := e.updateCAConfig(ctx, func(ca map[string]json.RawMessage) error {
+		var forceRotate uint64
+		if data, ok := ca["ForceRotate"]; ok {
+			if err := json.Unmarshal(data, &forceRotate); err != nil {
+				return fmt.Errorf("error decoding CA config: %w", err)
+			}
+		}
+		ca["ForceRotate"] = json.RawMessage(strconv.FormatUint(forceRotate+1, 10))
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return e.RootCA(ctx)
+}
+
+func (e *apiEngine) SetExternalCA(ctx context.Context, externalCA ExternalCA) error {
+	return e.updateCAConfig(ctx, func(ca map[string]json.RawMessage) error {
+		data, err := json.Marshal([]ExternalCA{externalCA})
+		if err != nil {
+			return fmt.Errorf("error encoding external CA: %w", err)
+		}
+		ca["ExternalCAs"] = data
+		return nil
+	})
+}
+
+func (e *apiEngine) SetCertExpiry(ctx context.Context, expiry time.Duration) error {
+	return e.updateSpec(ctx, func(spec map[string]json.RawMessage) error {
+		return setSpecField(spec, "CAConfig", "NodeCertExpiry", expiry)
+	})
+}
+
+func (e *apiEngine) Probe(ctx context.Context, network, host string, port int) (probeResult, error) {
+	return 0, errProbeUnsupported
+}
@@ -0,0 +1,257 @@
+/*
+	go-swarm is a Go library and ccommand-line tool for managing the creation
+	and maintenance of Docker Swarm cluster.
+
+    Copyright (C) 2021 Sovereign Cloud Australia Pty Ltd
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as published
+    by the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package swarm
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testAPIServer serves handler on a UNIX socket and returns an APIClient
+// connected to it.
+func testAPIServer(t *testing.T, handler http.Handler) *APIClient {
+	sockPath := filepath.Join(t.TempDir(), "docker.sock")
+
+	l, err := net.Listen("unix", sockPath)
+	require.NoError(t, err)
+
+	server := &http.Server{Handler: handler}
+	go server.Serve(l)
+	t.Cleanup(func() { server.Close() })
+
+	return NewUnixAPIClient(sockPath, time.Second*5)
+}
+
+// TestAPIClientNodeList tests listing nodes with the Docker Engine API and
+// converting them to the same form as `docker node ls`.
+func TestAPIClientNodeList(t *testing.T) {
+	assert := assert.New(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/nodes", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{
+			"ID": "abc",
+			"Version": {"Index": 42},
+			"Spec": {"Role": "manager", "Availability": "active"},
+			"Description": {"Hostname": "dm1", "Engine": {"EngineVersion": "20.10.12"}},
+			"Status": {"State": "ready", "Addr": "172.16.0.1"},
+			"ManagerStatus": {"Leader": true, "Reachability": "reachable", "Addr": "172.16.0.1:2377"}
+		}]`))
+	})
+
+	e := &apiEngine{client: testAPIServer(t, mux)}
+
+	nodes, err := e.NodeList(context.Background())
+	assert.NoError(err)
+	assert.Equal([]NodeStatus{{
+		ID:            "abc",
+		Hostname:      "dm1",
+		EngineVersion: "20.10.12",
+		Availability:  "Active",
+		ManagerStatus: "Leader",
+		Status:        "Ready",
+	}}, nodes)
+}
+
+// TestAPIClientNodeUpdate tests updating a node's spec with the Docker Engine
+// API preserves existing labels and uses the node's current version.
+func TestAPIClientNodeUpdate(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		version string
+		spec    NodeSpec
+	)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/nodes/dw1", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{
+			"ID": "abc",
+			"Version": {"Index": 42},
+			"Spec": {"Role": "worker", "Availability": "active", "Labels": {"zone": "a", "old": ""}}
+		}`))
+	})
+	mux.HandleFunc("/nodes/abc/update", func(w http.ResponseWriter, r *http.Request) {
+		version = r.URL.Query().Get("version")
+		json.NewDecoder(r.Body).Decode(&spec)
+	})
+
+	e := &apiEngine{client: testAPIServer(t, mux)}
+
+	update := NodeUpdate{Availability: "drain", AddLabels: []string{"role=db"}, RemoveLabels: []string{"old"}}
+	assert.NoError(e.NodeUpdate(context.Background(), "dw1", update))
+	assert.Equal("42", version)
+	assert.Equal(NodeSpec{
+		Role:         "worker",
+		Availability: "drain",
+		Labels:       map[string]string{"zone": "a", "role": "db"},
+	}, spec)
+}
+
+// TestAPIClientError tests error responses from the Docker Engine API.
+func TestAPIClientError(t *testing.T) {
+	assert := assert.New(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/swarm", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"message": "This node is not a swarm manager."}`))
+	})
+
+	c := testAPIServer(t, mux)
+
+	_, err := c.SwarmInspect(context.Background())
+	assert.Equal(&APIError{
+		StatusCode: http.StatusServiceUnavailable,
+		Message:    "This node is not a swarm manager.",
+	}, err)
+}
+
+// testTLSServer serves handler over TLS requiring client certificates signed
+// by clientCA and returns the path of the CA of the server's certificate
+func testTLSServer(t *testing.T, handler http.Handler, clientCA *x509.Certificate) (*httptest.Server, string) {
+	server := httptest.NewUnstartedServer(handler)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(clientCA)
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: pool}
+
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	writePEM(t, caFile, "CERTIFICATE", server.Certificate().Raw)
+
+	return server, caFile
+}
+
+// testCert writes a new self-signed client certificate and its key to dir
+// and returns the certificate and the paths of the files
+func testCert(t *testing.T, dir string) (*x509.Certificate, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "client"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+
+	return cert, certFile, keyFile
+}
+
+func writePEM(t *testing.T, path, blockType string, data []byte) {
+	pemData := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: data})
+	require.NoError(t, ioutil.WriteFile(path, pemData, 0600))
+}
+
+// TestTLSSwitcher tests switching to a node whose Docker Engine API is
+// protected by mutual TLS.
+func TestTLSSwitcher(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/_ping", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	})
+
+	cert, certFile, keyFile := testCert(t, t.TempDir())
+	server, caFile := testTLSServer(t, mux, cert)
+
+	addr := server.Listener.Addr().String()
+
+	s, err := NewTLSSwitcher("tcp://"+addr, caFile, certFile, keyFile, time.Second*5)
+	require.NoError(err)
+	assert.NotNil(s.(APISwitcher).Client())
+
+	// Switching keeps the port of the initial address
+	host, _, err := net.SplitHostPort(addr)
+	require.NoError(err)
+	assert.NoError(s.Switch(context.Background(), host))
+	assert.Equal("tcp://"+addr, s.String())
+}
+
+// TestTLSSwitcherUntrustedCA tests the server's certificate is verified
+// with the given CA.
+func TestTLSSwitcherUntrustedCA(t *testing.T) {
+	require := require.New(t)
+
+	cert, certFile, keyFile := testCert(t, t.TempDir())
+	server, _ := testTLSServer(t, http.NotFoundHandler(), cert)
+
+	// The client's own certificate did not sign the server's certificate
+	addr := "tcp://" + server.Listener.Addr().String()
+	_, err := NewTLSSwitcher(addr, certFile, certFile, keyFile, time.Second*5)
+	require.Error(err)
+	require.Contains(err.Error(), "certificate")
+}
+
+// TestTLSSwitcherMissingKey tests a missing key file is reported.
+func TestTLSSwitcherMissingKey(t *testing.T) {
+	require := require.New(t)
+
+	dir := t.TempDir()
+	cert, certFile, _ := testCert(t, dir)
+	server, caFile := testTLSServer(t, http.NotFoundHandler(), cert)
+
+	addr := "tcp://" + server.Listener.Addr().String()
+	_, err := NewTLSSwitcher(addr, caFile, certFile, filepath.Join(dir, "missing.pem"), time.Second*5)
+	require.Error(err)
+	require.Contains(err.Error(), "error loading tls cert")
+}
@@ -0,0 +1,162 @@
+/*
+	go-swarm is a Go library and ccommand-line tool for managing the creation
+	and maintenance of Docker Swarm cluster.
+
+    Copyright (C) 2021 Sovereign Cloud Australia Pty Ltd
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as published
+    by the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package swarm
+
+import (
+	"context"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// EnableAutolock enables autolock on the cluster so that managers must be
+// unlocked with the returned unlock key after they are restarted. The Raft
+// logs of the managers are encrypted at rest with the key.
+func (m *Manager) EnableAutolock() (string, error) {
+	return m.EnableAutolockContext(context.Background())
+}
+
+// EnableAutolockContext is like EnableAutolock but the operation is cancelled
+// when ctx is done.
+func (m *Manager) EnableAutolockContext(ctx context.Context) (string, error) {
+	if err := m.ensureManager(ctx); err != nil {
+		return "", fmt.Errorf("error connecting to manager node: %w", err)
+	}
+
+	if err := m.engine().SwarmAutolock(ctx, true); err != nil {
+		return "", fmt.Errorf("error enabling autolock: %w", err)
+	}
+
+	key, err := m.engine().UnlockKey(ctx)
+	if err != nil {
+		return "", fmt.Errorf("error getting unlock key: %w", err)
+	}
+
+	log.Info("Successfully enabled autolock")
+
+	return key, nil
+}
+
+// DisableAutolock disables autolock on the cluster so that managers no longer
+// need to be unlocked after they are restarted
+func (m *Manager) DisableAutolock() error {
+	return m.DisableAutolockContext(context.Background())
+}
+
+// DisableAutolockContext is like DisableAutolock but the operation is
+// cancelled when ctx is done.
+func (m *Manager) DisableAutolockContext(ctx context.Context) error {
+	if err := m.ensureManager(ctx); err != nil {
+		return fmt.Errorf("error connecting to manager node: %w", err)
+	}
+
+	if err := m.engine().SwarmAutolock(ctx, false); err != nil {
+		return fmt.Errorf("error disabling autolock: %w", err)
+	}
+
+	log.Info("Successfully disabled autolock")
+
+	return nil
+}
+
+// UnlockKey returns the current unlock key of an autolocked cluster
+func (m *Manager) UnlockKey() (string, error) {
+	return m.UnlockKeyContext(context.Background())
+}
+
+// UnlockKeyContext is like UnlockKey but the operation is cancelled when ctx
+// is done.
+func (m *Manager) UnlockKeyContext(ctx context.Context) (string, error) {
+	if err := m.ensureManager(ctx); err != nil {
+		return "", fmt.Errorf("error connecting to manager node: %w", err)
+	}
+
+	key, err := m.engine().UnlockKey(ctx)
+	if err != nil {
+		return "", fmt.Errorf("error getting unlock key: %w", err)
+	}
+
+	return key, nil
+}
+
+// RotateUnlockKey rotates the unlock key of an autolocked cluster and returns
+// the new key. Managers that have not yet seen the new key may still need the
+// previous key to be unlocked so it should be kept for a while.
+func (m *Manager) RotateUnlockKey() (string, error) {
+	return m.RotateUnlockKeyContext(context.Background())
+}
+
+// RotateUnlockKeyContext is like RotateUnlockKey but the operation is
+// cancelled when ctx is done.
+func (m *Manager) RotateUnlockKeyContext(ctx context.Context) (string, error) {
+	if err := m.ensureManager(ctx); err != nil {
+		return "", fmt.Errorf("error connecting to manager node: %w", err)
+	}
+
+	key, err := m.engine().RotateUnlockKey(ctx)
+	if err != nil {
+		return "", fmt.Errorf("error rotating unlock key: %w", err)
+	}
+
+	log.Info("Successfully rotated unlock key")
+
+	return key, nil
+}
+
+// UnlockManagers unlocks every manager in the given VM nodes that is locked
+// (e.g: after it was restarted) with the given unlock key. Managers that are
+// not locked are left as they are.
+func (m *Manager) UnlockManagers(vms VMNodes, key string) error {
+	return m.UnlockManagersContext(context.Background(), vms, key)
+}
+
+// UnlockManagersContext is like UnlockManagers but the operation is
+// cancelled when ctx is done.
+func (m *Manager) UnlockManagersContext(ctx context.Context, vms VMNodes, key string) error {
+	managers := vms.FilterByTag(RoleTag, ManagerRole)
+	if len(managers) == 0 {
+		return fmt.Errorf("error no manager nodes found")
+	}
+
+	return m.forEach(managers, func(n *Manager, vm VMNode) error {
+		if err := n.SwitchNodeContext(ctx, vm.PublicAddress); err != nil {
+			return fmt.Errorf("error switching to node %s: %w", vm.Hostname, err)
+		}
+
+		node, err := n.GetInfoContext(ctx)
+		if err != nil {
+			return fmt.Errorf("error getting node info of %s: %w", vm.Hostname, err)
+		}
+
+		if node.Swarm.LocalNodeState != "locked" {
+			log.Infof("Manager %s is not locked", vm.Hostname)
+			return nil
+		}
+
+		if err := n.engine().SwarmUnlock(ctx, key); err != nil {
+			return fmt.Errorf("error unlocking manager %s: %w", vm.Hostname, err)
+		}
+
+		log.Infof("Successfully unlocked %s", vm.Hostname)
+
+		return nil
+	})
+}
@@ -0,0 +1,252 @@
+/*
+	go-swarm is a Go library and ccommand-line tool for managing the creation
+	and maintenance of Docker Swarm cluster.
+
+    Copyright (C) 2021 Sovereign Cloud Australia Pty Ltd
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as published
+    by the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package swarm
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// NodeCertificatePath is the path of a node's TLS certificate which is read
+// (e.g: over SSH) to report when it expires. It is read with `sudo -n` so the
+// user commands are run as must be allowed to run sudo without a password.
+const NodeCertificatePath = "/var/lib/docker/swarm/certificates/swarm-node.crt"
+
+const nodeCertCommand = `sudo -n cat ` + NodeCertificatePath
+
+// CertExpiry is when the TLS certificate of a node in the cluster expires
+type CertExpiry struct {
+	NodeID   string
+	Hostname string
+	NotAfter time.Time
+
+	// Err is the error reading the node's certificate (if any) in which case
+	// NotAfter is not known
+	Err error
+}
+
+// ExpiresWithin returns true if the certificate expires within d from now
+// (or has already expired). Certificates that could not be read are assumed
+// to expire.
+func (c CertExpiry) ExpiresWithin(d time.Duration) bool {
+	return c.Err != nil || time.Until(c.NotAfter) < d
+}
+
+// RootCA returns the cluster's root CA certificate (PEM encoded)
+func (m *Manager) RootCA() (string, error) {
+	return m.RootCAContext(context.Background())
+}
+
+// RootCAContext is like RootCA but the operation is cancelled when ctx is
+// done.
+func (m *Manager) RootCAContext(ctx context.Context) (string, error) {
+	if err := m.ensureManager(ctx); err != nil {
+		return "", fmt.Errorf("error connecting to manager node: %w", err)
+	}
+
+	cert, err := m.engine().RootCA(ctx)
+	if err != nil {
+		return "", fmt.Errorf("error getting root CA: %w", err)
+	}
+
+	return cert, nil
+}
+
+// RotateCA rotates the cluster's root CA and returns the new root CA
+// certificate (PEM encoded). Every node is issued a new certificate signed by
+// the new root CA.
+func (m *Manager) RotateCA() (string, error) {
+	return m.RotateCAContext(context.Background())
+}
+
+// RotateCAContext is like RotateCA but the operation is cancelled when ctx is
+// done.
+func (m *Manager) RotateCAContext(ctx context.Context) (string, error) {
+	if err := m.ensureManager(ctx); err != nil {
+		return "", fmt.Errorf("error connecting to manager node: %w", err)
+	}
+
+	cert, err := m.engine().RotateCA(ctx)
+	if err != nil {
+		return "", fmt.Errorf("error rotating root CA: %w", err)
+	}
+
+	log.Info("Successfully rotated root CA")
+
+	return cert, nil
+}
+
+// SetExternalCA configures the cluster to have node certificates issued by
+// the given external CA
+func (m *Manager) SetExternalCA(ca ExternalCA) error {
+	return m.SetExternalCAContext(context.Background(), ca)
+}
+
+// SetExternalCAContext is like SetExternalCA but the operation is cancelled
+// when ctx is done.
+func (m *Manager) SetExternalCAContext(ctx context.Context, ca ExternalCA) error {
+	if ca.Protocol == "" || ca.URL == "" {
+		return fmt.Errorf("error external CA requires a protocol and url")
+	}
+
+	if err := m.ensureManager(ctx); err != nil {
+		return fmt.Errorf("error connecting to manager node: %w", err)
+	}
+
+	if err := m.engine().SetExternalCA(ctx, ca); err != nil {
+		return fmt.Errorf("error setting external CA: %w", err)
+	}
+
+	log.Infof("Successfully set external CA %s", ca.URL)
+
+	return nil
+}
+
+// SetCertExpiry configures how long node certificates are valid for. Nodes
+// renew their certificates before they expire.
+func (m *Manager) SetCertExpiry(expiry time.Duration) error {
+	return m.SetCertExpiryContext(context.Background(), expiry)
+}
+
+// SetCertExpiryContext is like SetCertExpiry but the operation is cancelled
+// when ctx is done.
+func (m *Manager) SetCertExpiryContext(ctx context.Context, expiry time.Duration) error {
+	if expiry <= 0 {
+		return fmt.Errorf("error invalid certificate expiry: %s", expiry)
+	}
+
+	if err := m.ensureManager(ctx); err != nil {
+		return fmt.Errorf("error connecting to manager node: %w", err)
+	}
+
+	if err := m.engine().SetCertExpiry(ctx, expiry); err != nil {
+		return fmt.Errorf("error setting certificate expiry: %w", err)
+	}
+
+	log.Infof("Successfully set certificate expiry to %s", expiry)
+
+	return nil
+}
+
+// GetCertExpiry returns when the TLS certificate of every node in the
+// cluster that is not down expires. Each node's certificate is read on the
+// node itself via the current manager. Nodes whose certificate could not be
+// read have Err set. Reading certificates requires a Switcher with a Runner
+// (e.g: SSH) and is not supported when talking to the Docker Engine API.
+func (m *Manager) GetCertExpiry() ([]CertExpiry, error) {
+	return m.GetCertExpiryContext(context.Background())
+}
+
+// GetCertExpiryContext is like GetCertExpiry but the operation is cancelled
+// when ctx is done.
+func (m *Manager) GetCertExpiryContext(ctx context.Context) ([]CertExpiry, error) {
+	if err := m.ensureManager(ctx); err != nil {
+		return nil, fmt.Errorf("error connecting to manager node: %w", err)
+	}
+
+	if m.Runner() == nil {
+		return nil, fmt.Errorf("error reading node certificates requires a switcher with a runner (e.g: SSH)")
+	}
+
+	nodes, err := m.GetNodesContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error getting nodes: %w", err)
+	}
+
+	var expiries []CertExpiry
+	for _, node := range nodes {
+		if strings.EqualFold(node.Status, "down") {
+			continue
+		}
+
+		expiry := CertExpiry{NodeID: node.ID, Hostname: node.Hostname}
+		expiry.NotAfter, expiry.Err = m.nodeCertExpiry(ctx, node.ID)
+		if expiry.Err != nil {
+			if ctx.Err() != nil {
+				return nil, expiry.Err
+			}
+			log.WithError(expiry.Err).Warnf("error getting certificate expiry of %s", node.Hostname)
+		}
+
+		expiries = append(expiries, expiry)
+	}
+
+	return expiries, nil
+}
+
+// nodeCertExpiry returns when the TLS certificate of the node given by its
+// ID or hostname expires
+func (m *Manager) nodeCertExpiry(ctx context.Context, node string) (time.Time, error) {
+	details, err := m.GetNodeContext(ctx, node)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error getting node details: %w", err)
+	}
+
+	n, err := m.fork()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if err := n.SwitchNodeViaContext(ctx, details.Addr()); err != nil {
+		return time.Time{}, fmt.Errorf("error switching to node %s: %w", details.Addr(), err)
+	}
+
+	data, err := n.nodeCertificate(ctx)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return time.Time{}, fmt.Errorf("error decoding node certificate: no PEM data found")
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error parsing node certificate: %w", err)
+	}
+
+	return cert.NotAfter, nil
+}
+
+// nodeCertificate reads the current node's TLS certificate (PEM encoded)
+func (m *Manager) nodeCertificate(ctx context.Context) ([]byte, error) {
+	out, err := m.runCmd(ctx, nodeCertCommand)
+	if err != nil {
+		return nil, fmt.Errorf(
+			"error reading node certificate %s (passwordless sudo is required): %w",
+			NodeCertificatePath, err,
+		)
+	}
+
+	data, err := ioutil.ReadAll(out)
+	if err != nil {
+		return nil, fmt.Errorf("error reading node certificate command output: %w", err)
+	}
+
+	return data, nil
+}
@@ -0,0 +1,336 @@
+/*
+	go-swarm is a Go library and ccommand-line tool for managing the creation
+	and maintenance of Docker Swarm cluster.
+
+    Copyright (C) 2021 Sovereign Cloud Australia Pty Ltd
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as published
+    by the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package swarm
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// PendingTask is a task of a node being drained that no remaining node could
+// run, leaving it pending
+type PendingTask struct {
+	// Node is the node being drained the task is running on
+	Node string
+
+	// Task is the name of the task
+	Task string
+
+	// Service is the name (or ID) of the service the task belongs to
+	Service string
+
+	// Reason is why the task cannot be rescheduled
+	Reason string
+}
+
+// CapacityError is returned by DrainNodes when the remaining active nodes
+// do not have the capacity or do not satisfy the placement constraints to
+// run the tasks of the nodes being drained
+type CapacityError struct {
+	Pending []PendingTask
+}
+
+func (e *CapacityError) Error() string {
+	var tasks []string
+	for _, task := range e.Pending {
+		tasks = append(tasks, fmt.Sprintf("%s on %s: %s", task.Task, task.Node, task.Reason))
+	}
+
+	return fmt.Sprintf(
+		"error %d task(s) would be left pending: %s",
+		len(e.Pending), strings.Join(tasks, "; "),
+	)
+}
+
+// candidate is a node that tasks could be rescheduled on and the resources
+// it has left
+type candidate struct {
+	details NodeDetails
+	free    Resources
+}
+
+// fits returns true if r fits in the node's remaining resources (tasks that
+// reserve nothing fit on any node)
+func (c *candidate) fits(r Resources) bool {
+	return (r.NanoCPUs == 0 || r.NanoCPUs <= c.free.NanoCPUs) &&
+		(r.MemoryBytes == 0 || r.MemoryBytes <= c.free.MemoryBytes)
+}
+
+// reserve subtracts r from the node's remaining resources
+func (c *candidate) reserve(r Resources) {
+	c.free.NanoCPUs -= r.NanoCPUs
+	c.free.MemoryBytes -= r.MemoryBytes
+}
+
+// rescheduled is a task of a node being drained that has to be rescheduled
+type rescheduled struct {
+	node    string
+	task    TaskStatus
+	service Service
+}
+
+// serviceName returns the name (or ID) of the service the task belongs to
+// from the task's name in the form `<service>.<slot>` (or `<service>.<node>`
+// for global services)
+func (t TaskStatus) serviceName() string {
+	name := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(t.Name), `\_`))
+	if i := strings.LastIndex(name, "."); i > 0 {
+		return name[:i]
+	}
+	return name
+}
+
+// reservations returns the resources reserved by the service's tasks
+func (s Service) reservations() Resources {
+	if r := s.Spec.TaskTemplate.Resources; r != nil && r.Reservations != nil {
+		return *r.Reservations
+	}
+	return Resources{}
+}
+
+// constraints returns the placement constraints of the service's tasks
+func (s Service) constraints() []string {
+	if p := s.Spec.TaskTemplate.Placement; p != nil {
+		return p.Constraints
+	}
+	return nil
+}
+
+// matchConstraint returns true if node satisfies the placement constraint
+// expr (e.g: `node.role==manager` or `node.labels.zone!=a`)
+func matchConstraint(expr string, node NodeDetails) (bool, error) {
+	var (
+		key, value string
+		equal      bool
+	)
+
+	if i := strings.Index(expr, "!="); i >= 0 {
+		key, value = expr[:i], expr[i+2:]
+	} else if i := strings.Index(expr, "=="); i >= 0 {
+		key, value, equal = expr[:i], expr[i+2:], true
+	} else {
+		return false, fmt.Errorf("error invalid constraint %q", expr)
+	}
+
+	// Label names are case sensitive unlike the rest of the key
+	name := strings.TrimSpace(key)
+	key = strings.ToLower(name)
+	value = strings.TrimSpace(value)
+
+	var (
+		actual string
+		found  = true
+	)
+
+	switch {
+	case key == "node.id":
+		actual = node.ID
+	case key == "node.hostname":
+		actual = node.Description.Hostname
+	case key == "node.role":
+		actual = node.Spec.Role
+	case key == "node.platform.os":
+		actual = node.Description.Platform.OS
+	case key == "node.platform.arch":
+		actual = node.Description.Platform.Architecture
+	case key == "node.ip":
+		if _, subnet, err := net.ParseCIDR(value); err == nil {
+			ip := net.ParseIP(node.Addr())
+			return (ip != nil && subnet.Contains(ip)) == equal, nil
+		}
+		actual = node.Addr()
+	case strings.HasPrefix(key, "node.labels."):
+		actual, found = node.Spec.Labels[name[len("node.labels."):]]
+	case strings.HasPrefix(key, "engine.labels."):
+		actual, found = node.Description.Engine.Labels[name[len("engine.labels."):]]
+	default:
+		return false, fmt.Errorf("error unsupported constraint %q", expr)
+	}
+
+	// A missing label never equals a value but is always not equal to one
+	if !found {
+		return !equal, nil
+	}
+
+	return strings.EqualFold(actual, value) == equal, nil
+}
+
+// matchConstraints returns true if node satisfies all of the constraints
+func matchConstraints(constraints []string, node NodeDetails) (bool, error) {
+	for _, expr := range constraints {
+		ok, err := matchConstraint(expr, node)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// checkCapacity returns a *CapacityError if the tasks running on nodes could
+// not all be rescheduled on the remaining active nodes once nodes are
+// drained, taking the resources reserved by services and their placement
+// constraints into account. Tasks of global services are not rescheduled and
+// are ignored.
+func (m *Manager) checkCapacity(ctx context.Context, nodes []string) error {
+	current, err := m.GetNodesContext(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting current nodes: %w", err)
+	}
+
+	services, err := m.engine().ServiceList(ctx)
+	if err != nil {
+		return fmt.Errorf("error listing services: %w", err)
+	}
+
+	byName := make(map[string]Service)
+	for _, service := range services {
+		byName[service.ID] = service
+		byName[service.Spec.Name] = service
+	}
+
+	draining := make(map[string]bool)
+	for _, node := range nodes {
+		draining[node] = true
+	}
+
+	var (
+		candidates []*candidate
+		tasks      []rescheduled
+	)
+
+	for _, node := range current {
+		details, err := m.engine().NodeInspect(ctx, node.ID)
+		if err != nil {
+			return fmt.Errorf("error inspecting node %s: %w", node.Hostname, err)
+		}
+
+		drain := draining[node.ID] || draining[node.Hostname]
+		eligible := !drain && !details.IsDown() &&
+			strings.EqualFold(details.Spec.Availability, AvailabilityActive)
+
+		if !drain && !eligible {
+			continue
+		}
+
+		running, err := m.engine().NodeTasks(ctx, node.ID)
+		if err != nil {
+			return fmt.Errorf("error getting tasks of node %s: %w", node.Hostname, err)
+		}
+
+		c := &candidate{details: details, free: details.Description.Resources}
+
+		for _, task := range running {
+			if !task.desiredRunning() {
+				continue
+			}
+
+			service, ok := byName[task.serviceName()]
+			if !ok {
+				log.Debugf("ignoring task %s on %s of unknown service", task.Name, node.Hostname)
+				continue
+			}
+
+			if drain {
+				if !service.IsGlobal() {
+					tasks = append(tasks, rescheduled{node: node.Hostname, task: task, service: service})
+				}
+			} else {
+				c.reserve(service.reservations())
+			}
+		}
+
+		if eligible {
+			candidates = append(candidates, c)
+		}
+	}
+
+	// Place the largest tasks first so smaller tasks fill the gaps left
+	sort.SliceStable(tasks, func(i, j int) bool {
+		a, b := tasks[i].service.reservations(), tasks[j].service.reservations()
+		if a.MemoryBytes != b.MemoryBytes {
+			return a.MemoryBytes > b.MemoryBytes
+		}
+		return a.NanoCPUs > b.NanoCPUs
+	})
+
+	var pending []PendingTask
+
+	for _, t := range tasks {
+		reservations := t.service.reservations()
+
+		var (
+			best     *candidate
+			eligible int
+		)
+
+		for _, c := range candidates {
+			ok, err := matchConstraints(t.service.constraints(), c.details)
+			if err != nil {
+				return fmt.Errorf("error checking constraints of service %s: %w", t.service.Spec.Name, err)
+			}
+			if !ok {
+				continue
+			}
+			eligible++
+
+			if !c.fits(reservations) {
+				continue
+			}
+
+			// Spread tasks over the nodes with the most memory left like
+			// the scheduler would
+			if best == nil || c.free.MemoryBytes > best.free.MemoryBytes {
+				best = c
+			}
+		}
+
+		if best != nil {
+			best.reserve(reservations)
+			continue
+		}
+
+		reason := "no active node satisfies its placement constraints"
+		if eligible > 0 {
+			reason = fmt.Sprintf(
+				"insufficient resources on %d eligible node(s) to reserve %.2f CPUs and %d MiB of memory",
+				eligible, float64(reservations.NanoCPUs)/1e9, reservations.MemoryBytes/(1<<20),
+			)
+		}
+
+		pending = append(pending, PendingTask{
+			Node:    t.node,
+			Task:    t.task.Name,
+			Service: t.service.Spec.Name,
+			Reason:  reason,
+		})
+	}
+
+	if len(pending) > 0 {
+		return &CapacityError{Pending: pending}
+	}
+
+	return nil
+}
@@ -0,0 +1,74 @@
+/*
+	go-swarm is a Go library and ccommand-line tool for managing the creation
+	and maintenance of Docker Swarm cluster.
+
+    Copyright (C) 2021 Sovereign Cloud Australia Pty Ltd
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as published
+    by the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package swarm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMatchConstraint tests matching placement constraints against nodes.
+func TestMatchConstraint(t *testing.T) {
+	assert := assert.New(t)
+
+	node := NodeDetails{
+		ID:     "n1",
+		Spec:   NodeSpec{Role: WorkerRole, Labels: map[string]string{"Zone": "a"}},
+		Status: NodeState{Addr: "10.0.0.5"},
+		Description: NodeDescription{
+			Hostname: "dw1",
+			Platform: Platform{Architecture: "x86_64", OS: "linux"},
+			Engine:   EngineDescription{Labels: map[string]string{"ssd": "true"}},
+		},
+	}
+
+	tests := []struct {
+		expr  string
+		match bool
+	}{
+		{"node.id==n1", true},
+		{"node.hostname != dw1", false},
+		{"node.role==manager", false},
+		{"node.role==Worker", true},
+		{"node.platform.os==linux", true},
+		{"node.platform.arch!=aarch64", true},
+		{"node.ip==10.0.0.5", true},
+		{"node.ip==10.0.0.0/24", true},
+		{"node.ip!=10.0.1.0/24", true},
+		{"node.labels.Zone==a", true},
+		{"node.labels.zone==a", false},
+		{"node.labels.rack!=1", true},
+		{"engine.labels.ssd==true", true},
+	}
+
+	for _, test := range tests {
+		match, err := matchConstraint(test.expr, node)
+		assert.NoError(err, test.expr)
+		assert.Equal(test.match, match, test.expr)
+	}
+
+	_, err := matchConstraint("node.role=manager", node)
+	assert.Error(err)
+
+	_, err = matchConstraint("node.unknown==x", node)
+	assert.Error(err)
+}
@@ -0,0 +1,222 @@
+/*
+	go-swarm is a Go library and ccommand-line tool for managing the creation
+	and maintenance of Docker Swarm cluster.
+
+    Copyright (C) 2021 Sovereign Cloud Australia Pty Ltd
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as published
+    by the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package swarm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	stepInit   = "init"
+	stepUpdate = "update"
+)
+
+// step returns the name of a step of a Plan that was applied to a node
+func step(action, hostname string) string {
+	return fmt.Sprintf("%s %s", action, hostname)
+}
+
+// Checkpoint records the progress of applying a Plan so that a failed
+// attempt can be resumed with only the steps that remain.
+type Checkpoint struct {
+	// ClusterID is the ID of the cluster the Plan is being applied to and
+	// is set once a new cluster has been initialized.
+	ClusterID string `json:"cluster_id"`
+
+	// Plan is the Plan being applied.
+	Plan *Plan `json:"plan"`
+
+	// Done are the steps of the Plan that have been completed
+	// (e.g: "init", "join dw1" or "label dw1").
+	Done []string `json:"done"`
+}
+
+// ReadCheckpoint reads a Checkpoint from the file given by path
+func ReadCheckpoint(path string) (*Checkpoint, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading checkpoint %s: %w", path, err)
+	}
+
+	var c Checkpoint
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("error parsing checkpoint %s: %w", path, err)
+	}
+
+	if c.Plan == nil {
+		return nil, fmt.Errorf("error checkpoint %s has no plan", path)
+	}
+
+	return &c, nil
+}
+
+// Remaining returns a Plan of the steps of the checkpoint's Plan that have
+// not been completed
+func (c *Checkpoint) Remaining() *Plan {
+	done := make(map[string]bool)
+	for _, s := range c.Done {
+		done[s] = true
+	}
+
+	plan := &Plan{
+		ClusterID: c.Plan.ClusterID,
+		Init:      c.Plan.Init && !done[stepInit],
+		Leader:    c.Plan.Leader,
+	}
+	if !plan.Init && c.ClusterID != "" {
+		plan.ClusterID = c.ClusterID
+	}
+
+	if !done[stepUpdate] {
+		plan.Update = c.Plan.Update
+	}
+	plan.Swarm = c.Plan.Swarm
+
+	for _, vm := range c.Plan.Managers {
+		if !done[step("join", vm.Hostname)] {
+			plan.Managers = append(plan.Managers, vm)
+		}
+	}
+	for _, vm := range c.Plan.Workers {
+		if !done[step("join", vm.Hostname)] {
+			plan.Workers = append(plan.Workers, vm)
+		}
+	}
+	for _, hostname := range c.Plan.Promote {
+		if !done[step("promote", hostname)] {
+			plan.Promote = append(plan.Promote, hostname)
+		}
+	}
+	for _, label := range c.Plan.Labels {
+		if !done[step("label", label.Node.Hostname)] {
+			plan.Labels = append(plan.Labels, label)
+		}
+	}
+	for _, hostname := range c.Plan.Demote {
+		if !done[step("demote", hostname)] {
+			plan.Demote = append(plan.Demote, hostname)
+		}
+	}
+	for _, hostname := range c.Plan.Remove {
+		if !done[step("remove", hostname)] {
+			plan.Remove = append(plan.Remove, hostname)
+		}
+	}
+
+	return plan
+}
+
+// checkpointer saves a Checkpoint to a file as steps are completed. A nil
+// checkpointer (no checkpoint file configured) does nothing. Failing to save
+// a checkpoint is logged but does not fail applying the plan.
+type checkpointer struct {
+	sync.Mutex
+
+	path       string
+	checkpoint Checkpoint
+}
+
+func newCheckpointer(path string, plan *Plan) *checkpointer {
+	if path == "" {
+		return nil
+	}
+
+	c := &checkpointer{path: path, checkpoint: Checkpoint{ClusterID: plan.ClusterID, Plan: plan}}
+	c.save()
+
+	return c
+}
+
+// init records that a new cluster was initialized with the given ID
+func (c *checkpointer) init(clusterID string) {
+	if c == nil {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.checkpoint.ClusterID = clusterID
+	c.checkpoint.Done = append(c.checkpoint.Done, stepInit)
+	c.save()
+}
+
+// done records that the step was completed
+func (c *checkpointer) done(step string) {
+	if c == nil {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.checkpoint.Done = append(c.checkpoint.Done, step)
+	c.save()
+}
+
+// remove removes the checkpoint file once the plan is fully applied
+func (c *checkpointer) remove() {
+	if c == nil {
+		return
+	}
+
+	if err := os.Remove(c.path); err != nil && !os.IsNotExist(err) {
+		log.WithError(err).Warnf("error removing checkpoint %s", c.path)
+	}
+}
+
+// save writes the checkpoint to a temporary file which is then renamed so
+// that the checkpoint file is never left partially written
+func (c *checkpointer) save() {
+	data, err := json.MarshalIndent(c.checkpoint, "", "  ")
+	if err != nil {
+		log.WithError(err).Warn("error encoding checkpoint")
+		return
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(c.path), filepath.Base(c.path)+".*")
+	if err != nil {
+		log.WithError(err).Warnf("error saving checkpoint %s", c.path)
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		log.WithError(err).Warnf("error saving checkpoint %s", c.path)
+		return
+	}
+	if err := tmp.Close(); err != nil {
+		log.WithError(err).Warnf("error saving checkpoint %s", c.path)
+		return
+	}
+
+	if err := os.Rename(tmp.Name(), c.path); err != nil {
+		log.WithError(err).Warnf("error saving checkpoint %s", c.path)
+	}
+}
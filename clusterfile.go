@@ -25,6 +25,8 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
+	"strconv"
 )
 
 const (
@@ -44,16 +46,29 @@ const (
 	// `key1=value1&key2=value2&key3&key4`
 	// (This uses the URL Query String format).
 	LabelsTag = "labels"
+
+	// DefaultSwarmPort is the default port used for cluster management
+	// traffic between nodes
+	DefaultSwarmPort = 2377
 )
 
 // VMNode represents a single VM Node and at a bare minimum contains the
 // node's hostname, private and public ip addresses as well as a list of tags
 // used to label the nodes for different purposes such as Manager ndoes.
+//
+// Cluster management traffic uses the private address unless an advertise
+// and/or listen address is given. Overlay network traffic uses the advertise
+// address unless a data path address is given.
 type VMNode struct {
 	Hostname       string            `json:"hostname"`
 	PublicAddress  string            `json:"public_address"`
 	PrivateAddress string            `json:"private_address"`
 	Tags           map[string]string `json:"tags"`
+
+	DataPathAddress  string `json:"data_path_address,omitempty"`
+	AdvertiseAddress string `json:"advertise_address,omitempty"`
+	ListenAddress    string `json:"listen_address,omitempty"`
+	SwarmPort        int    `json:"swarm_port,omitempty"`
 }
 
 func (vm VMNode) Stirng() string {
@@ -63,6 +78,59 @@ func (vm VMNode) Stirng() string {
 	)
 }
 
+// AdvertiseAddr returns the address the node advertises to other nodes for
+// cluster management traffic
+func (vm VMNode) AdvertiseAddr() string {
+	if vm.AdvertiseAddress != "" {
+		return vm.withPort(vm.AdvertiseAddress)
+	}
+	return vm.withPort(vm.PrivateAddress)
+}
+
+// ListenAddr returns the address the node listens on for cluster management
+// traffic
+func (vm VMNode) ListenAddr() string {
+	if vm.ListenAddress != "" {
+		return vm.withPort(vm.ListenAddress)
+	}
+	return vm.withPort(vm.PrivateAddress)
+}
+
+// RemoteAddr returns the address (host:port) other nodes use to join the
+// cluster through this node
+func (vm VMNode) RemoteAddr() string {
+	host := vm.AdvertiseAddress
+	if host == "" {
+		host = vm.PrivateAddress
+	}
+	port := vm.SwarmPort
+	if port == 0 {
+		port = DefaultSwarmPort
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// withPort adds the node's swarm port (if any) to addr
+func (vm VMNode) withPort(addr string) string {
+	if vm.SwarmPort == 0 {
+		return addr
+	}
+	return net.JoinHostPort(addr, strconv.Itoa(vm.SwarmPort))
+}
+
+func (vm VMNode) validate() error {
+	if vm.AdvertiseAddress != "" && net.ParseIP(vm.AdvertiseAddress) == nil {
+		return fmt.Errorf("invalid advertise address %q", vm.AdvertiseAddress)
+	}
+	if vm.ListenAddress != "" && net.ParseIP(vm.ListenAddress) == nil {
+		return fmt.Errorf("invalid listen address %q", vm.ListenAddress)
+	}
+	if vm.SwarmPort < 0 || vm.SwarmPort > 65535 {
+		return fmt.Errorf("invalid swarm port %d", vm.SwarmPort)
+	}
+	return nil
+}
+
 func (vm VMNode) GetTag(name string) string {
 	return vm.Tags[name]
 }
@@ -86,6 +154,18 @@ func (vms VMNodes) FilterByTag(name, value string) VMNodes {
 	return res
 }
 
+func (vms VMNodes) FilterByHostname(hostname string) VMNodes {
+	var res VMNodes
+
+	for _, vm := range vms {
+		if vm.Hostname == hostname {
+			res = append(res, vm)
+		}
+	}
+
+	return res
+}
+
 func (vms VMNodes) FilterByPrivateAddress(address string) VMNodes {
 	var res VMNodes
 
@@ -120,12 +200,37 @@ type Clusterfile struct {
 	Domain      string `json:"domain"`
 
 	Nodes VMNodes `json:"nodes"`
+
+	// Swarm is the configuration of the cluster (see SwarmConfig)
+	Swarm SwarmConfig `json:"swarm,omitempty"`
+
+	// Engine is the engine version policy of the cluster (see EnginePolicy)
+	Engine EnginePolicy `json:"engine,omitempty"`
+}
+
+// Options returns the Options that apply the swarm configuration and engine
+// version policy of the Clusterfile to a Manager
+func (cf *Clusterfile) Options() []Option {
+	return append(cf.Swarm.Options(), cf.Engine.Options()...)
 }
 
 func (cf *Clusterfile) Validate() error {
+	if err := cf.Swarm.Validate(); err != nil {
+		return fmt.Errorf("invalid swarm config: %w", err)
+	}
+
+	if cf.Engine.MinVersion != "" {
+		if _, _, err := parseVersion(cf.Engine.MinVersion); err != nil {
+			return fmt.Errorf("invalid engine policy: %w", err)
+		}
+	}
+
 	var managers int
 
 	for _, node := range cf.Nodes {
+		if err := node.validate(); err != nil {
+			return fmt.Errorf("invalid node %s: %w", node.Hostname, err)
+		}
 		if node.HasTag(RoleTag, ManagerRole) {
 			managers++
 		}
@@ -23,6 +23,7 @@ package swarm
 import (
 	"bytes"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -122,3 +123,59 @@ func TestFilterByPublicAddress(t *testing.T) {
 	assert.Len(vms, 1)
 	assert.Equal(vms[0].Hostname, "dm1")
 }
+
+// TestReadClusterfileSwarm tests parsing and validating the `swarm` section
+// of a `Clusterfile`.
+func TestReadClusterfileSwarm(t *testing.T) {
+	assert := assert.New(t)
+
+	cf, err := ReadClusterfile(bytes.NewBufferString(`{
+  "swarm": {
+    "default_addr_pool": ["10.20.0.0/16"],
+    "default_addr_pool_mask_length": 26,
+    "task_history_limit": 0,
+    "dispatcher_heartbeat": "10s",
+    "cert_expiry": "720h"
+  }
+}`))
+	assert.Nil(err)
+
+	limit := int64(0)
+	assert.Equal(SwarmConfig{
+		DefaultAddrPool:           []string{"10.20.0.0/16"},
+		DefaultAddrPoolMaskLength: 26,
+		TaskHistoryLimit:          &limit,
+		DispatcherHeartbeat:       Duration(10 * time.Second),
+		CertExpiry:                Duration(720 * time.Hour),
+	}, cf.Swarm)
+	assert.Nil(cf.Swarm.Validate())
+	assert.Len(cf.Swarm.Options(), 5)
+
+	cf.Swarm.DefaultAddrPool = []string{"10.20.0.0"}
+	assert.Error(cf.Swarm.Validate())
+
+	_, err = ReadClusterfile(bytes.NewBufferString(`{"swarm": {"dispatcher_heartbeat": 10}}`))
+	assert.Error(err)
+}
+
+// TestVMNodeAddresses tests the addresses used by a node for cluster
+// management traffic.
+func TestVMNodeAddresses(t *testing.T) {
+	assert := assert.New(t)
+
+	vm := VMNode{Hostname: "dm1", PrivateAddress: "172.16.0.1"}
+	assert.Equal("172.16.0.1", vm.AdvertiseAddr())
+	assert.Equal("172.16.0.1", vm.ListenAddr())
+	assert.Equal("172.16.0.1:2377", vm.RemoteAddr())
+
+	vm.AdvertiseAddress = "172.17.0.1"
+	vm.ListenAddress = "0.0.0.0"
+	vm.SwarmPort = 4377
+	assert.Equal("172.17.0.1:4377", vm.AdvertiseAddr())
+	assert.Equal("0.0.0.0:4377", vm.ListenAddr())
+	assert.Equal("172.17.0.1:4377", vm.RemoteAddr())
+	assert.Nil(vm.validate())
+
+	vm.AdvertiseAddress = "eth1"
+	assert.Error(vm.validate())
+}
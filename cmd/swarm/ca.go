@@ -0,0 +1,92 @@
+/*
+	go-swarm is a Go library and ccommand-line tool for managing the creation
+	and maintenance of Docker Swarm cluster.
+
+    Copyright (C) 2021 Sovereign Cloud Australia Pty Ltd
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as published
+    by the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/aucloud/go-swarm/internal"
+)
+
+func init() {
+	caExternalCmd.Flags().String(
+		"protocol", "cfssl",
+		"Protocol used to talk to the external CA",
+	)
+
+	caCmd.AddCommand(caInspectCmd)
+	caCmd.AddCommand(caRotateCmd)
+	caCmd.AddCommand(caExternalCmd)
+	caCmd.AddCommand(caCertExpiryCmd)
+	RootCmd.AddCommand(caCmd)
+}
+
+var caCmd = &cobra.Command{
+	Use:     "ca",
+	Aliases: []string{},
+	Short:   "Manages the root CA of an existing Swarm Cluster",
+	Long: `These commands manage the root CA of an existing Swarm Cluster that
+issues the TLS certificates of its nodes. Use status --cert-expiry-days to
+find nodes whose certificates expire soon.`,
+}
+
+var caInspectCmd = &cobra.Command{
+	Use:     "inspect",
+	Aliases: []string{},
+	Short:   "Displays the root CA certificate",
+	Args:    cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		internal.CAInspect(cmd.Context(), manager)
+	},
+}
+
+var caRotateCmd = &cobra.Command{
+	Use:     "rotate",
+	Aliases: []string{},
+	Short:   "Rotates the root CA and displays the new root CA certificate",
+	Long: `This command rotates the root CA and waits for every node to be
+issued a new certificate signed by the new root CA.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		internal.CARotate(cmd.Context(), manager)
+	},
+}
+
+var caExternalCmd = &cobra.Command{
+	Use:     "external-ca <url>",
+	Aliases: []string{},
+	Short:   "Sets an external CA to issue node certificates",
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		protocol, _ := cmd.Flags().GetString("protocol")
+		internal.CAExternal(cmd.Context(), manager, protocol, args[0])
+	},
+}
+
+var caCertExpiryCmd = &cobra.Command{
+	Use:     "cert-expiry <duration>",
+	Aliases: []string{},
+	Short:   "Sets how long node certificates are valid for (e.g: 2160h)",
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		internal.CACertExpiry(cmd.Context(), manager, args)
+	},
+}
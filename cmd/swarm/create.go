@@ -21,9 +21,13 @@
 package main
 
 import (
+	"fmt"
+	"os"
+
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
+	"github.com/aucloud/go-swarm"
 	"github.com/aucloud/go-swarm/internal"
 )
 
@@ -32,9 +36,64 @@ func init() {
 		"force-single-manager-cluster", "f", false,
 		"Force creation of single-mnager-node clusters",
 	)
-	viper.BindPFlag("force-single-manager-cluster", createCmd.Flags().Lookup("force"))
+	viper.BindPFlag("force-single-manager-cluster", createCmd.Flags().Lookup("force-single-manager-cluster"))
 	viper.SetDefault("force-single-manager-cluster", false)
 
+	createCmd.Flags().BoolP(
+		"plan", "p", false,
+		"Display the changes that would be made without making them",
+	)
+
+	createCmd.Flags().String(
+		"checkpoint", "",
+		"Save progress to a checkpoint file so a failed create can be resumed",
+	)
+
+	createCmd.Flags().Bool(
+		"resume", false,
+		"Resume a failed create from the checkpoint file given by --checkpoint",
+	)
+
+	createCmd.Flags().Bool(
+		"rollback-on-failure", false,
+		"Make every node that joined leave the cluster again if create fails",
+	)
+
+	createCmd.Flags().Bool(
+		"rotate-tokens-after", false,
+		"Rotate the manager and worker join tokens once all nodes have joined",
+	)
+
+	createCmd.Flags().String(
+		"autolock-key-file", "",
+		"Enable autolock and write the unlock key to the given file",
+	)
+
+	createCmd.Flags().Bool(
+		"preflight", false,
+		"Run the pre-flight checks first and stop if any check fails",
+	)
+
+	createCmd.Flags().Bool(
+		"skip-port-checks", false,
+		"Skip the port checks of the pre-flight checks",
+	)
+
+	createCmd.Flags().Int(
+		"parallel", swarm.DefaultConcurrency,
+		"Number of worker nodes to join and label concurrently",
+	)
+
+	createCmd.Flags().String(
+		"min-engine-version", "",
+		"Minimum engine version of every node (e.g: 20.10.0)",
+	)
+
+	createCmd.Flags().Bool(
+		"enforce-engine-version", false,
+		"Refuse to join nodes that do not comply with the engine version policy",
+	)
+
 	RootCmd.AddCommand(createCmd)
 }
 
@@ -46,10 +105,49 @@ var createCmd = &cobra.Command{
 of nodes to create a new Docker Swarm Cluster. The Clusterfile is expected to
 have information about the region, enviornment, cluaster and a list of nodes
 along with their public and private ip address. Each node must also have a set
-of labels that are used to assign nodes as managers and others as workers.`,
+of labels that are used to assign nodes as managers and others as workers.
+
+With --plan the changes that would be made are displayed without making them.
+
+Creating a cluster is idempotent. If a previous attempt failed part way
+through, the nodes that already joined the cluster are skipped and the
+remaining nodes are joined. With --checkpoint the progress is also saved to a
+checkpoint file and --resume applies exactly the steps that remain.
+
+With --rollback-on-failure every node that joined the cluster is made to
+leave it again (in reverse order) if creating the cluster fails.
+
+With --rotate-tokens-after the join tokens are rotated once all nodes have
+joined so that the tokens used can not be used again.
+
+With --autolock-key-file autolock is enabled when the cluster is initialized
+and the unlock key is written to the given file.
+
+Nodes whose engine version is below --min-engine-version (or the engine
+min_version of the Clusterfile) or more than one minor version apart from the
+managers are reported when they join. With --enforce-engine-version they are
+not joined.
+
+With --preflight the checks of the preflight command are run first and
+nothing is changed if any check fails. Use --skip-port-checks to skip the
+port checks (e.g: with --use-api).
+
+With --parallel up to the given number of workers are joined and labelled
+concurrently. Managers are always joined one at a time.`,
 	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		force := viper.GetBool("force-single-manager-cluster")
-		internal.Create(manager, args, force)
+		plan, _ := cmd.Flags().GetBool("plan")
+
+		var checkpoint string
+		if resume, _ := cmd.Flags().GetBool("resume"); resume {
+			checkpoint, _ = cmd.Flags().GetString("checkpoint")
+			if checkpoint == "" {
+				fmt.Fprintln(os.Stderr, "error --resume requires --checkpoint")
+				os.Exit(-1)
+			}
+		}
+
+		internal.Create(cmd.Context(), manager, args, force, plan, checkpoint)
 	},
 }
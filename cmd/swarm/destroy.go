@@ -0,0 +1,81 @@
+/*
+	go-swarm is a Go library and ccommand-line tool for managing the creation
+	and maintenance of Docker Swarm cluster.
+
+    Copyright (C) 2021 Sovereign Cloud Australia Pty Ltd
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as published
+    by the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/aucloud/go-swarm/internal"
+)
+
+func init() {
+	destroyCmd.Flags().String(
+		"cluster-id", "",
+		"ID of the Swarm Cluster expected to be destroyed (required)",
+	)
+
+	destroyCmd.Flags().Bool(
+		"yes-i-really-mean-it", false,
+		"Confirm that the Swarm Cluster should be destroyed (required)",
+	)
+
+	destroyCmd.Flags().Bool(
+		"remove-services", false,
+		"Remove all services before destroying the Swarm Cluster",
+	)
+
+	RootCmd.AddCommand(destroyCmd)
+}
+
+var destroyCmd = &cobra.Command{
+	Use:     "destroy",
+	Aliases: []string{},
+	Short:   "Destroys an existing Swarm Cluster",
+	Long: `This command uses a Clusterfile that describes the nodes of an
+existing Swarm Cluster and tears the cluster down. Workers are drained, then
+every node leaves the cluster with workers first and the leader last of all.
+With --remove-services all services are removed first.
+
+This cannot be undone. The ID of the cluster (as displayed by info) must be
+given with --cluster-id and must match the live cluster and the destruction
+must be confirmed with --yes-i-really-mean-it.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		clusterID, _ := cmd.Flags().GetString("cluster-id")
+		confirm, _ := cmd.Flags().GetBool("yes-i-really-mean-it")
+		removeServices, _ := cmd.Flags().GetBool("remove-services")
+
+		if clusterID == "" {
+			fmt.Fprintln(os.Stderr, "error --cluster-id is required")
+			os.Exit(-1)
+		}
+
+		if !confirm {
+			fmt.Fprintln(os.Stderr, "error refusing to destroy cluster without --yes-i-really-mean-it")
+			os.Exit(-1)
+		}
+
+		internal.Destroy(cmd.Context(), manager, args, clusterID, removeServices)
+	},
+}
@@ -21,12 +21,66 @@
 package main
 
 import (
+	"fmt"
+	"os"
+
 	"github.com/spf13/cobra"
 
+	"github.com/aucloud/go-swarm"
 	"github.com/aucloud/go-swarm/internal"
 )
 
 func init() {
+	drainCmd.Flags().Duration(
+		"timeout", swarm.DefaultDrainTimeout,
+		"How long to wait for each node to drain",
+	)
+
+	drainCmd.Flags().Duration(
+		"poll-interval", swarm.DefaultPollInterval,
+		"How often to check whether a node has drained",
+	)
+
+	drainCmd.Flags().Int(
+		"parallel", 1,
+		"Number of nodes to drain concurrently",
+	)
+
+	drainCmd.Flags().Bool(
+		"continue-on-error", false,
+		"Keep draining the remaining nodes when a node fails to drain",
+	)
+
+	drainCmd.Flags().Int(
+		"max-drained-percent", 0,
+		"Refuse to drain if more than this percentage of nodes would be unavailable (0 for no limit)",
+	)
+
+	drainCmd.Flags().BoolP(
+		"force", "f", false,
+		"Drain even if the remaining nodes cannot run all of the drained tasks",
+	)
+
+	drainCmd.Flags().Bool(
+		"then-activate", false,
+		"Perform maintenance on each node in turn and make it active again",
+	)
+
+	drainCmd.Flags().String(
+		"exec", "",
+		"Command to run on each drained node with --then-activate",
+	)
+
+	drainCmd.Flags().Bool(
+		"reboot", false,
+		"Reboot each drained node with --then-activate",
+	)
+
+	drainCmd.Flags().Duration(
+		"ready-timeout", swarm.DefaultReadyTimeout,
+		"How long to wait for each node to be ready again with --then-activate",
+	)
+
 	RootCmd.AddCommand(drainCmd)
 }
 
@@ -35,9 +89,46 @@ var drainCmd = &cobra.Command{
 	Aliases: []string{},
 	Short:   "Drains one or more nodes in an existing Swarm Cluster",
 	Long: `This command drains one or more nodes from an existing Swarm Cluster
-and waits for tasks to be shutdown on those nodes before returning.`,
-	Args: cobra.ExactArgs(1),
+and waits for tasks to be shutdown on those nodes before returning.
+
+By default nodes are drained one at a time and draining stops at the first
+node that fails to drain. Use --parallel to drain several nodes at once and
+--continue-on-error to drain the remaining nodes regardless.
+
+Before draining the remaining active nodes are checked to have the resources
+reserved by and satisfy the placement constraints of the tasks that would be
+rescheduled. Use --force to drain anyway, leaving those tasks pending.
+
+With --then-activate the nodes are put into maintenance one at a time: each
+node is drained, the command given by --exec is run on it, it is rebooted if
+--reboot is given and once it is ready again it is made active again. If any
+step fails the node is left drained and no further nodes are drained.`,
+	Args: cobra.MinimumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		internal.Drain(manager, args)
+		var opts swarm.DrainOptions
+		opts.Timeout, _ = cmd.Flags().GetDuration("timeout")
+		opts.PollInterval, _ = cmd.Flags().GetDuration("poll-interval")
+		opts.Concurrency, _ = cmd.Flags().GetInt("parallel")
+		opts.ContinueOnError, _ = cmd.Flags().GetBool("continue-on-error")
+		opts.MaxDrainedPercent, _ = cmd.Flags().GetInt("max-drained-percent")
+		opts.Force, _ = cmd.Flags().GetBool("force")
+
+		var maintenance swarm.MaintenanceOptions
+		maintenance.Exec, _ = cmd.Flags().GetString("exec")
+		maintenance.Reboot, _ = cmd.Flags().GetBool("reboot")
+		maintenance.ReadyTimeout, _ = cmd.Flags().GetDuration("ready-timeout")
+
+		if activate, _ := cmd.Flags().GetBool("then-activate"); activate {
+			maintenance.Drain = opts
+			internal.Maintain(cmd.Context(), manager, args, maintenance)
+			return
+		}
+
+		if maintenance.Exec != "" || maintenance.Reboot {
+			fmt.Fprintln(os.Stderr, "error --exec and --reboot require --then-activate")
+			os.Exit(-1)
+		}
+
+		internal.Drain(cmd.Context(), manager, args, opts)
 	},
 }
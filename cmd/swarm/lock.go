@@ -0,0 +1,105 @@
+/*
+	go-swarm is a Go library and ccommand-line tool for managing the creation
+	and maintenance of Docker Swarm cluster.
+
+    Copyright (C) 2021 Sovereign Cloud Australia Pty Ltd
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as published
+    by the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/aucloud/go-swarm/internal"
+)
+
+func init() {
+	lockEnableCmd.Flags().String(
+		"key-file", "",
+		"Write the unlock key to the given file instead of stdout",
+	)
+
+	lockRotateCmd.Flags().String(
+		"key-file", "",
+		"Write the unlock key to the given file instead of stdout",
+	)
+
+	lockCmd.AddCommand(lockEnableCmd)
+	lockCmd.AddCommand(lockDisableCmd)
+	lockCmd.AddCommand(lockRotateCmd)
+	lockCmd.AddCommand(lockUnlockCmd)
+	RootCmd.AddCommand(lockCmd)
+}
+
+var lockCmd = &cobra.Command{
+	Use:     "lock",
+	Aliases: []string{},
+	Short:   "Manages autolock of an existing Swarm Cluster",
+	Long: `These commands manage autolock of an existing Swarm Cluster. With
+autolock enabled the Raft logs of the managers are encrypted at rest and
+managers must be unlocked with the unlock key after they are restarted.`,
+}
+
+var lockEnableCmd = &cobra.Command{
+	Use:     "enable",
+	Aliases: []string{},
+	Short:   "Enables autolock and displays the unlock key",
+	Long: `This command enables autolock and displays the unlock key (or writes
+it to --key-file). Store the key safely, without it locked managers cannot
+be unlocked.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		keyFile, _ := cmd.Flags().GetString("key-file")
+		internal.LockEnable(cmd.Context(), manager, keyFile)
+	},
+}
+
+var lockDisableCmd = &cobra.Command{
+	Use:     "disable",
+	Aliases: []string{},
+	Short:   "Disables autolock",
+	Args:    cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		internal.LockDisable(cmd.Context(), manager)
+	},
+}
+
+var lockRotateCmd = &cobra.Command{
+	Use:     "rotate",
+	Aliases: []string{},
+	Short:   "Rotates the unlock key and displays the new key",
+	Long: `This command rotates the unlock key and displays the new key (or
+writes it to --key-file). Keep the previous key for a while as managers that
+have not yet seen the new key may still need it to be unlocked.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		keyFile, _ := cmd.Flags().GetString("key-file")
+		internal.LockRotate(cmd.Context(), manager, keyFile)
+	},
+}
+
+var lockUnlockCmd = &cobra.Command{
+	Use:     "unlock <Clusterfile>",
+	Aliases: []string{},
+	Short:   "Unlocks the locked managers of a Swarm Cluster",
+	Long: `This command uses a Clusterfile that describes the nodes of an
+existing Swarm Cluster and unlocks every manager that is locked (e.g: after
+it was restarted) with the unlock key read from stdin.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		internal.LockUnlock(cmd.Context(), manager, args)
+	},
+}
@@ -0,0 +1,44 @@
+/*
+	go-swarm is a Go library and ccommand-line tool for managing the creation
+	and maintenance of Docker Swarm cluster.
+
+    Copyright (C) 2021 Sovereign Cloud Australia Pty Ltd
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as published
+    by the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/aucloud/go-swarm"
+	"github.com/aucloud/go-swarm/internal"
+)
+
+func init() {
+	RootCmd.AddCommand(pauseCmd)
+}
+
+var pauseCmd = &cobra.Command{
+	Use:     "pause",
+	Aliases: []string{},
+	Short:   "Pauses one or more nodes in an existing Swarm Cluster",
+	Long: `This command pauses one or more nodes of an existing Swarm Cluster. No new
+tasks are scheduled on paused nodes but their existing tasks keep running.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		internal.SetAvailability(cmd.Context(), manager, args, swarm.AvailabilityPause)
+	},
+}
@@ -0,0 +1,65 @@
+/*
+	go-swarm is a Go library and ccommand-line tool for managing the creation
+	and maintenance of Docker Swarm cluster.
+
+    Copyright (C) 2021 Sovereign Cloud Australia Pty Ltd
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as published
+    by the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/aucloud/go-swarm/internal"
+)
+
+func init() {
+	preflightCmd.Flags().String(
+		"min-engine-version", "",
+		"Minimum engine version of every node (e.g: 20.10.0)",
+	)
+
+	preflightCmd.Flags().Bool(
+		"skip-port-checks", false,
+		"Skip checking the ports used by Swarm are reachable between nodes",
+	)
+
+	RootCmd.AddCommand(preflightCmd)
+}
+
+var preflightCmd = &cobra.Command{
+	Use:     "preflight",
+	Aliases: []string{},
+	Short:   "Checks nodes are ready to form a Swarm Cluster",
+	Long: `This command uses a Clusterfile and connects to every node to check
+that the Docker daemon is reachable, engine versions comply with the engine
+version policy (see --min-engine-version), nodes are not already part of a
+different swarm, hostnames are unique, clocks are in sync and the ports used
+by Swarm (TCP 2377 on managers, TCP/UDP 7946 and UDP 4789) are reachable
+between the nodes. No changes are made.
+
+A table of the checks each node passed or failed is displayed followed by the
+reasons for any failures. The exit status is non-zero if any check failed.
+
+Port checks require nc (netcat) and timeout on the nodes and fail with
+--use-api. Use --skip-port-checks to skip them.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		os.Exit(internal.Preflight(cmd.Context(), manager, args))
+	},
+}
@@ -0,0 +1,103 @@
+/*
+	go-swarm is a Go library and ccommand-line tool for managing the creation
+	and maintenance of Docker Swarm cluster.
+
+    Copyright (C) 2021 Sovereign Cloud Australia Pty Ltd
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as published
+    by the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/aucloud/go-swarm"
+	"github.com/aucloud/go-swarm/internal"
+)
+
+func init() {
+	rollingMaintenanceCmd.Flags().String(
+		"exec", "",
+		"Command to run on each drained node",
+	)
+
+	rollingMaintenanceCmd.Flags().Bool(
+		"reboot", false,
+		"Reboot each drained node",
+	)
+
+	rollingMaintenanceCmd.Flags().Int(
+		"parallel", 1,
+		"Number of worker nodes to maintain concurrently",
+	)
+
+	rollingMaintenanceCmd.Flags().Duration(
+		"drain-timeout", swarm.DefaultDrainTimeout,
+		"How long to wait for each node to drain",
+	)
+
+	rollingMaintenanceCmd.Flags().Duration(
+		"ready-timeout", swarm.DefaultReadyTimeout,
+		"How long to wait for each node to be ready again",
+	)
+
+	rollingMaintenanceCmd.Flags().Duration(
+		"converge-timeout", swarm.DefaultConvergeTimeout,
+		"How long to wait for services to converge after each batch of nodes",
+	)
+
+	rollingMaintenanceCmd.Flags().BoolP(
+		"force", "f", false,
+		"Drain nodes even if the remaining nodes cannot run all of the drained tasks",
+	)
+
+	RootCmd.AddCommand(rollingMaintenanceCmd)
+}
+
+var rollingMaintenanceCmd = &cobra.Command{
+	Use:     "rolling-maintenance",
+	Aliases: []string{},
+	Short:   "Performs maintenance on every node of an existing Swarm Cluster",
+	Long: `This command performs maintenance on every node of an existing Swarm
+Cluster given by the Clusterfile. Each node is drained, the command given by
+--exec is run on it, it is rebooted if --reboot is given and once it is ready
+again it is made active again.
+
+Workers are maintained first (up to --parallel at a time) followed by the
+managers one at a time with the leader last. Services must converge before
+the first and after every batch of nodes. Maintenance stops at the first node
+that fails which is left drained.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var opts swarm.RollingMaintenanceOptions
+		opts.Maintenance.Exec, _ = cmd.Flags().GetString("exec")
+		opts.Maintenance.Reboot, _ = cmd.Flags().GetBool("reboot")
+		opts.Maintenance.ReadyTimeout, _ = cmd.Flags().GetDuration("ready-timeout")
+		opts.Maintenance.Drain.Timeout, _ = cmd.Flags().GetDuration("drain-timeout")
+		opts.Maintenance.Drain.Force, _ = cmd.Flags().GetBool("force")
+		opts.Concurrency, _ = cmd.Flags().GetInt("parallel")
+		opts.ConvergeTimeout, _ = cmd.Flags().GetDuration("converge-timeout")
+
+		if opts.Maintenance.Exec == "" && !opts.Maintenance.Reboot {
+			fmt.Fprintln(os.Stderr, "error --exec or --reboot is required")
+			os.Exit(-1)
+		}
+
+		internal.RollingMaintenance(cmd.Context(), manager, args, opts)
+	},
+}
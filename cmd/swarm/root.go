@@ -24,7 +24,9 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/mitchellh/go-homedir"
@@ -53,7 +55,11 @@ facilitate and automation the creation and management of Docker Swarm Clusters.
 
 Supported functions include:
 
+- Checking nodes are ready to form a Swarm Cluster
 - Creating a Swarm Clsuter
+- Destroying a Swarm Cluster
+- Managing autolock and unlocking managers
+- Managing the root CA and certificate expiry
 - Adding new worker or manager nodes
 - Draining nodes
 - Removing nodes
@@ -70,20 +76,65 @@ Supported functions include:
 
 		var switcher swarm.Switcher
 
-		if viper.GetBool("use-local") {
+		timeout := viper.GetDuration("ssh-timeout")
+
+		switch {
+		case strings.HasPrefix(viper.GetString("host"), "tcp://"):
+			host := viper.GetString("host")
+			ca := viper.GetString("tls-ca")
+			cert := viper.GetString("tls-cert")
+			key := viper.GetString("tls-key")
+
+			tlsSwitcher, err := swarm.NewTLSSwitcher(host, ca, cert, key, timeout)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error creating tls switcher: %s\n", err)
+				os.Exit(-1)
+			}
+
+			switcher = tlsSwitcher
+		case viper.GetBool("use-local") && viper.GetBool("use-api"):
+			sockPath := viper.GetString("sock-path")
+
+			unixSwitcher, err := swarm.NewUnixSwitcher(sockPath, timeout)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error creating unix switcher: %s\n", err)
+				os.Exit(-1)
+			}
+
+			ctx, cancel := context.WithTimeout(cmd.Context(), timeout)
+			defer cancel()
+			if err := unixSwitcher.Switch(ctx, ""); err != nil {
+				fmt.Fprintf(os.Stderr, "error switching to local node: %s\n", err)
+				os.Exit(-1)
+			}
+
+			switcher = unixSwitcher
+		case viper.GetBool("use-local"):
 			localSwitcher, err := swarm.NewLocalSwitcher()
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "error creating local switcher: %s\n", err)
 				os.Exit(-1)
 			}
-			if localSwitcher.Switch(context.Background(), ""); err != nil {
+			if localSwitcher.Switch(cmd.Context(), ""); err != nil {
 				fmt.Fprintf(os.Stderr, "error switching to local node: %s\n", err)
 				os.Exit(-1)
 			}
 
 			switcher = localSwitcher
-		} else {
-			timeout := viper.GetDuration("ssh-timeout")
+		case viper.GetBool("use-api"):
+			user := viper.GetString("ssh-user")
+			addr := viper.GetString("ssh-addr")
+			key := viper.GetString("ssh-key")
+			sockPath := viper.GetString("sock-path")
+
+			sshAPISwitcher, err := swarm.NewSSHAPISwitcher(user, addr, key, sockPath, timeout)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error creating ssh api switcher: %s\n", err)
+				os.Exit(-1)
+			}
+
+			switcher = sshAPISwitcher
+		default:
 			user := viper.GetString("ssh-user")
 			addr := viper.GetString("ssh-addr")
 			key := viper.GetString("ssh-key")
@@ -94,7 +145,7 @@ Supported functions include:
 				os.Exit(-1)
 			}
 
-			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			ctx, cancel := context.WithTimeout(cmd.Context(), timeout)
 			defer cancel()
 			if sshSwitcher.Switch(ctx, addr); err != nil {
 				fmt.Fprintf(os.Stderr, "error switching to remote node %s: %s\n", addr, err)
@@ -104,7 +155,61 @@ Supported functions include:
 			switcher = sshSwitcher
 		}
 
-		if manager, err = swarm.NewManager(switcher); err != nil {
+		var options []swarm.Option
+
+		if cmd.Flags().Lookup("parallel") != nil {
+			parallel, _ := cmd.Flags().GetInt("parallel")
+			options = append(options, swarm.WithConcurrency(parallel))
+		}
+
+		if cmd.Flags().Lookup("checkpoint") != nil {
+			checkpoint, _ := cmd.Flags().GetString("checkpoint")
+			options = append(options, swarm.WithCheckpoint(checkpoint))
+		}
+
+		if cmd.Flags().Lookup("rotate-tokens-after") != nil {
+			if rotate, _ := cmd.Flags().GetBool("rotate-tokens-after"); rotate {
+				options = append(options, swarm.WithTokenRotation())
+			}
+		}
+
+		if cmd.Flags().Lookup("autolock-key-file") != nil {
+			if keyFile, _ := cmd.Flags().GetString("autolock-key-file"); keyFile != "" {
+				options = append(options, swarm.WithAutolock(internal.KeyFile(keyFile).Save))
+			}
+		}
+
+		if cmd.Flags().Lookup("min-engine-version") != nil {
+			if version, _ := cmd.Flags().GetString("min-engine-version"); version != "" {
+				options = append(options, swarm.WithMinEngineVersion(version))
+			}
+		}
+
+		if cmd.Flags().Lookup("enforce-engine-version") != nil {
+			if enforce, _ := cmd.Flags().GetBool("enforce-engine-version"); enforce {
+				options = append(options, swarm.WithEngineVersionEnforcement())
+			}
+		}
+
+		if cmd.Flags().Lookup("preflight") != nil {
+			if preflight, _ := cmd.Flags().GetBool("preflight"); preflight {
+				options = append(options, swarm.WithPreflight())
+			}
+		}
+
+		if cmd.Flags().Lookup("skip-port-checks") != nil {
+			if skip, _ := cmd.Flags().GetBool("skip-port-checks"); skip {
+				options = append(options, swarm.WithoutPortChecks())
+			}
+		}
+
+		if cmd.Flags().Lookup("rollback-on-failure") != nil {
+			if rollback, _ := cmd.Flags().GetBool("rollback-on-failure"); rollback {
+				options = append(options, swarm.WithRollback())
+			}
+		}
+
+		if manager, err = swarm.NewManager(switcher, options...); err != nil {
 			fmt.Fprintf(os.Stderr, "error creating manager: %s\n", err)
 			os.Exit(-1)
 		}
@@ -115,7 +220,11 @@ Supported functions include:
 // and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
-	if err := RootCmd.Execute(); err != nil {
+	// Cancel any running operation on Ctrl-C (or SIGTERM)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := RootCmd.ExecuteContext(ctx); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
@@ -139,6 +248,11 @@ func init() {
 		"Use local socket (connects directly to Docker UNIX socket)",
 	)
 
+	RootCmd.PersistentFlags().BoolP(
+		"use-api", "P", false,
+		"Use the Docker Engine API (over the Docker UNIX socket) instead of the docker CLI",
+	)
+
 	RootCmd.PersistentFlags().DurationP(
 		"ssh-timeout", "T", time.Minute*5,
 		"Timeout to use for SSH connections before giving up (retries failed connections)",
@@ -159,6 +273,26 @@ func init() {
 		"SSH User to use for remote execution",
 	)
 
+	RootCmd.PersistentFlags().StringP(
+		"host", "H", "",
+		"Docker daemon address to connect to over TLS (e.g: tcp://host:2376)",
+	)
+
+	RootCmd.PersistentFlags().String(
+		"tls-ca", internal.DefaultTLSCA,
+		"CA certificate to verify Docker daemons with over TLS",
+	)
+
+	RootCmd.PersistentFlags().String(
+		"tls-cert", internal.DefaultTLSCert,
+		"Client certificate to use for Docker daemons over TLS",
+	)
+
+	RootCmd.PersistentFlags().String(
+		"tls-key", internal.DefaultTLSKey,
+		"Client key to use for Docker daemons over TLS",
+	)
+
 	RootCmd.PersistentFlags().StringP(
 		"sock-path", "S", "/var/run/docker.sock",
 		"Path to Docker UNIX Socket",
@@ -167,6 +301,9 @@ func init() {
 	viper.BindPFlag("use-local", RootCmd.PersistentFlags().Lookup("use-local"))
 	viper.SetDefault("use-local", false)
 
+	viper.BindPFlag("use-api", RootCmd.PersistentFlags().Lookup("use-api"))
+	viper.SetDefault("use-api", false)
+
 	viper.BindPFlag("ssh-addr", RootCmd.PersistentFlags().Lookup("ssh-addr"))
 
 	viper.BindPFlag("ssh-key", RootCmd.PersistentFlags().Lookup("ssh-key"))
@@ -175,6 +312,17 @@ func init() {
 	viper.BindPFlag("ssh-user", RootCmd.PersistentFlags().Lookup("ssh-user"))
 	viper.SetDefault("ssh-user", internal.DefaultSSHUser)
 
+	viper.BindPFlag("host", RootCmd.PersistentFlags().Lookup("host"))
+
+	viper.BindPFlag("tls-ca", RootCmd.PersistentFlags().Lookup("tls-ca"))
+	viper.SetDefault("tls-ca", internal.DefaultTLSCA)
+
+	viper.BindPFlag("tls-cert", RootCmd.PersistentFlags().Lookup("tls-cert"))
+	viper.SetDefault("tls-cert", internal.DefaultTLSCert)
+
+	viper.BindPFlag("tls-key", RootCmd.PersistentFlags().Lookup("tls-key"))
+	viper.SetDefault("tls-key", internal.DefaultTLSKey)
+
 	viper.BindPFlag("sock-path", RootCmd.PersistentFlags().Lookup("sock-path"))
 	viper.SetDefault("sock-path", internal.DefaultSockPath)
 
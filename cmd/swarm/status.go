@@ -27,6 +27,16 @@ import (
 )
 
 func init() {
+	statusCmd.Flags().Int(
+		"cert-expiry-days", 0,
+		"Flag nodes whose TLS certificates expire within the given number of days",
+	)
+
+	statusCmd.Flags().String(
+		"min-engine-version", "",
+		"Minimum engine version of every node (e.g: 20.10.0)",
+	)
+
 	RootCmd.AddCommand(statusCmd)
 }
 
@@ -36,9 +46,18 @@ var statusCmd = &cobra.Command{
 	Short:   "Retrieve and display Swarm Cluster Status",
 	Long: `This command retrives and display information about the Swarm Clsuter
 status of all nodes participating int he warm including which ndoes are mangers,
-workers and who the current leader is.`,
+workers and who the current leader is.
+
+Nodes whose engine version is more than one minor version apart from the
+managers are flagged as SKEWED and nodes whose engine version is below
+--min-engine-version as OUTDATED.
+
+With --cert-expiry-days the expiry of each node's TLS certificate is also
+displayed and nodes whose certificates expire within the given number of
+days are flagged.`,
 	Args: cobra.ExactArgs(0),
 	Run: func(cmd *cobra.Command, args []string) {
-		internal.Status(manager, args)
+		certExpiryDays, _ := cmd.Flags().GetInt("cert-expiry-days")
+		internal.Status(cmd.Context(), manager, args, certExpiryDays)
 	},
 }
@@ -0,0 +1,52 @@
+/*
+	go-swarm is a Go library and ccommand-line tool for managing the creation
+	and maintenance of Docker Swarm cluster.
+
+    Copyright (C) 2021 Sovereign Cloud Australia Pty Ltd
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as published
+    by the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package main
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/aucloud/go-swarm/internal"
+)
+
+func init() {
+	tokenCmd.AddCommand(tokenRotateCmd)
+	RootCmd.AddCommand(tokenCmd)
+}
+
+var tokenCmd = &cobra.Command{
+	Use:     "token",
+	Aliases: []string{},
+	Short:   "Manages the join tokens of an existing Swarm Cluster",
+}
+
+var tokenRotateCmd = &cobra.Command{
+	Use:     "rotate [manager|worker]",
+	Aliases: []string{},
+	Short:   "Rotates the join tokens of an existing Swarm Cluster",
+	Long: `This command rotates the manager or worker join token (or both if
+neither is given) so that the previous token can no longer be used to join
+the cluster.`,
+	Args:      cobra.MaximumNArgs(1),
+	ValidArgs: []string{"manager", "worker"},
+	Run: func(cmd *cobra.Command, args []string) {
+		internal.RotateToken(cmd.Context(), manager, args)
+	},
+}
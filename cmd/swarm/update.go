@@ -23,10 +23,46 @@ package main
 import (
 	"github.com/spf13/cobra"
 
+	"github.com/aucloud/go-swarm"
 	"github.com/aucloud/go-swarm/internal"
 )
 
 func init() {
+	updateCmd.Flags().BoolP(
+		"plan", "p", false,
+		"Display the changes that would be made without making them",
+	)
+
+	updateCmd.Flags().Bool(
+		"rotate-tokens-after", false,
+		"Rotate the manager and worker join tokens once all nodes have joined",
+	)
+
+	updateCmd.Flags().Bool(
+		"preflight", false,
+		"Run the pre-flight checks first and stop if any check fails",
+	)
+
+	updateCmd.Flags().Bool(
+		"skip-port-checks", false,
+		"Skip the port checks of the pre-flight checks",
+	)
+
+	updateCmd.Flags().Int(
+		"parallel", swarm.DefaultConcurrency,
+		"Number of worker nodes to join and label concurrently",
+	)
+
+	updateCmd.Flags().String(
+		"min-engine-version", "",
+		"Minimum engine version of every node (e.g: 20.10.0)",
+	)
+
+	updateCmd.Flags().Bool(
+		"enforce-engine-version", false,
+		"Refuse to join nodes that do not comply with the engine version policy",
+	)
+
 	RootCmd.AddCommand(updateCmd)
 }
 
@@ -38,9 +74,27 @@ var updateCmd = &cobra.Command{
 and types of nodes that should exist in the Swarm Cluster. If there are
 nodes that are missing from the cluster that should be new managers or
 workers, they are added. Any that should be removed are drained and
-removed from the cluster gracefully.`,
+removed from the cluster gracefully.
+
+With --plan the changes that would be made are displayed without making them.
+
+With --rotate-tokens-after the join tokens are rotated once all nodes have
+joined so that the tokens used can not be used again.
+
+Nodes whose engine version is below --min-engine-version (or the engine
+min_version of the Clusterfile) or more than one minor version apart from the
+managers are reported when they join. With --enforce-engine-version they are
+not joined.
+
+With --preflight the checks of the preflight command are run first and
+nothing is changed if any check fails. Use --skip-port-checks to skip the
+port checks (e.g: with --use-api).
+
+With --parallel up to the given number of workers are joined and labelled
+concurrently. Managers are always joined one at a time.`,
 	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		internal.Update(manager, args)
+		plan, _ := cmd.Flags().GetBool("plan")
+		internal.Update(cmd.Context(), manager, args, plan)
 	},
 }
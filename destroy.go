@@ -0,0 +1,203 @@
+/*
+	go-swarm is a Go library and ccommand-line tool for managing the creation
+	and maintenance of Docker Swarm cluster.
+
+    Copyright (C) 2021 Sovereign Cloud Australia Pty Ltd
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as published
+    by the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package swarm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DestroySwarm tears down the Docker Swarm cluster formed by the given set of
+// nodes. The cluster is only destroyed if its ID matches clusterID. If
+// removeServices is true all services are removed first. Workers are drained
+// and leave the cluster first, then managers (other than the leader) are
+// demoted and leave and the leader leaves last of all. Finally every node is
+// checked to no longer be part of any swarm.
+func (m *Manager) DestroySwarm(vms VMNodes, clusterID string, removeServices bool) error {
+	return m.DestroySwarmContext(context.Background(), vms, clusterID, removeServices)
+}
+
+// DestroySwarmContext is like DestroySwarm but the operation is cancelled
+// when ctx is done.
+func (m *Manager) DestroySwarmContext(ctx context.Context, vms VMNodes, clusterID string, removeServices bool) error {
+	if clusterID == "" {
+		return fmt.Errorf("error no cluster id given")
+	}
+
+	id, manager, err := m.findCluster(ctx, vms.FilterByTag(RoleTag, ManagerRole))
+	if err != nil {
+		return err
+	}
+
+	if id == "" {
+		return fmt.Errorf("error no swarm cluster found")
+	}
+
+	if id != clusterID {
+		return fmt.Errorf("error refusing to destroy swarm cluster %s (expected %s)", id, clusterID)
+	}
+
+	if err := m.SwitchNodeContext(ctx, manager.PublicAddress); err != nil {
+		return fmt.Errorf("error switching to manager node: %w", err)
+	}
+
+	nodes, err := m.GetNodesContext(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting current nodes: %w", err)
+	}
+
+	// The Raft leader leaves last so that leadership never changes while
+	// the cluster is torn down
+	var leader VMNode
+	for _, node := range nodes {
+		if node.ManagerStatus != "Leader" {
+			continue
+		}
+		vm := vms.FilterByHostname(node.Hostname)
+		if len(vm) == 0 {
+			return fmt.Errorf("error node %s of swarm cluster %s is not in the Clusterfile", node.Hostname, id)
+		}
+		leader = vm[0]
+	}
+
+	if leader.Hostname == "" {
+		return fmt.Errorf("error no leader found for swarm cluster %s", id)
+	}
+
+	if err := m.SwitchNodeContext(ctx, leader.PublicAddress); err != nil {
+		return fmt.Errorf("error switching to leader node: %w", err)
+	}
+
+	// Every node of the cluster must be in the Clusterfile otherwise they
+	// would be left behind as part of a cluster that no longer exists
+	var (
+		managers, workers VMNodes
+		current           = make(map[string]NodeStatus)
+	)
+
+	for _, node := range nodes {
+		if strings.EqualFold(node.Status, "down") {
+			continue
+		}
+
+		vm := vms.FilterByHostname(node.Hostname)
+		if len(vm) == 0 {
+			return fmt.Errorf("error node %s of swarm cluster %s is not in the Clusterfile", node.Hostname, id)
+		}
+
+		current[node.Hostname] = node
+
+		switch {
+		case vm[0].Hostname == leader.Hostname:
+		case node.IsManager():
+			managers = append(managers, vm[0])
+		default:
+			workers = append(workers, vm[0])
+		}
+	}
+
+	if removeServices {
+		services, err := m.engine().ServiceList(ctx)
+		if err != nil {
+			return fmt.Errorf("error listing services: %w", err)
+		}
+
+		for _, service := range services {
+			if err := m.engine().ServiceRemove(ctx, service.ID); err != nil {
+				return fmt.Errorf("error removing service %s: %w", service.Spec.Name, err)
+			}
+			log.Infof("Removed service %s", service.Spec.Name)
+		}
+	}
+
+	if err := m.forEach(workers, func(n *Manager, vm VMNode) error {
+		if err := n.SwitchNodeContext(ctx, leader.PublicAddress); err != nil {
+			return fmt.Errorf("error switching to manager node: %w", err)
+		}
+		if err := n.drainNode(ctx, vm.Hostname, DrainOptions{}); err != nil {
+			return fmt.Errorf("error draining node %s: %w", vm.Hostname, err)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	leave := func(n *Manager, vm VMNode) error {
+		if err := n.leaveSwarm(ctx, vm, false); err != nil {
+			return fmt.Errorf("error leaving swarm on %s: %w", vm.Hostname, err)
+		}
+		return nil
+	}
+
+	// Workers leave first, then the managers and finally the leader
+	if err := m.forEach(workers, leave); err != nil {
+		return err
+	}
+
+	// Demote managers one at a time so the cluster keeps its quorum until
+	// only the leader is left
+	for _, vm := range managers {
+		if err := m.demoteNode(ctx, vm.Hostname); err != nil {
+			return fmt.Errorf("error demoting node %s: %w", vm.Hostname, err)
+		}
+	}
+
+	if err := m.forEach(managers, leave); err != nil {
+		return err
+	}
+
+	// The leader is the last manager left and must be forced to leave
+	if err := m.leaveSwarm(ctx, leader, true); err != nil {
+		return fmt.Errorf("error leaving swarm on %s: %w", leader.Hostname, err)
+	}
+
+	var failed []string
+
+	for _, vm := range vms {
+		if _, ok := current[vm.Hostname]; !ok {
+			continue
+		}
+
+		if err := m.SwitchNodeContext(ctx, vm.PublicAddress); err != nil {
+			return fmt.Errorf("error switching to node %s: %w", vm.PublicAddress, err)
+		}
+
+		info, err := m.GetInfoContext(ctx)
+		if err != nil {
+			return fmt.Errorf("error getting node info for %s: %w", vm.Hostname, err)
+		}
+
+		if info.Swarm.LocalNodeState != "inactive" {
+			failed = append(failed, fmt.Sprintf("%s (%s)", vm.Hostname, info.Swarm.LocalNodeState))
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("error nodes still part of a swarm: %s", strings.Join(failed, ", "))
+	}
+
+	log.Infof("Successfully destroyed swarm cluster %s", id)
+
+	return nil
+}
@@ -0,0 +1,410 @@
+/*
+	go-swarm is a Go library and ccommand-line tool for managing the creation
+	and maintenance of Docker Swarm cluster.
+
+    Copyright (C) 2021 Sovereign Cloud Australia Pty Ltd
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as published
+    by the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package swarm
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultDrainTimeout is how long to wait for a node to drain by default
+const DefaultDrainTimeout = time.Minute * 10 // 10 minutes
+
+// DrainOptions control how DrainNodes drains nodes. The zero value drains one
+// node at a time with the default timeout and poll interval and stops at the
+// first node that fails to drain.
+type DrainOptions struct {
+	// Timeout is how long to wait for each node to drain (DefaultDrainTimeout
+	// if zero)
+	Timeout time.Duration
+
+	// PollInterval is how often the tasks of a draining node are checked (the
+	// Manager's poll interval if zero)
+	PollInterval time.Duration
+
+	// Concurrency is the maximum number of nodes drained at once (one if zero)
+	Concurrency int
+
+	// ContinueOnError drains the remaining nodes when a node fails to drain
+	// instead of stopping at the first failure
+	ContinueOnError bool
+
+	// MaxDrainedPercent is the maximum percentage of the cluster's nodes that
+	// may be unavailable (drained, paused or down) once the nodes are drained
+	// (no limit if zero)
+	MaxDrainedPercent int
+
+	// Force drains the nodes even if the remaining active nodes lack the
+	// capacity or do not satisfy the placement constraints to run the tasks
+	// of the nodes being drained (leaving those tasks pending)
+	Force bool
+}
+
+// WithDrainOptions sets how nodes are drained before they are removed from
+// a cluster (e.g: nodes no longer in the Clusterfile when updating it). Only
+// the timeout and poll interval apply as nodes are removed one at a time.
+func WithDrainOptions(opts DrainOptions) Option {
+	return func(cfg *Config) error {
+		if err := opts.Validate(); err != nil {
+			return err
+		}
+		cfg.Drain = opts
+		return nil
+	}
+}
+
+// Validate returns an error if any of the options are invalid
+func (o DrainOptions) Validate() error {
+	switch {
+	case o.Timeout < 0:
+		return fmt.Errorf("error invalid drain timeout: %s", o.Timeout)
+	case o.PollInterval < 0:
+		return fmt.Errorf("error invalid drain poll interval: %s", o.PollInterval)
+	case o.Concurrency < 0:
+		return fmt.Errorf("error invalid drain concurrency: %d", o.Concurrency)
+	case o.MaxDrainedPercent < 0 || o.MaxDrainedPercent > 100:
+		return fmt.Errorf("error invalid maximum drained percentage: %d", o.MaxDrainedPercent)
+	}
+	return nil
+}
+
+// withDefaults returns the options with defaults for any zero values
+func (o DrainOptions) withDefaults(cfg *Config) DrainOptions {
+	if o.Timeout == 0 {
+		o.Timeout = DefaultDrainTimeout
+	}
+	if o.PollInterval == 0 {
+		o.PollInterval = cfg.PollInterval
+	}
+	if o.Concurrency == 0 {
+		o.Concurrency = 1
+	}
+	return o
+}
+
+// DrainError is returned by DrainNodes when one or more nodes failed to drain
+// and ContinueOnError was given
+type DrainError struct {
+	// Failed are the nodes that failed to drain and the errors encountered
+	Failed map[string]error
+}
+
+func (e *DrainError) Error() string {
+	var nodes []string
+	for node := range e.Failed {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+
+	var errs []string
+	for _, node := range nodes {
+		errs = append(errs, fmt.Sprintf("%s: %s", node, e.Failed[node]))
+	}
+
+	return fmt.Sprintf("error draining %d node(s): %s", len(nodes), strings.Join(errs, "; "))
+}
+
+// DrainTimeoutError is returned when a node did not drain in time
+type DrainTimeoutError struct {
+	// Node is the node being drained
+	Node string
+
+	// Elapsed is how long was waited for the node to drain
+	Elapsed time.Duration
+
+	// Tasks are the tasks last seen that had not stopped (empty if the
+	// node's tasks could not be listed)
+	Tasks Tasks
+
+	// Err is the reason waiting was given up (e.g: context.DeadlineExceeded)
+	Err error
+}
+
+func (e *DrainTimeoutError) Error() string {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "error waiting for %s to drain after %s: %s", e.Node, e.Elapsed, e.Err)
+
+	if len(e.Tasks) > 0 {
+		var tasks []string
+		for _, task := range e.Tasks {
+			tasks = append(tasks, task.String())
+		}
+		fmt.Fprintf(&sb, " (%d task(s) not stopped: %s)", len(tasks), strings.Join(tasks, "; "))
+	}
+
+	return sb.String()
+}
+
+func (e *DrainTimeoutError) Unwrap() error {
+	return e.Err
+}
+
+func (m *Manager) drainNode(ctx context.Context, node string, opts DrainOptions) error {
+	opts = opts.withDefaults(m.config)
+	startedAt := time.Now()
+
+	update := NodeUpdate{Availability: AvailabilityDrain}
+	if err := m.engine().NodeUpdate(ctx, node, update); err != nil {
+		return fmt.Errorf("error updating node availability: %w", err)
+	}
+
+	// The earlier of the caller's deadline (if any) and the timeout applies
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(opts.PollInterval)
+	defer ticker.Stop()
+
+	var remaining Tasks
+
+	for {
+		select {
+		case <-ticker.C:
+			elapsed := time.Since(startedAt)
+
+			tasks, err := m.engine().NodeTasks(ctx, node)
+			if err != nil {
+				log.WithError(err).Warnf("error getting tasks from node %s (retrying)", node)
+				continue
+			}
+
+			// Tasks of global services are stopped too (but not moved)
+			remaining = tasks.Remaining()
+
+			if len(remaining) == 0 {
+				log.Infof("Successfully drained %s after %s", node, elapsed)
+				return nil
+			}
+
+			log.Infof("Still waiting for %d task(s) on %s to stop after %s ...", len(remaining), node, elapsed)
+		case <-ctx.Done():
+			elapsed := time.Since(startedAt)
+			log.Errorf("gave up waiting for %s to drain after %s", node, elapsed)
+			for _, task := range remaining {
+				log.Errorf("task %s on %s has not stopped", task, node)
+			}
+			return &DrainTimeoutError{Node: node, Elapsed: elapsed, Tasks: remaining, Err: ctx.Err()}
+		}
+	}
+
+	// Unreachable
+}
+
+// DrainNodes drains one or more nodes from an existing Docker Swarm cluster
+// and blocks until there are no more tasks running on thoese nodes. How many
+// nodes are drained at once, how long to wait for each and whether to carry
+// on when a node fails to drain is controlled by the optional opts (the zero
+// DrainOptions if not given).
+//
+// Unless opts.Force is given a *CapacityError is returned without draining
+// any node if the tasks of the nodes could not all be rescheduled on the
+// remaining active nodes.
+func (m *Manager) DrainNodes(nodes []string, opts ...DrainOptions) error {
+	return m.DrainNodesContext(context.Background(), nodes, opts...)
+}
+
+// DrainNodesContext is like DrainNodes but the operation is cancelled when
+// ctx is done.
+func (m *Manager) DrainNodesContext(ctx context.Context, nodes []string, options ...DrainOptions) error {
+	var opts DrainOptions
+	switch len(options) {
+	case 0:
+	case 1:
+		opts = options[0]
+	default:
+		return fmt.Errorf("error expected at most one set of drain options but got %d", len(options))
+	}
+
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	opts = opts.withDefaults(m.config)
+
+	if err := m.ensureManager(ctx); err != nil {
+		return fmt.Errorf("error connecting to manager node: %w", err)
+	}
+
+	if opts.MaxDrainedPercent > 0 {
+		if err := m.checkDrained(ctx, nodes, opts.MaxDrainedPercent); err != nil {
+			return err
+		}
+	}
+
+	if !opts.Force {
+		if err := m.checkCapacity(ctx, nodes); err != nil {
+			return err
+		}
+	}
+
+	if opts.Concurrency == 1 {
+		return m.drainEach(ctx, nodes, opts, func(_ int) *Manager { return m })
+	}
+
+	// Each concurrent drain runs on its own connection to the manager so
+	// that one drain timing out does not close the connection of the others
+	info, err := m.GetInfoContext(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting manager info: %w", err)
+	}
+
+	forks := make([]*Manager, opts.Concurrency)
+	for i := range forks {
+		n, err := m.fork()
+		if err != nil {
+			return err
+		}
+		if err := n.SwitchNodeViaContext(ctx, info.Swarm.NodeAddr); err != nil {
+			return fmt.Errorf("error connecting to manager node: %w", err)
+		}
+		forks[i] = n
+	}
+
+	return m.drainEach(ctx, nodes, opts, func(i int) *Manager { return forks[i] })
+}
+
+// drainEach drains nodes with at most opts.Concurrency drains at once, each
+// on the Manager returned by manager for the worker's slot
+func (m *Manager) drainEach(ctx context.Context, nodes []string, opts DrainOptions, manager func(slot int) *Manager) error {
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed = make(map[string]error)
+		first  error
+	)
+
+	slots := make(chan int, opts.Concurrency)
+	for i := 0; i < opts.Concurrency; i++ {
+		slots <- i
+	}
+
+	for _, node := range nodes {
+		slot := <-slots
+
+		mu.Lock()
+		stop := first != nil && !opts.ContinueOnError
+		mu.Unlock()
+		if stop {
+			slots <- slot
+			break
+		}
+
+		wg.Add(1)
+		go func(slot int, node string) {
+			defer func() { slots <- slot }()
+			defer wg.Done()
+
+			if err := manager(slot).drainNode(ctx, node, opts); err != nil {
+				log.WithError(err).Errorf("error draining node: %s", node)
+				mu.Lock()
+				failed[node] = err
+				if first == nil {
+					first = fmt.Errorf("error draining node %s: %w", node, err)
+				}
+				mu.Unlock()
+			}
+		}(slot, node)
+	}
+
+	wg.Wait()
+
+	switch {
+	case len(failed) == 0:
+		return nil
+	case opts.ContinueOnError:
+		return &DrainError{Failed: failed}
+	default:
+		return first
+	}
+}
+
+// checkDrained returns an error if draining nodes would leave more than
+// maxPercent of the cluster's nodes unavailable. Nodes that are already
+// drained, paused or down count towards the limit.
+func (m *Manager) checkDrained(ctx context.Context, nodes []string, maxPercent int) error {
+	current, err := m.GetNodesContext(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting current nodes: %w", err)
+	}
+
+	draining := make(map[string]bool)
+	for _, node := range nodes {
+		draining[node] = true
+	}
+
+	unavailable := 0
+	for _, node := range current {
+		switch {
+		case draining[node.ID] || draining[node.Hostname]:
+		case !strings.EqualFold(node.Availability, AvailabilityActive):
+		case strings.EqualFold(node.Status, "down"):
+		default:
+			continue
+		}
+		unavailable++
+	}
+
+	if unavailable*100 > len(current)*maxPercent {
+		return fmt.Errorf(
+			"error refusing to drain %d node(s): %d of %d nodes would be unavailable (maximum %d%%)",
+			len(nodes), unavailable, len(current), maxPercent,
+		)
+	}
+
+	return nil
+}
+
+// SetAvailability sets the availability of a node in an existing Docker
+// Swarm cluster to one of AvailabilityActive, AvailabilityPause or
+// AvailabilityDrain. Unlike DrainNodes it does not wait for the tasks of a
+// drained node to shutdown.
+func (m *Manager) SetAvailability(node, availability string) error {
+	return m.SetAvailabilityContext(context.Background(), node, availability)
+}
+
+// SetAvailabilityContext is like SetAvailability but the operation is
+// cancelled when ctx is done.
+func (m *Manager) SetAvailabilityContext(ctx context.Context, node, availability string) error {
+	switch availability {
+	case AvailabilityActive, AvailabilityPause, AvailabilityDrain:
+	default:
+		return fmt.Errorf("error invalid availability %q (expected active, pause or drain)", availability)
+	}
+
+	if err := m.ensureManager(ctx); err != nil {
+		return fmt.Errorf("error connecting to manager node: %w", err)
+	}
+
+	update := NodeUpdate{Availability: availability}
+	if err := m.engine().NodeUpdate(ctx, node, update); err != nil {
+		return fmt.Errorf("error updating node availability: %w", err)
+	}
+
+	log.Infof("Set availability of %s to %s", node, availability)
+
+	return nil
+}
@@ -0,0 +1,445 @@
+/*
+	go-swarm is a Go library and ccommand-line tool for managing the creation
+	and maintenance of Docker Swarm cluster.
+
+    Copyright (C) 2021 Sovereign Cloud Australia Pty Ltd
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as published
+    by the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package swarm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"go.mills.io/jsonlines"
+)
+
+const (
+	infoCommand      = `docker info --format "{{ json . }}"`
+	nodesCommand     = `docker node ls --format "{{ json . }}"`
+	inspectCommand   = `docker node inspect --format "{{ json . }}" %s`
+	tasksCommand     = `docker node ps --format "{{ json .}}" %s`
+	initCommand      = `docker swarm init --advertise-addr %s --listen-addr %s`
+	joinCommand      = `docker swarm join --advertise-addr %s --listen-addr %s --token %s`
+	leaveCommand     = `docker swarm leave`
+	forceLeave       = `--force`
+	tokenCommand     = `docker swarm join-token -q %s`
+	rotateCommand    = `docker swarm join-token --rotate -q %s`
+	swarmUpdate      = `docker swarm update %s`
+	autolockCommand  = `docker swarm update --autolock=%t`
+	unlockKeyCommand = `docker swarm unlock-key -q`
+	rotateKeyCommand = `docker swarm unlock-key --rotate -q`
+	unlockCommand    = `docker swarm unlock`
+	caCommand        = `docker swarm ca`
+	rotateCACommand  = `docker swarm ca --rotate --quiet`
+	externalCA       = `docker swarm update --external-ca %s`
+	certExpiry       = `docker swarm update --cert-expiry %s`
+	updateCommand    = `docker node update %s %s`
+	removeCommand    = `docker node rm %s`
+	servicesCommand  = `docker service ls --quiet`
+	serviceCommand   = `docker service inspect --format "{{ json . }}" %s`
+	serviceRmCommand = `docker service rm %s`
+	probeCommand     = `sh -c 'command -v nc > /dev/null && command -v timeout > /dev/null || { echo unavailable; exit; }; timeout %d nc -z%s %s %d; echo $?'`
+	setAvailability  = `--availability %s`
+	setRole          = `--role %s`
+	labelAdd         = `--label-add %s`
+	labelRm          = `--label-rm %s`
+)
+
+// NodeUpdate describes changes to a node's spec. Empty fields are left
+// unchanged. Labels to be added are in the form `key=value` (or `key`).
+type NodeUpdate struct {
+	Availability string
+	Role         string
+	AddLabels    []string
+	RemoveLabels []string
+}
+
+// engine is the set of Docker Engine operations the Manager performs on the
+// current node. By default operations are performed by running `docker`
+// commands with the Switcher's Runner, Switchers that implement APISwitcher
+// talk to the Docker Engine API directly instead.
+type engine interface {
+	Info(ctx context.Context) (NodeInfo, error)
+	NodeList(ctx context.Context) ([]NodeStatus, error)
+	NodeInspect(ctx context.Context, node string) (NodeDetails, error)
+	NodeUpdate(ctx context.Context, node string, update NodeUpdate) error
+	NodeRemove(ctx context.Context, node string) error
+	NodeTasks(ctx context.Context, node string) (Tasks, error)
+	SwarmInit(ctx context.Context, advertiseAddr, listenAddr string, cfg SwarmConfig) error
+	SwarmUpdate(ctx context.Context, update SwarmUpdate) error
+	SwarmJoin(ctx context.Context, advertiseAddr, listenAddr, dataPathAddr, token, remoteAddr string) error
+	SwarmLeave(ctx context.Context, force bool) error
+	JoinToken(ctx context.Context, tokenType string) (string, error)
+	RotateJoinToken(ctx context.Context, tokenType string) (string, error)
+	SwarmAutolock(ctx context.Context, enable bool) error
+	UnlockKey(ctx context.Context) (string, error)
+	RotateUnlockKey(ctx context.Context) (string, error)
+	SwarmUnlock(ctx context.Context, key string) error
+	RootCA(ctx context.Context) (string, error)
+	RotateCA(ctx context.Context) (string, error)
+	SetExternalCA(ctx context.Context, ca ExternalCA) error
+	SetCertExpiry(ctx context.Context, expiry time.Duration) error
+	ServiceList(ctx context.Context) ([]Service, error)
+	ServiceRemove(ctx context.Context, service string) error
+	Probe(ctx context.Context, network, host string, port int) (probeResult, error)
+}
+
+// engine returns the engine for the current Switcher
+func (m *Manager) engine() engine {
+	if s, ok := m.switcher.(APISwitcher); ok {
+		return &apiEngine{client: s.Client()}
+	}
+	return &cliEngine{m: m}
+}
+
+// cliEngine implements engine by running `docker` commands and parsing
+// their output
+type cliEngine struct {
+	m *Manager
+}
+
+func (e *cliEngine) Info(ctx context.Context) (NodeInfo, error) {
+	var node NodeInfo
+
+	out, err := e.m.runCmd(ctx, infoCommand)
+	if err != nil {
+		return NodeInfo{}, fmt.Errorf("error running info command: %w", err)
+	}
+
+	data, err := ioutil.ReadAll(out)
+	if err != nil {
+		return NodeInfo{}, fmt.Errorf("error reading info command output: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &node); err != nil {
+		return NodeInfo{}, fmt.Errorf("error parsing json data: %s", err)
+	}
+
+	return node, nil
+}
+
+func (e *cliEngine) NodeList(ctx context.Context) ([]NodeStatus, error) {
+	stdout, err := e.m.runCmd(ctx, nodesCommand)
+	if err != nil {
+		return nil, fmt.Errorf("error running nodes command: %w", err)
+	}
+
+	var nodes []NodeStatus
+
+	if err := jsonlines.Decode(stdout, &nodes); err != nil {
+		return nil, fmt.Errorf("error parsing json data: %s", err)
+	}
+
+	return nodes, nil
+}
+
+func (e *cliEngine) NodeInspect(ctx context.Context, node string) (NodeDetails, error) {
+	stdout, err := e.m.runCmd(ctx, fmt.Sprintf(inspectCommand, node))
+	if err != nil {
+		return NodeDetails{}, fmt.Errorf("error running inspect command: %w", err)
+	}
+
+	data, err := ioutil.ReadAll(stdout)
+	if err != nil {
+		return NodeDetails{}, fmt.Errorf("error reading inspect command output: %w", err)
+	}
+
+	var details NodeDetails
+
+	if err := json.Unmarshal(data, &details); err != nil {
+		return NodeDetails{}, fmt.Errorf("error parsing json data: %s", err)
+	}
+
+	return details, nil
+}
+
+func (e *cliEngine) NodeUpdate(ctx context.Context, node string, update NodeUpdate) error {
+	var options []string
+
+	if update.Availability != "" {
+		options = append(options, fmt.Sprintf(setAvailability, update.Availability))
+	}
+	if update.Role != "" {
+		options = append(options, fmt.Sprintf(setRole, update.Role))
+	}
+	for _, label := range update.AddLabels {
+		options = append(options, fmt.Sprintf(labelAdd, label))
+	}
+	for _, label := range update.RemoveLabels {
+		options = append(options, fmt.Sprintf(labelRm, label))
+	}
+
+	if len(options) == 0 {
+		return nil
+	}
+
+	cmd := fmt.Sprintf(updateCommand, strings.Join(options, " "), node)
+	if _, err := e.m.runCmd(ctx, cmd); err != nil {
+		return fmt.Errorf("error running update command: %w", err)
+	}
+
+	return nil
+}
+
+func (e *cliEngine) NodeRemove(ctx context.Context, node string) error {
+	if _, err := e.m.runCmd(ctx, fmt.Sprintf(removeCommand, node)); err != nil {
+		return fmt.Errorf("error running remove command: %w", err)
+	}
+	return nil
+}
+
+func (e *cliEngine) NodeTasks(ctx context.Context, node string) (Tasks, error) {
+	stdout, err := e.m.runCmd(ctx, fmt.Sprintf(tasksCommand, node))
+	if err != nil {
+		return nil, fmt.Errorf("error running tasks command: %w", err)
+	}
+
+	var tasks Tasks
+
+	if err := jsonlines.Decode(stdout, &tasks); err != nil {
+		return nil, fmt.Errorf("error parsing json data: %s", err)
+	}
+
+	return tasks, nil
+}
+
+func (e *cliEngine) SwarmInit(ctx context.Context, advertiseAddr, listenAddr string, cfg SwarmConfig) error {
+	cmd := fmt.Sprintf(initCommand, advertiseAddr, listenAddr)
+	if flags := cfg.initFlags(); len(flags) > 0 {
+		cmd += " " + strings.Join(flags, " ")
+	}
+	if _, err := e.m.runCmd(ctx, cmd); err != nil {
+		return fmt.Errorf("error running init command: %w", err)
+	}
+	return nil
+}
+
+func (e *cliEngine) SwarmUpdate(ctx context.Context, update SwarmUpdate) error {
+	cmd := fmt.Sprintf(swarmUpdate, strings.Join(update.flags(), " "))
+	if _, err := e.m.runCmd(ctx, cmd); err != nil {
+		return fmt.Errorf("error running update command: %w", err)
+	}
+	return nil
+}
+
+func (e *cliEngine) SwarmJoin(ctx context.Context, advertiseAddr, listenAddr, dataPathAddr, token, remoteAddr string) error {
+	cmd := fmt.Sprintf(joinCommand, advertiseAddr, listenAddr, token)
+	if dataPathAddr != "" {
+		cmd += " --data-path-addr " + dataPathAddr
+	}
+	cmd += " " + remoteAddr
+	if _, err := e.m.runCmd(ctx, cmd); err != nil {
+		return fmt.Errorf("error running join command: %w", err)
+	}
+	return nil
+}
+
+func (e *cliEngine) SwarmLeave(ctx context.Context, force bool) error {
+	cmd := leaveCommand
+	if force {
+		cmd += " " + forceLeave
+	}
+	if _, err := e.m.runCmd(ctx, cmd); err != nil {
+		return fmt.Errorf("error running leave command: %w", err)
+	}
+	return nil
+}
+
+func (e *cliEngine) JoinToken(ctx context.Context, tokenType string) (string, error) {
+	stdout, err := e.m.runCmd(ctx, fmt.Sprintf(tokenCommand, tokenType))
+	if err != nil {
+		return "", fmt.Errorf("error running token command: %w", err)
+	}
+
+	data, err := ioutil.ReadAll(stdout)
+	if err != nil {
+		return "", fmt.Errorf("error reading stdout: %w", err)
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (e *cliEngine) RotateJoinToken(ctx context.Context, tokenType string) (string, error) {
+	stdout, err := e.m.runCmd(ctx, fmt.Sprintf(rotateCommand, tokenType))
+	if err != nil {
+		return "", fmt.Errorf("error running rotate command: %w", err)
+	}
+
+	data, err := ioutil.ReadAll(stdout)
+	if err != nil {
+		return "", fmt.Errorf("error reading stdout: %w", err)
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (e *cliEngine) SwarmAutolock(ctx context.Context, enable bool) error {
+	if _, err := e.m.runCmd(ctx, fmt.Sprintf(autolockCommand, enable)); err != nil {
+		return fmt.Errorf("error running autolock command: %w", err)
+	}
+	return nil
+}
+
+func (e *cliEngine) UnlockKey(ctx context.Context) (string, error) {
+	stdout, err := e.m.runCmd(ctx, unlockKeyCommand)
+	if err != nil {
+		return "", fmt.Errorf("error running unlock-key command: %w", err)
+	}
+
+	data, err := ioutil.ReadAll(stdout)
+	if err != nil {
+		return "", fmt.Errorf("error reading stdout: %w", err)
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (e *cliEngine) RotateUnlockKey(ctx context.Context) (string, error) {
+	stdout, err := e.m.runCmd(ctx, rotateKeyCommand)
+	if err != nil {
+		return "", fmt.Errorf("error running unlock-key command: %w", err)
+	}
+
+	data, err := ioutil.ReadAll(stdout)
+	if err != nil {
+		return "", fmt.Errorf("error reading stdout: %w", err)
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (e *cliEngine) SwarmUnlock(ctx context.Context, key string) error {
+	// The key is given on stdin so that it is not visible in the process list
+	if _, err := e.m.runCmdWithInput(ctx, unlockCommand, key+"\n"); err != nil {
+		return fmt.Errorf("error running unlock command: %w", err)
+	}
+	return nil
+}
+
+func (e *cliEngine) RootCA(ctx context.Context) (string, error) {
+	stdout, err := e.m.runCmd(ctx, caCommand)
+	if err != nil {
+		return "", fmt.Errorf("error running ca command: %w", err)
+	}
+
+	data, err := ioutil.ReadAll(stdout)
+	if err != nil {
+		return "", fmt.Errorf("error reading stdout: %w", err)
+	}
+
+	return strings.TrimSpace(string(data)) + "\n", nil
+}
+
+func (e *cliEngine) RotateCA(ctx context.Context) (string, error) {
+	stdout, err := e.m.runCmd(ctx, rotateCACommand)
+	if err != nil {
+		return "", fmt.Errorf("error running ca command: %w", err)
+	}
+
+	data, err := ioutil.ReadAll(stdout)
+	if err != nil {
+		return "", fmt.Errorf("error reading stdout: %w", err)
+	}
+
+	return strings.TrimSpace(string(data)) + "\n", nil
+}
+
+func (e *cliEngine) SetExternalCA(ctx context.Context, ca ExternalCA) error {
+	if _, err := e.m.runCmd(ctx, fmt.Sprintf(externalCA, ca)); err != nil {
+		return fmt.Errorf("error running update command: %w", err)
+	}
+	return nil
+}
+
+func (e *cliEngine) SetCertExpiry(ctx context.Context, expiry time.Duration) error {
+	if _, err := e.m.runCmd(ctx, fmt.Sprintf(certExpiry, expiry)); err != nil {
+		return fmt.Errorf("error running update command: %w", err)
+	}
+	return nil
+}
+
+func (e *cliEngine) ServiceList(ctx context.Context) ([]Service, error) {
+	stdout, err := e.m.runCmd(ctx, servicesCommand)
+	if err != nil {
+		return nil, fmt.Errorf("error running services command: %w", err)
+	}
+
+	data, err := ioutil.ReadAll(stdout)
+	if err != nil {
+		return nil, fmt.Errorf("error reading stdout: %w", err)
+	}
+
+	ids := strings.Fields(string(data))
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	stdout, err = e.m.runCmd(ctx, fmt.Sprintf(serviceCommand, strings.Join(ids, " ")))
+	if err != nil {
+		return nil, fmt.Errorf("error running service inspect command: %w", err)
+	}
+
+	var services []Service
+
+	if err := jsonlines.Decode(stdout, &services); err != nil {
+		return nil, fmt.Errorf("error parsing json data: %s", err)
+	}
+
+	return services, nil
+}
+
+func (e *cliEngine) ServiceRemove(ctx context.Context, service string) error {
+	if _, err := e.m.runCmd(ctx, fmt.Sprintf(serviceRmCommand, service)); err != nil {
+		return fmt.Errorf("error running service remove command: %w", err)
+	}
+	return nil
+}
+
+func (e *cliEngine) Probe(ctx context.Context, network, host string, port int) (probeResult, error) {
+	var flags string
+	if network == "udp" {
+		flags = "u"
+	}
+
+	stdout, err := e.m.runCmd(ctx, fmt.Sprintf(probeCommand, probeTimeout, flags, host, port))
+	if err != nil {
+		return 0, fmt.Errorf("error running probe command: %w", err)
+	}
+
+	data, err := ioutil.ReadAll(stdout)
+	if err != nil {
+		return 0, fmt.Errorf("error reading probe output: %w", err)
+	}
+
+	// The probe's exit status is that of nc unless timeout killed it
+	switch status := strings.TrimSpace(string(data)); status {
+	case "unavailable":
+		return 0, errProbeUnavailable
+	case "0":
+		return probeOpen, nil
+	case "1":
+		return probeRefused, nil
+	case "124":
+		return probeTimedOut, nil
+	default:
+		return 0, fmt.Errorf("error probing %s/%d on %s: exit status %s", network, port, host, status)
+	}
+}
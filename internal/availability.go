@@ -0,0 +1,44 @@
+/*
+	go-swarm is a Go library and ccommand-line tool for managing the creation
+	and maintenance of Docker Swarm cluster.
+
+    Copyright (C) 2021 Sovereign Cloud Australia Pty Ltd
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as published
+    by the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package internal
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aucloud/go-swarm"
+)
+
+// SetAvailability sets the availability of the nodes given by args
+func SetAvailability(ctx context.Context, m *swarm.Manager, args []string, availability string) int {
+	for _, node := range args {
+		if err := m.SetAvailabilityContext(ctx, node, availability); err != nil {
+			fmt.Fprintf(os.Stderr, "error setting availability of %s: %s\n", node, err)
+			return StatusError
+		}
+	}
+
+	fmt.Fprintf(os.Stdout, "Nodes %s successfully set to %s\n", strings.Join(args, ","), availability)
+
+	return Status(ctx, m, nil, 0)
+}
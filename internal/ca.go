@@ -0,0 +1,83 @@
+/*
+	go-swarm is a Go library and ccommand-line tool for managing the creation
+	and maintenance of Docker Swarm cluster.
+
+    Copyright (C) 2021 Sovereign Cloud Australia Pty Ltd
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as published
+    by the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package internal
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aucloud/go-swarm"
+)
+
+func CAInspect(ctx context.Context, m *swarm.Manager) int {
+	rootCA, err := m.RootCAContext(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error getting root CA: %s\n", err)
+		return StatusError
+	}
+
+	fmt.Fprint(os.Stdout, rootCA)
+
+	return StatusOK
+}
+
+func CARotate(ctx context.Context, m *swarm.Manager) int {
+	rootCA, err := m.RotateCAContext(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error rotating root CA: %s\n", err)
+		return StatusError
+	}
+
+	fmt.Fprint(os.Stdout, rootCA)
+
+	return StatusOK
+}
+
+func CAExternal(ctx context.Context, m *swarm.Manager, protocol, url string) int {
+	ca := swarm.ExternalCA{Protocol: protocol, URL: url}
+	if err := m.SetExternalCAContext(ctx, ca); err != nil {
+		fmt.Fprintf(os.Stderr, "error setting external CA: %s\n", err)
+		return StatusError
+	}
+
+	fmt.Fprintf(os.Stdout, "External CA successfully set to %s\n", url)
+
+	return StatusOK
+}
+
+func CACertExpiry(ctx context.Context, m *swarm.Manager, args []string) int {
+	expiry, err := time.ParseDuration(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error parsing certificate expiry: %s\n", err)
+		return StatusError
+	}
+
+	if err := m.SetCertExpiryContext(ctx, expiry); err != nil {
+		fmt.Fprintf(os.Stderr, "error setting certificate expiry: %s\n", err)
+		return StatusError
+	}
+
+	fmt.Fprintf(os.Stdout, "Certificate expiry successfully set to %s\n", expiry)
+
+	return StatusOK
+}
@@ -21,6 +21,8 @@
 package internal
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -28,7 +30,10 @@ import (
 	"github.com/aucloud/go-swarm"
 )
 
-func Create(m *swarm.Manager, args []string, force bool) int {
+// Create creates a new cluster from the Clusterfile given by args or, if
+// resume is not empty, applies the remaining steps from the checkpoint file
+// given by resume
+func Create(ctx context.Context, m *swarm.Manager, args []string, force, plan bool, resume string) int {
 	var (
 		f   io.ReadCloser
 		err error
@@ -59,12 +64,43 @@ func Create(m *swarm.Manager, args []string, force bool) int {
 		return StatusError
 	}
 
-	if err := m.CreateSwarm(cf.Nodes, force); err != nil {
+	if err := m.Configure(cf.Options()...); err != nil {
+		fmt.Fprintf(os.Stderr, "error configuring manager: %s\n", err)
+		return StatusError
+	}
+
+	var p *swarm.Plan
+
+	if resume != "" {
+		checkpoint, err := swarm.ReadCheckpoint(resume)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error reading checkpoint: %s\n", err)
+			return StatusError
+		}
+		p = checkpoint.Remaining()
+	} else {
+		p, err = m.PlanCreateSwarmContext(ctx, cf.Nodes, force)
+		if err != nil {
+			var perr *swarm.PreflightError
+			if errors.As(err, &perr) {
+				printPreflight(os.Stderr, perr.Results)
+			}
+			fmt.Fprintf(os.Stderr, "error planning swarm cluster: %s\n", err)
+			return StatusError
+		}
+	}
+
+	if plan {
+		fmt.Fprint(os.Stdout, p.Diff())
+		return StatusOK
+	}
+
+	if err := m.ApplyPlanContext(ctx, p); err != nil {
 		fmt.Fprintf(os.Stderr, "error creating swarm cluster: %s\n", err)
 		return StatusError
 	}
 
-	node, err := m.GetInfo()
+	node, err := m.GetInfoContext(ctx)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error creating node info: %s\n", err)
 		return StatusError
@@ -72,5 +108,5 @@ func Create(m *swarm.Manager, args []string, force bool) int {
 
 	fmt.Fprintf(os.Stdout, "Swarm Cluster successfully created with id: %s\n", node.Swarm.Cluster.ID)
 
-	return Status(m, nil)
+	return Status(ctx, m, nil, 0)
 }
@@ -30,6 +30,15 @@ const (
 	// DefaultSockPath is the default path to the Docker API's UNIX Socket
 	DefaultSockPath = "/var/run/docker.sock"
 
+	// DefaultTLSCA is the default CA used to verify Docker daemons over TLS
+	DefaultTLSCA = "$HOME/.docker/ca.pem"
+
+	// DefaultTLSCert is the default client certificate used for TLS
+	DefaultTLSCert = "$HOME/.docker/cert.pem"
+
+	// DefaultTLSKey is the default client key used for TLS
+	DefaultTLSKey = "$HOME/.docker/key.pem"
+
 	// MinSwarmClusterNodes is the minimum number of  nodes to form a swam cluster
 	MinSwarmClusterNodes = 1
 )
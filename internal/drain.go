@@ -21,20 +21,58 @@
 package internal
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 
 	"github.com/aucloud/go-swarm"
 )
 
-func Drain(m *swarm.Manager, args []string) int {
-	if err := m.DrainNodes(args); err != nil {
+// printPending prints the tasks that would be left pending if err is a
+// *swarm.CapacityError and returns true, otherwise nothing is printed
+func printPending(w io.Writer, err error) bool {
+	var cerr *swarm.CapacityError
+	if !errors.As(err, &cerr) {
+		return false
+	}
+
+	for _, task := range cerr.Pending {
+		fmt.Fprintf(w, "%s (%s): %s\n", task.Task, task.Node, task.Reason)
+	}
+	fmt.Fprintf(w, "error refusing to drain nodes as %d task(s) would be left pending (use --force to drain anyway)\n", len(cerr.Pending))
+
+	return true
+}
+
+func Drain(ctx context.Context, m *swarm.Manager, args []string, opts swarm.DrainOptions) int {
+	if err := m.DrainNodesContext(ctx, args, opts); err != nil {
+		if printPending(os.Stderr, err) {
+			return StatusError
+		}
 		fmt.Fprintf(os.Stderr, "error draining nodes: %s\n", err)
 		return StatusError
 	}
 
 	fmt.Fprintf(os.Stdout, "Nodes %s successfully drained\n", strings.Join(args, ","))
 
-	return Status(m, nil)
+	return Status(ctx, m, nil, 0)
+}
+
+// Maintain performs maintenance on the nodes given by args one at a time
+func Maintain(ctx context.Context, m *swarm.Manager, args []string, opts swarm.MaintenanceOptions) int {
+	for _, node := range args {
+		if err := m.MaintainNodeContext(ctx, node, opts); err != nil {
+			if printPending(os.Stderr, err) {
+				return StatusError
+			}
+			fmt.Fprintf(os.Stderr, "error performing maintenance on %s: %s\n", node, err)
+			return StatusError
+		}
+		fmt.Fprintf(os.Stdout, "Node %s successfully drained and activated\n", node)
+	}
+
+	return Status(ctx, m, nil, 0)
 }
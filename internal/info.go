@@ -21,20 +21,21 @@
 package internal
 
 import (
+	"context"
 	"fmt"
 	"os"
 
 	"github.com/aucloud/go-swarm"
 )
 
-func Info(m *swarm.Manager, args []string) int {
-	node, err := m.GetInfo()
+func Info(ctx context.Context, m *swarm.Manager, args []string) int {
+	node, err := m.GetInfoContext(ctx)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error getting node info: %s\n", err)
 		return StatusError
 	}
 
-	managers, err := m.GetManagers()
+	managers, err := m.GetManagersContext(ctx)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error getting managers: %s\n", err)
 		return StatusError
@@ -0,0 +1,157 @@
+/*
+	go-swarm is a Go library and ccommand-line tool for managing the creation
+	and maintenance of Docker Swarm cluster.
+
+    Copyright (C) 2021 Sovereign Cloud Australia Pty Ltd
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as published
+    by the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package internal
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aucloud/go-swarm"
+)
+
+// KeyFile is the path of a file the unlock key is saved to which is only
+// readable by the current user
+type KeyFile string
+
+// Save writes key (followed by a newline) to a temporary file (created only
+// readable by the current user) which then replaces the file so that an
+// existing file readable by others never contains the key
+func (f KeyFile) Save(key string) error {
+	path := string(f)
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := fmt.Fprintln(tmp, key); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// writeKey writes key to the file if given or stdout otherwise
+func writeKey(key, keyFile string) error {
+	if keyFile != "" {
+		return KeyFile(keyFile).Save(key)
+	}
+	_, err := fmt.Fprintln(os.Stdout, key)
+	return err
+}
+
+func LockEnable(ctx context.Context, m *swarm.Manager, keyFile string) int {
+	key, err := m.EnableAutolockContext(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error enabling autolock: %s\n", err)
+		return StatusError
+	}
+
+	if err := writeKey(key, keyFile); err != nil {
+		fmt.Fprintf(os.Stderr, "error writing unlock key: %s\n", err)
+		return StatusError
+	}
+
+	return StatusOK
+}
+
+func LockDisable(ctx context.Context, m *swarm.Manager) int {
+	if err := m.DisableAutolockContext(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "error disabling autolock: %s\n", err)
+		return StatusError
+	}
+
+	fmt.Fprintln(os.Stdout, "Autolock successfully disabled")
+
+	return StatusOK
+}
+
+func LockRotate(ctx context.Context, m *swarm.Manager, keyFile string) int {
+	key, err := m.RotateUnlockKeyContext(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error rotating unlock key: %s\n", err)
+		return StatusError
+	}
+
+	if err := writeKey(key, keyFile); err != nil {
+		fmt.Fprintf(os.Stderr, "error writing unlock key: %s\n", err)
+		return StatusError
+	}
+
+	return StatusOK
+}
+
+func LockUnlock(ctx context.Context, m *swarm.Manager, args []string) int {
+	clusterFile := args[0]
+
+	if clusterFile == "-" {
+		fmt.Fprintln(os.Stderr, "error the unlock key is read from stdin so the Clusterfile must be a file")
+		return StatusError
+	}
+
+	f, err := os.Open(clusterFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error reading Clusterfile: %s\n", err)
+		return StatusError
+	}
+
+	cf, err := swarm.ReadClusterfile(f)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error parsing Clusterfile: %s\n", err)
+		return StatusError
+	}
+
+	if err := cf.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "error validating Clusterfile: %s\n", err)
+		return StatusError
+	}
+
+	key, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		fmt.Fprintf(os.Stderr, "error reading unlock key: %s\n", err)
+		return StatusError
+	}
+	key = strings.TrimSpace(key)
+	if key == "" {
+		fmt.Fprintln(os.Stderr, "error no unlock key given on stdin")
+		return StatusError
+	}
+
+	if err := m.UnlockManagersContext(ctx, cf.Nodes, key); err != nil {
+		fmt.Fprintf(os.Stderr, "error unlocking managers: %s\n", err)
+		return StatusError
+	}
+
+	fmt.Fprintln(os.Stdout, "Managers successfully unlocked")
+
+	return StatusOK
+}
@@ -0,0 +1,122 @@
+/*
+	go-swarm is a Go library and ccommand-line tool for managing the creation
+	and maintenance of Docker Swarm cluster.
+
+    Copyright (C) 2021 Sovereign Cloud Australia Pty Ltd
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as published
+    by the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package internal
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/aucloud/go-swarm"
+)
+
+// Preflight runs the pre-flight checks on the nodes of the Clusterfile given
+// by args and displays a pass/fail table of the results
+func Preflight(ctx context.Context, m *swarm.Manager, args []string) int {
+	var (
+		f   io.ReadCloser
+		err error
+	)
+
+	clusterFile := args[0]
+
+	if clusterFile == "-" {
+		f = os.Stdin
+	} else {
+		f, err = os.Open(clusterFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error reading Clusterfile: %s\n", err)
+			return StatusError
+		}
+	}
+
+	cf, err := swarm.ReadClusterfile(f)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error parsing Clusterfile: %s\n", err)
+		return StatusError
+	}
+
+	if err := cf.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "error validating Clusterfile: %s\n", err)
+		return StatusError
+	}
+
+	if err := m.Configure(cf.Options()...); err != nil {
+		fmt.Fprintf(os.Stderr, "error configuring manager: %s\n", err)
+		return StatusError
+	}
+
+	results, err := m.PreflightContext(ctx, cf.Nodes)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error running pre-flight checks: %s\n", err)
+		return StatusError
+	}
+
+	printPreflight(os.Stdout, results)
+
+	if !results.Passed() {
+		fmt.Fprintf(os.Stderr, "error pre-flight checks failed on %s\n", strings.Join(results.Failed(), ", "))
+		return StatusError
+	}
+
+	return StatusOK
+}
+
+// printPreflight displays a table of the pre-flight check results of every
+// node followed by the reason each failed check failed
+func printPreflight(w io.Writer, results swarm.PreflightResults) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+
+	fmt.Fprint(tw, "NODE")
+	for _, name := range swarm.PreflightChecks {
+		fmt.Fprintf(tw, "\t%s", strings.ToUpper(name))
+	}
+	fmt.Fprintln(tw)
+
+	for _, result := range results {
+		fmt.Fprint(tw, result.Node.Hostname)
+		for _, name := range swarm.PreflightChecks {
+			check, ok := result.Check(name)
+			switch {
+			case !ok:
+				fmt.Fprint(tw, "\t-")
+			case check.Err != nil:
+				fmt.Fprint(tw, "\tFAIL")
+			default:
+				fmt.Fprint(tw, "\tPASS")
+			}
+		}
+		fmt.Fprintln(tw)
+	}
+
+	tw.Flush()
+
+	for _, result := range results {
+		for _, check := range result.Checks {
+			if check.Err != nil {
+				fmt.Fprintf(w, "%s %s: %s\n", result.Node.Hostname, check.Name, check.Err)
+			}
+		}
+	}
+}
@@ -21,22 +21,46 @@
 package internal
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/aucloud/go-swarm"
 )
 
-func Status(m *swarm.Manager, args []string) int {
-	nodes, err := m.GetNodes()
+func Status(ctx context.Context, m *swarm.Manager, args []string, certExpiryDays int) int {
+	nodes, err := m.GetNodesContext(ctx)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error getting nodes: %s\n", err)
 		return StatusError
 	}
 
+	// The status is still displayed if the engine versions cannot be checked
+	issues, versionsErr := m.CheckEngineVersionsContext(ctx)
+	if versionsErr != nil {
+		fmt.Fprintf(os.Stderr, "warning error checking engine versions: %s\n", versionsErr)
+	}
+
+	var expiries map[string]swarm.CertExpiry
+	if certExpiryDays > 0 {
+		certExpiries, err := m.GetCertExpiryContext(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error getting certificate expiry: %s\n", err)
+			return StatusError
+		}
+
+		expiries = make(map[string]swarm.CertExpiry)
+		for _, expiry := range certExpiries {
+			expiries[expiry.NodeID] = expiry
+		}
+	}
+
+	var expiring int
 	for _, node := range nodes {
 		fmt.Fprintf(
-			os.Stdout, "%s %s %s %s %s %s\n",
+			os.Stdout, "%s %s %s %s %s %s",
 			node.ID,
 			node.Hostname,
 			node.Status,
@@ -44,6 +68,47 @@ func Status(m *swarm.Manager, args []string) int {
 			node.ManagerStatus,
 			node.EngineVersion,
 		)
+
+		if versionsErr != nil {
+			fmt.Fprintf(os.Stdout, " version=unknown")
+		} else if err, ok := issues[node.ID]; ok {
+			if errors.Is(err, swarm.ErrEngineVersionTooOld) {
+				fmt.Fprintf(os.Stdout, " OUTDATED")
+			} else {
+				fmt.Fprintf(os.Stdout, " SKEWED")
+			}
+		}
+
+		if expiries != nil {
+			expiry, ok := expiries[node.ID]
+			switch {
+			case !ok:
+				// Down nodes are not checked
+			case expiry.Err != nil:
+				fmt.Fprintf(os.Stdout, " cert-expiry=unknown")
+				expiring++
+			case expiry.ExpiresWithin(time.Duration(certExpiryDays) * 24 * time.Hour):
+				fmt.Fprintf(os.Stdout, " cert-expiry=%s EXPIRING", expiry.NotAfter.Format(time.RFC3339))
+				expiring++
+			default:
+				fmt.Fprintf(os.Stdout, " cert-expiry=%s", expiry.NotAfter.Format(time.RFC3339))
+			}
+		}
+
+		fmt.Fprintln(os.Stdout)
+	}
+
+	for _, node := range nodes {
+		if err, ok := issues[node.ID]; ok {
+			fmt.Fprintf(os.Stderr, "warning %s does not comply with the engine version policy: %s\n", node.Hostname, err)
+		}
+	}
+
+	if expiring > 0 {
+		fmt.Fprintf(
+			os.Stderr, "warning %d node certificate(s) expire within %d days (or could not be read)\n",
+			expiring, certExpiryDays,
+		)
 	}
 
 	return StatusOK
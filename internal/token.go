@@ -0,0 +1,52 @@
+/*
+	go-swarm is a Go library and ccommand-line tool for managing the creation
+	and maintenance of Docker Swarm cluster.
+
+    Copyright (C) 2021 Sovereign Cloud Australia Pty Ltd
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as published
+    by the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package internal
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/aucloud/go-swarm"
+)
+
+func RotateToken(ctx context.Context, m *swarm.Manager, args []string) int {
+	tokenTypes := []string{swarm.ManagerRole, swarm.WorkerRole}
+	if len(args) > 0 {
+		tokenTypes = args
+	}
+
+	for _, tokenType := range tokenTypes {
+		if tokenType != swarm.ManagerRole && tokenType != swarm.WorkerRole {
+			fmt.Fprintf(os.Stderr, "error unknown token type %q (expected manager or worker)\n", tokenType)
+			return StatusError
+		}
+
+		if _, err := m.RotateJoinTokenContext(ctx, tokenType); err != nil {
+			fmt.Fprintf(os.Stderr, "error rotating token: %s\n", err)
+			return StatusError
+		}
+
+		fmt.Fprintf(os.Stdout, "Successfully rotated %s join token\n", tokenType)
+	}
+
+	return StatusOK
+}
@@ -21,6 +21,8 @@
 package internal
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -28,7 +30,7 @@ import (
 	"github.com/aucloud/go-swarm"
 )
 
-func Update(m *swarm.Manager, args []string) int {
+func Update(ctx context.Context, m *swarm.Manager, args []string, plan bool) int {
 	var (
 		f   io.ReadCloser
 		err error
@@ -59,12 +61,32 @@ func Update(m *swarm.Manager, args []string) int {
 		return StatusError
 	}
 
-	if err := m.UpdateSwarm(cf.Nodes); err != nil {
+	if err := m.Configure(cf.Options()...); err != nil {
+		fmt.Fprintf(os.Stderr, "error configuring manager: %s\n", err)
+		return StatusError
+	}
+
+	p, err := m.PlanUpdateSwarmContext(ctx, cf.Nodes)
+	if err != nil {
+		var perr *swarm.PreflightError
+		if errors.As(err, &perr) {
+			printPreflight(os.Stderr, perr.Results)
+		}
+		fmt.Fprintf(os.Stderr, "error planning swarm cluster: %s\n", err)
+		return StatusError
+	}
+
+	if plan {
+		fmt.Fprint(os.Stdout, p.Diff())
+		return StatusOK
+	}
+
+	if err := m.ApplyPlanContext(ctx, p); err != nil {
 		fmt.Fprintf(os.Stderr, "error updating swarm cluster: %s\n", err)
 		return StatusError
 	}
 
-	node, err := m.GetInfo()
+	node, err := m.GetInfoContext(ctx)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "error getting node info: %s\n", err)
 		return StatusError
@@ -72,5 +94,5 @@ func Update(m *swarm.Manager, args []string) int {
 
 	fmt.Fprintf(os.Stdout, "Swarm Cluster successfully updated with id: %s\n", node.Swarm.Cluster.ID)
 
-	return Status(m, nil)
+	return Status(ctx, m, nil, 0)
 }
@@ -0,0 +1,116 @@
+/*
+	go-swarm is a Go library and ccommand-line tool for managing the creation
+	and maintenance of Docker Swarm cluster.
+
+    Copyright (C) 2021 Sovereign Cloud Australia Pty Ltd
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as published
+    by the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package swarm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+
+	"github.com/anmitsu/go-shlex"
+	"github.com/aucloud/go-runcmd"
+)
+
+// localRunner is a runcmd.Runner that runs commands locally like
+// runcmd.Local except that its commands can be killed when cancelled
+type localRunner struct{}
+
+func (r *localRunner) Command(cmdline string) (runcmd.CmdWorker, error) {
+	if cmdline == "" {
+		return nil, errors.New("command cannot be empty")
+	}
+
+	argv, err := shlex.Split(cmdline, true)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing cmdline %v: %w", cmdline, err)
+	}
+
+	return &localCmd{cmdline: cmdline, cmd: exec.Command(argv[0], argv[1:]...)}, nil
+}
+
+// localCmd is a runcmd.CmdWorker for a command started by localRunner
+type localCmd struct {
+	cmdline string
+	cmd     *exec.Cmd
+}
+
+func (c *localCmd) Run() ([]string, error) {
+	var buffer bytes.Buffer
+
+	c.SetStdout(&buffer)
+	c.SetStderr(&buffer)
+
+	if err := c.Start(); err != nil {
+		return nil, err
+	}
+
+	err := c.Wait()
+	output := strings.Split(buffer.String(), "\n")
+
+	if err != nil {
+		return nil, runcmd.ExecError{ExecutionError: err, CommandLine: c.cmdline, Output: output}
+	}
+
+	return output, nil
+}
+
+func (c *localCmd) Start() error {
+	return c.cmd.Start()
+}
+
+func (c *localCmd) Wait() error {
+	return c.cmd.Wait()
+}
+
+// Kill kills the command if it has been started
+func (c *localCmd) Kill() error {
+	if c.cmd.Process == nil {
+		return nil
+	}
+	return c.cmd.Process.Kill()
+}
+
+func (c *localCmd) StdinPipe() (io.WriteCloser, error) {
+	return c.cmd.StdinPipe()
+}
+
+func (c *localCmd) StdoutPipe() (io.Reader, error) {
+	return c.cmd.StdoutPipe()
+}
+
+func (c *localCmd) StderrPipe() (io.Reader, error) {
+	return c.cmd.StderrPipe()
+}
+
+func (c *localCmd) SetStdout(w io.Writer) {
+	c.cmd.Stdout = w
+}
+
+func (c *localCmd) SetStderr(w io.Writer) {
+	c.cmd.Stderr = w
+}
+
+func (c *localCmd) GetCommandLine() string {
+	return c.cmdline
+}
@@ -0,0 +1,73 @@
+/*
+	go-swarm is a Go library and ccommand-line tool for managing the creation
+	and maintenance of Docker Swarm cluster.
+
+    Copyright (C) 2021 Sovereign Cloud Australia Pty Ltd
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as published
+    by the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package swarm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestLocalRunnerCancelled tests that a local command is killed when its
+// context is done
+func TestLocalRunnerCancelled(t *testing.T) {
+	assert := assert.New(t)
+
+	switcher, err := NewLocalSwitcher()
+	require.NoError(t, err)
+	require.NoError(t, switcher.Switch(context.Background(), ""))
+
+	m, err := NewManager(switcher)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err = m.runCmd(ctx, "sleep 10")
+	assert.True(errors.Is(err, context.DeadlineExceeded))
+	assert.Less(int64(time.Since(start)), int64(5*time.Second))
+}
+
+// TestLocalRunnerKill tests that killing a local command makes it exit
+func TestLocalRunnerKill(t *testing.T) {
+	assert := assert.New(t)
+
+	worker, err := (&localRunner{}).Command("sleep 10")
+	require.NoError(t, err)
+	require.NoError(t, worker.Start())
+
+	done := make(chan error, 1)
+	go func() { done <- worker.Wait() }()
+
+	assert.NoError(worker.(killer).Kill())
+
+	select {
+	case err := <-done:
+		assert.Error(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("command was not killed")
+	}
+}
@@ -0,0 +1,225 @@
+/*
+	go-swarm is a Go library and ccommand-line tool for managing the creation
+	and maintenance of Docker Swarm cluster.
+
+    Copyright (C) 2021 Sovereign Cloud Australia Pty Ltd
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as published
+    by the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package swarm
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultReadyTimeout is how long to wait by default for a node to be
+	// ready again after maintenance
+	DefaultReadyTimeout = time.Minute * 10 // 10 minutes
+
+	// DefaultRebootCommand is the command run on a node to reboot it. The
+	// reboot is delayed and run in the background so the command returns
+	// before the node goes down.
+	DefaultRebootCommand = `sudo nohup sh -c 'sleep 1; reboot' > /dev/null 2>&1 &`
+
+	bootIDCommand = `cat /proc/sys/kernel/random/boot_id`
+)
+
+// MaintenanceOptions control what MaintainNode does to a node while it is
+// drained
+type MaintenanceOptions struct {
+	// Drain controls how the node is drained
+	Drain DrainOptions
+
+	// Exec is a command run on the node once drained (e.g: to patch the OS)
+	Exec string
+
+	// Reboot reboots the node (after running Exec)
+	Reboot bool
+
+	// RebootCommand is the command run to reboot the node
+	// (DefaultRebootCommand if empty)
+	RebootCommand string
+
+	// ReadyTimeout is how long to wait for the node to be ready again
+	// (DefaultReadyTimeout if zero)
+	ReadyTimeout time.Duration
+}
+
+// MaintainNode drains a node of an existing Docker Swarm cluster, runs the
+// maintenance command given by opts.Exec on the node, reboots the node if
+// opts.Reboot is given, waits for the node to be ready again and finally
+// makes the node active again. If any step fails the node is left drained.
+// Running commands on the node requires a Switcher with a Runner (e.g: SSH).
+// If the node is rebooted and is the manager node currently connected to,
+// another reachable manager is switched to first.
+func (m *Manager) MaintainNode(node string, opts MaintenanceOptions) error {
+	return m.MaintainNodeContext(context.Background(), node, opts)
+}
+
+// MaintainNodeContext is like MaintainNode but the operation is cancelled
+// when ctx is done.
+func (m *Manager) MaintainNodeContext(ctx context.Context, node string, opts MaintenanceOptions) error {
+	if opts.RebootCommand == "" {
+		opts.RebootCommand = DefaultRebootCommand
+	}
+	if opts.ReadyTimeout == 0 {
+		opts.ReadyTimeout = DefaultReadyTimeout
+	}
+
+	if err := m.ensureManager(ctx); err != nil {
+		return fmt.Errorf("error connecting to manager node: %w", err)
+	}
+
+	details, err := m.GetNodeContext(ctx, node)
+	if err != nil {
+		return fmt.Errorf("error getting node details: %w", err)
+	}
+
+	if details.IsDown() {
+		return fmt.Errorf("error node %s is down", node)
+	}
+
+	if opts.Reboot {
+		if err := m.switchFrom(ctx, details); err != nil {
+			return err
+		}
+	}
+
+	if err := m.DrainNodesContext(ctx, []string{node}, opts.Drain); err != nil {
+		return err
+	}
+
+	var bootID string
+
+	if opts.Exec != "" || opts.Reboot {
+		n, err := m.fork()
+		if err != nil {
+			return err
+		}
+		if err := n.SwitchNodeViaContext(ctx, details.Addr()); err != nil {
+			return fmt.Errorf("error switching to node %s: %w", details.Addr(), err)
+		}
+
+		if opts.Reboot {
+			if bootID, err = n.bootID(ctx); err != nil {
+				return fmt.Errorf("error getting boot id of node %s: %w", node, err)
+			}
+		}
+
+		if opts.Exec != "" {
+			log.Infof("Running %q on %s ...", opts.Exec, node)
+			out, err := n.runCmd(ctx, opts.Exec)
+			if err != nil {
+				return fmt.Errorf("error running maintenance command on %s: %w", node, err)
+			}
+			if data, err := ioutil.ReadAll(out); err == nil && len(data) > 0 {
+				log.Debugf("%s: %s", node, data)
+			}
+		}
+
+		if opts.Reboot {
+			log.Infof("Rebooting %s ...", node)
+			if _, err := n.runCmd(ctx, opts.RebootCommand); err != nil {
+				return fmt.Errorf("error rebooting node %s: %w", node, err)
+			}
+		}
+	}
+
+	if err := m.waitReady(ctx, node, details.Addr(), bootID, opts.ReadyTimeout); err != nil {
+		return err
+	}
+
+	return m.SetAvailabilityContext(ctx, node, AvailabilityActive)
+}
+
+// bootID returns an ID of the current node that changes every time the node
+// boots
+func (m *Manager) bootID(ctx context.Context) (string, error) {
+	out, err := m.runCmd(ctx, bootIDCommand)
+	if err != nil {
+		return "", fmt.Errorf("error running boot id command: %w", err)
+	}
+
+	data, err := ioutil.ReadAll(out)
+	if err != nil {
+		return "", fmt.Errorf("error reading boot id command output: %w", err)
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+// waitReady waits for node to be ready. If bootID is not empty the node is
+// being rebooted and must also have booted again (its boot id differs from
+// bootID) which is checked by connecting to the node at addr.
+func (m *Manager) waitReady(ctx context.Context, node, addr, bootID string, timeout time.Duration) error {
+	startedAt := time.Now()
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(m.config.PollInterval)
+	defer ticker.Stop()
+
+	rebooted := bootID == ""
+
+	for {
+		select {
+		case <-ticker.C:
+			if !rebooted {
+				// The node is unreachable while it reboots
+				n, err := m.fork()
+				if err != nil {
+					return err
+				}
+				if err := n.SwitchNodeViaContext(ctx, addr); err != nil {
+					log.WithError(err).Debugf("error connecting to %s (retrying)", node)
+					continue
+				}
+
+				id, err := n.bootID(ctx)
+				if err != nil {
+					log.WithError(err).Debugf("error getting boot id of %s (retrying)", node)
+					continue
+				}
+				if rebooted = id != bootID; !rebooted {
+					continue
+				}
+				log.Infof("%s rebooted after %s", node, time.Since(startedAt))
+			}
+
+			details, err := m.engine().NodeInspect(ctx, node)
+			if err != nil {
+				log.WithError(err).Warnf("error getting node details of %s (retrying)", node)
+				continue
+			}
+
+			if strings.EqualFold(details.Status.State, "ready") {
+				log.Infof("%s is ready after %s", node, time.Since(startedAt))
+				return nil
+			}
+
+			log.Infof("Still waiting for %s to be ready after %s ...", node, time.Since(startedAt))
+		case <-ctx.Done():
+			return fmt.Errorf("error waiting for %s to be ready after %s: %w", node, time.Since(startedAt), ctx.Err())
+		}
+	}
+}
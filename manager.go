@@ -23,51 +23,64 @@ package swarm
 import (
 	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"math/rand"
 	"net"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
-	"go.mills.io/jsonlines"
 
 	"github.com/aucloud/go-runcmd"
 )
 
 const (
-	infoCommand        = `docker info --format "{{ json . }}"`
-	nodesCommand       = `docker node ls --format "{{ json . }}"`
-	tasksCommand       = `docker node ps --format "{{ json .}}" %s`
-	initCommand        = `docker swarm init --advertise-addr %s --listen-addr %s`
-	joinCommand        = `docker swarm join --advertise-addr %s --listen-addr %s --token %s %s:2377`
-	tokenCommand       = `docker swarm join-token -q %s`
-	updateCommand      = `docker node update %s %s`
-	setAvailability    = `--availability %s`
-	labelAdd           = `--label-add %s`
-	availabilityDrain  = `drain`
-	availabilityActive = `active`
+	// AvailabilityActive, AvailabilityPause and AvailabilityDrain are the
+	// availabilities of a node. Tasks are only scheduled on active nodes,
+	// paused nodes keep their tasks and drained nodes have their tasks
+	// moved to other nodes.
+	AvailabilityActive = `active`
+	AvailabilityPause  = `pause`
+	AvailabilityDrain  = `drain`
+)
 
+const (
 	managerToken = "manager"
 	workerToken  = "worker"
-
-	drainTimeout = time.Minute * 10 // 10 minutes
 )
 
 const (
-	DefaultTimeout = time.Minute * 5
+	DefaultTimeout      = time.Minute * 5
+	DefaultPollInterval = time.Second * 5
+	DefaultConcurrency  = 1
 )
 
 type Config struct {
-	Timeout time.Duration
+	Timeout      time.Duration
+	PollInterval time.Duration
+	Concurrency  int
+	Checkpoint   string
+	Rollback     bool
+	RotateTokens bool
+	AutolockKey  func(key string) error
+	Preflight    bool
+	Swarm        SwarmConfig
+	Drain        DrainOptions
+
+	SkipPortChecks bool
+
+	MinEngineVersion     string
+	EnforceEngineVersion bool
 }
 
 func NewDefaultConfig() *Config {
 	return &Config{
-		Timeout: DefaultTimeout,
+		Timeout:      DefaultTimeout,
+		PollInterval: DefaultPollInterval,
+		Concurrency:  DefaultConcurrency,
 	}
 }
 
@@ -88,6 +101,70 @@ func WithTimeout(timeout time.Duration) Option {
 	}
 }
 
+// WithPollInterval sets the interval at which the state of nodes is polled
+// while waiting for them to become reachable, drain or go down
+func WithPollInterval(interval time.Duration) Option {
+	return func(cfg *Config) error {
+		if interval <= 0 {
+			return fmt.Errorf("error invalid poll interval: %s", interval)
+		}
+		cfg.PollInterval = interval
+		return nil
+	}
+}
+
+// WithConcurrency sets the maximum number of nodes that workers are joined
+// and labelled on concurrently. Managers are always joined one at a time.
+func WithConcurrency(n int) Option {
+	return func(cfg *Config) error {
+		if n < 1 {
+			return fmt.Errorf("error invalid concurrency: %d", n)
+		}
+		cfg.Concurrency = n
+		return nil
+	}
+}
+
+// WithCheckpoint saves the progress of applying a plan to the file given by
+// path so that a failed attempt can be resumed (see ReadCheckpoint). The file
+// is removed once the plan has been applied successfully.
+func WithCheckpoint(path string) Option {
+	return func(cfg *Config) error {
+		cfg.Checkpoint = path
+		return nil
+	}
+}
+
+// WithRollback undoes the changes made by a plan that fails to apply by
+// making every node that joined the cluster leave it again (see ApplyPlan)
+func WithRollback() Option {
+	return func(cfg *Config) error {
+		cfg.Rollback = true
+		return nil
+	}
+}
+
+// WithTokenRotation rotates both join tokens once all nodes have joined
+// while applying a plan so that the tokens used can not be used again
+func WithTokenRotation() Option {
+	return func(cfg *Config) error {
+		cfg.RotateTokens = true
+		return nil
+	}
+}
+
+// WithAutolock enables autolock when a new cluster is initialized while
+// applying a plan and calls save once with the unlock key
+func WithAutolock(save func(key string) error) Option {
+	return func(cfg *Config) error {
+		if save == nil {
+			return fmt.Errorf("error autolock key func must not be nil")
+		}
+		cfg.AutolockKey = save
+		return nil
+	}
+}
+
 // NewManager constructs a new Manager type with the provider Switcher
 func NewManager(switcher Switcher, options ...Option) (*Manager, error) {
 	m := &Manager{switcher: switcher, config: NewDefaultConfig()}
@@ -102,6 +179,18 @@ func NewManager(switcher Switcher, options ...Option) (*Manager, error) {
 	return m, nil
 }
 
+// Configure applies further options to the Manager's configuration (e.g:
+// from the `swarm` section of a Clusterfile read after the Manager was
+// constructed)
+func (m *Manager) Configure(options ...Option) error {
+	for _, option := range options {
+		if err := option(m.config); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Switcher returns the current Switcher for the manager being used
 func (m *Manager) Switcher() Switcher {
 	return m.switcher
@@ -112,9 +201,32 @@ func (m *Manager) Runner() runcmd.Runner {
 	return m.Switcher().Runner()
 }
 
+// fork returns a new Manager with a clone of the current Switcher so that
+// operations can be performed on other nodes without switching away from
+// the current node. An error is returned if the Switcher cannot be cloned.
+func (m *Manager) fork() (*Manager, error) {
+	cloner, ok := m.switcher.(Cloner)
+	if !ok {
+		return nil, fmt.Errorf("error switcher %T does not support cloning", m.switcher)
+	}
+
+	switcher := cloner.Clone()
+	if switcher == nil {
+		return nil, fmt.Errorf("error cloning switcher %s", m.switcher)
+	}
+
+	return &Manager{switcher: switcher, config: m.config}, nil
+}
+
 // SwitchNode switches to a new node given by nodeAddr to perform operations on
 func (m *Manager) SwitchNode(nodeAddr string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), m.config.Timeout)
+	return m.SwitchNodeContext(context.Background(), nodeAddr)
+}
+
+// SwitchNodeContext is like SwitchNode but the operation is cancelled when
+// ctx is done.
+func (m *Manager) SwitchNodeContext(ctx context.Context, nodeAddr string) error {
+	ctx, cancel := context.WithTimeout(ctx, m.config.Timeout)
 	defer cancel()
 	if err := m.Switcher().Switch(ctx, nodeAddr); err != nil {
 		log.WithError(err).Errorf("error switching to node %s", nodeAddr)
@@ -127,7 +239,13 @@ func (m *Manager) SwitchNode(nodeAddr string) error {
 // SwitchNodeVia switches to a new node given by nodeAddr by jumping through
 // the current node as a "bastion" host to perform operations on the node.
 func (m *Manager) SwitchNodeVia(nodeAddr string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), m.config.Timeout)
+	return m.SwitchNodeViaContext(context.Background(), nodeAddr)
+}
+
+// SwitchNodeViaContext is like SwitchNodeVia but the operation is cancelled
+// when ctx is done.
+func (m *Manager) SwitchNodeViaContext(ctx context.Context, nodeAddr string) error {
+	ctx, cancel := context.WithTimeout(ctx, m.config.Timeout)
 	defer cancel()
 	if err := m.Switcher().SwitchVia(ctx, nodeAddr); err != nil {
 		log.WithError(err).Errorf("error switching to node %s via %s", nodeAddr, m.Switcher())
@@ -137,14 +255,37 @@ func (m *Manager) SwitchNodeVia(nodeAddr string) error {
 	return nil
 }
 
-func (m *Manager) runCmd(cmd string, args ...string) (io.Reader, error) {
-	if m.Runner() == nil {
+// connectionCloser is implemented by Runners whose connection to the node
+// can be closed (e.g: SSH) which kills any command running on it
+type connectionCloser interface {
+	CloseConnection() error
+}
+
+// killer is implemented by CmdWorkers whose command can be killed directly
+// (e.g: local commands)
+type killer interface {
+	Kill() error
+}
+
+func (m *Manager) runCmd(ctx context.Context, cmd string, args ...string) (io.Reader, error) {
+	return m.runCmdWithInput(ctx, cmd, "", args...)
+}
+
+// runCmdWithInput is like runCmd but writes input to the command's stdin
+// (if not empty) which is then closed
+func (m *Manager) runCmdWithInput(ctx context.Context, cmd, input string, args ...string) (io.Reader, error) {
+	runner := m.Runner()
+	if runner == nil {
 		return nil, fmt.Errorf("error no runner configured")
 	}
 
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("error running cmd: %w", err)
+	}
+
 	log.WithField("args", args).Debugf("running cmd on %s: %s", m.switcher.String(), cmd)
 
-	worker, err := m.Runner().Command(cmd)
+	worker, err := runner.Command(cmd)
 	if err != nil {
 		return nil, fmt.Errorf("error creating worker: %w", err)
 	}
@@ -155,11 +296,48 @@ func (m *Manager) runCmd(cmd string, args ...string) (io.Reader, error) {
 	stderr := &bytes.Buffer{}
 	worker.SetStderr(stderr)
 
+	var stdin io.WriteCloser
+	if input != "" {
+		if stdin, err = worker.StdinPipe(); err != nil {
+			return nil, fmt.Errorf("error getting stdin: %w", err)
+		}
+	}
+
 	if err := worker.Start(); err != nil {
 		return nil, fmt.Errorf("error starting worker: %w", err)
 	}
 
-	if err := worker.Wait(); err != nil {
+	if stdin != nil {
+		go func() {
+			defer stdin.Close()
+			if _, err := io.WriteString(stdin, input); err != nil {
+				log.WithError(err).Warn("error writing stdin")
+			}
+		}()
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- worker.Wait() }()
+
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		// Kill the command directly or by closing the connection it is
+		// running on so that worker.Wait() returns
+		if k, ok := worker.(killer); ok {
+			if err := k.Kill(); err != nil {
+				log.WithError(err).Warnf("error killing cmd on %s", m.switcher.String())
+			}
+		}
+		if c, ok := runner.(connectionCloser); ok {
+			if err := c.CloseConnection(); err != nil {
+				log.WithError(err).Warnf("error closing connection to %s", m.switcher.String())
+			}
+		}
+		return nil, fmt.Errorf("error running worker: %w", ctx.Err())
+	}
+
+	if err != nil {
 		log.WithError(err).
 			WithField("stdout", string(stdout.String())).
 			WithField("stderr", string(stderr.String())).
@@ -173,8 +351,8 @@ func (m *Manager) runCmd(cmd string, args ...string) (io.Reader, error) {
 	return stdout, nil
 }
 
-func (m *Manager) ensureManager() error {
-	node, err := m.GetInfo()
+func (m *Manager) ensureManager(ctx context.Context) error {
+	node, err := m.GetInfoContext(ctx)
 	if err != nil {
 		return fmt.Errorf("error getting node info: %w", err)
 	}
@@ -185,7 +363,7 @@ func (m *Manager) ensureManager() error {
 				log.WithError(err).Warn("error parsing remote manager address (trying next manager): %w", err)
 				continue
 			}
-			if err := m.SwitchNodeVia(host); err != nil {
+			if err := m.SwitchNodeViaContext(ctx, host); err != nil {
 				log.WithError(err).Warn("error switching to remote manager (trying next manager): %w", err)
 				continue
 			}
@@ -197,99 +375,156 @@ func (m *Manager) ensureManager() error {
 	return nil
 }
 
-func (m *Manager) joinSwarm(newNode VMNode, managerNode VMNode, token string) error {
-	if err := m.SwitchNode(newNode.PublicAddress); err != nil {
+func (m *Manager) joinSwarm(ctx context.Context, newNode VMNode, managerNode VMNode, token string, versions []string) error {
+	if err := m.SwitchNodeContext(ctx, newNode.PublicAddress); err != nil {
 		return fmt.Errorf("error switching nodes to %s: %w", newNode.PublicAddress, err)
 	}
 
-	cmd := fmt.Sprintf(
-		joinCommand,
-		newNode.PrivateAddress,
-		newNode.PrivateAddress,
+	if err := m.checkJoin(ctx, newNode, versions); err != nil {
+		return err
+	}
+
+	return m.engine().SwarmJoin(
+		ctx,
+		newNode.AdvertiseAddr(),
+		newNode.ListenAddr(),
+		m.dataPathAddr(newNode),
 		token,
-		managerNode.PrivateAddress,
+		managerNode.RemoteAddr(),
 	)
-	_, err := m.runCmd(cmd)
-	if err != nil {
-		return fmt.Errorf("error running join command: %w", err)
+}
+
+// dataPathAddr returns the data path address of a node, defaulting to the
+// data path address of the swarm config
+func (m *Manager) dataPathAddr(node VMNode) string {
+	if node.DataPathAddress != "" {
+		return node.DataPathAddress
+	}
+	return m.config.Swarm.DataPathAddr
+}
+
+// leaveSwarm makes a node leave the cluster it belongs to
+func (m *Manager) leaveSwarm(ctx context.Context, vm VMNode, force bool) error {
+	if err := m.SwitchNodeContext(ctx, vm.PublicAddress); err != nil {
+		return fmt.Errorf("error switching to node %s: %w", vm.PublicAddress, err)
+	}
+
+	if err := m.engine().SwarmLeave(ctx, force); err != nil {
+		return fmt.Errorf("error leaving swarm: %w", err)
 	}
 
 	return nil
 }
 
+// LabelNode reconciles the Swarm labels of a node with the labels given by
+// the node's labels tag. Labels that are missing or have a different value
+// are added and labels that are no longer present are removed.
 func (m *Manager) LabelNode(node VMNode) error {
-	if err := m.SwitchNode(node.PublicAddress); err != nil {
-		return fmt.Errorf("error switching nodes to %s: %w", node, err)
+	return m.LabelNodeContext(context.Background(), node)
+}
+
+// LabelNodeContext is like LabelNode but the operation is cancelled when ctx
+// is done.
+func (m *Manager) LabelNodeContext(ctx context.Context, node VMNode) error {
+	if err := m.SwitchNodeContext(ctx, node.PublicAddress); err != nil {
+		return fmt.Errorf("error switching nodes to %s: %w", node.PublicAddress, err)
 	}
 
-	info, err := m.GetInfo()
+	info, err := m.GetInfoContext(ctx)
 	if err != nil {
 		return fmt.Errorf("error getting node info: %w", err)
 	}
 
-	labelOptions := []string{}
+	if err := m.ensureManager(ctx); err != nil {
+		return fmt.Errorf("error connecting to manager node: %w", err)
+	}
+
+	details, err := m.GetNodeContext(ctx, info.Swarm.NodeID)
+	if err != nil {
+		return fmt.Errorf("error getting node details: %w", err)
+	}
 
-	labels, err := ParseLabels(node.GetTag(LabelsTag))
+	change, err := labelChange(node, details.Spec.Labels)
 	if err != nil {
 		log.WithError(err).Error("error parsing labels")
 		return fmt.Errorf("error parsing labels: %w", err)
 	}
 
-	if labels == nil || len(labels) == 0 {
-		// No labels, nothing to do.
+	if change == nil {
+		// Labels are up-to-date, nothing to do.
 		return nil
 	}
 
-	for key, values := range labels {
-		label := key
-		if values != nil || len(values) > 0 {
-			label += fmt.Sprintf("=%s", strings.Join(values, ","))
-		}
-		labelOptions = append(labelOptions, fmt.Sprintf(labelAdd, label))
+	update := NodeUpdate{AddLabels: change.Add, RemoveLabels: change.Remove}
+	return m.engine().NodeUpdate(ctx, info.Swarm.NodeID, update)
+}
+
+// switchFrom switches to another reachable manager if the current node is
+// the given node as the node is about to become unavailable (e.g: it is
+// being rebooted or removed)
+func (m *Manager) switchFrom(ctx context.Context, node NodeDetails) error {
+	info, err := m.GetInfoContext(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting node info: %w", err)
 	}
 
-	if err := m.ensureManager(); err != nil {
-		return fmt.Errorf("error connecting to manager node: %w", err)
+	if info.Swarm.NodeID != node.ID {
+		return nil
 	}
 
-	cmd := fmt.Sprintf(
-		updateCommand,
-		strings.Join(labelOptions, " "),
-		info.Swarm.NodeID,
-	)
-	_, err = m.runCmd(cmd)
+	nodes, err := m.GetNodesContext(ctx)
 	if err != nil {
-		return fmt.Errorf("error running update command: %w", err)
+		return fmt.Errorf("error getting current nodes: %w", err)
 	}
 
-	return nil
-}
+	for _, other := range nodes {
+		if other.ID == node.ID || !other.IsReachable() || strings.EqualFold(other.Status, "down") {
+			continue
+		}
 
-// GetInfo returns information about the current node
-func (m *Manager) GetInfo() (NodeInfo, error) {
-	var node NodeInfo
+		details, err := m.GetNodeContext(ctx, other.ID)
+		if err != nil {
+			log.WithError(err).Warnf("error getting node details of %s (trying next manager)", other.Hostname)
+			continue
+		}
 
-	cmd := infoCommand
-	out, err := m.runCmd(cmd)
-	if err != nil {
-		return NodeInfo{}, fmt.Errorf("error running info command: %w", err)
-	}
+		// The other manager is switched to directly as the current node
+		// cannot be used to reach it once unavailable
+		if err := m.SwitchNodeContext(ctx, details.Addr()); err != nil {
+			log.WithError(err).Warnf("error switching to manager %s (trying next manager)", other.Hostname)
+			continue
+		}
 
-	data, err := ioutil.ReadAll(out)
-	if err != nil {
-		return NodeInfo{}, fmt.Errorf("error reading info command output: %w", err)
+		log.Infof("Switched from %s to manager %s", node.Description.Hostname, other.Hostname)
+		return nil
 	}
 
-	if err := json.Unmarshal(data, &node); err != nil {
-		return NodeInfo{}, fmt.Errorf("error parsing json data: %s", err)
-	}
+	return fmt.Errorf(
+		"error no other reachable manager to switch to from %s (connect to another manager)",
+		node.Description.Hostname,
+	)
+}
+
+// GetInfo returns information about the current node
+func (m *Manager) GetInfo() (NodeInfo, error) {
+	return m.GetInfoContext(context.Background())
+}
 
-	return node, nil
+// GetInfoContext is like GetInfo but the operation is cancelled when ctx is
+// done.
+func (m *Manager) GetInfoContext(ctx context.Context) (NodeInfo, error) {
+	return m.engine().Info(ctx)
 }
 
 // GetManagers returns a list of manager nodes and their information
 func (m *Manager) GetManagers() ([]NodeInfo, error) {
-	node, err := m.GetInfo()
+	return m.GetManagersContext(context.Background())
+}
+
+// GetManagersContext is like GetManagers but the operation is cancelled when
+// ctx is done.
+func (m *Manager) GetManagersContext(ctx context.Context) ([]NodeInfo, error) {
+	node, err := m.GetInfoContext(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("error getting node info: %w", err)
 	}
@@ -300,10 +535,10 @@ func (m *Manager) GetManagers() ([]NodeInfo, error) {
 		if err != nil {
 			return nil, fmt.Errorf("error parsing remote manager address: %w", err)
 		}
-		if err := m.SwitchNode(host); err != nil {
+		if err := m.SwitchNodeContext(ctx, host); err != nil {
 			return nil, fmt.Errorf("error switching nodes to %s: %w", host, err)
 		}
-		node, err := m.GetInfo()
+		node, err := m.GetInfoContext(ctx)
 		if err != nil {
 			return nil, fmt.Errorf("error getting manager node info: %w", err)
 		}
@@ -315,324 +550,847 @@ func (m *Manager) GetManagers() ([]NodeInfo, error) {
 
 // GetNodes returns all nodes in the cluster
 func (m *Manager) GetNodes() ([]NodeStatus, error) {
-	if err := m.ensureManager(); err != nil {
+	return m.GetNodesContext(context.Background())
+}
+
+// GetNodesContext is like GetNodes but the operation is cancelled when ctx
+// is done.
+func (m *Manager) GetNodesContext(ctx context.Context) ([]NodeStatus, error) {
+	if err := m.ensureManager(ctx); err != nil {
 		return nil, fmt.Errorf("error connecting to manager node: %w", err)
 	}
 
-	cmd := nodesCommand
-	stdout, err := m.runCmd(cmd)
-	if err != nil {
-		return nil, fmt.Errorf("error running nodes command: %w", err)
-	}
+	return m.engine().NodeList(ctx)
+}
 
-	var nodes []NodeStatus
+// GetNode returns detailed information about a node in the cluster given
+// by its ID or hostname
+func (m *Manager) GetNode(node string) (NodeDetails, error) {
+	return m.GetNodeContext(context.Background(), node)
+}
 
-	if err := jsonlines.Decode(stdout, &nodes); err != nil {
-		return nil, fmt.Errorf("error parsing json data: %s", err)
+// GetNodeContext is like GetNode but the operation is cancelled when ctx is
+// done.
+func (m *Manager) GetNodeContext(ctx context.Context, node string) (NodeDetails, error) {
+	if err := m.ensureManager(ctx); err != nil {
+		return NodeDetails{}, fmt.Errorf("error connecting to manager node: %w", err)
 	}
 
-	return nodes, nil
+	return m.engine().NodeInspect(ctx, node)
 }
 
-// CreateSwarm creates a new Docker Swarm cluster given a set of nodes
-func (m *Manager) CreateSwarm(vms VMNodes, force bool) error {
+// PlanCreateSwarm computes the Plan for creating a new Docker Swarm cluster
+// given a set of nodes without making any changes to the nodes.
+func (m *Manager) PlanCreateSwarm(vms VMNodes, force bool) (*Plan, error) {
+	return m.PlanCreateSwarmContext(context.Background(), vms, force)
+}
+
+// PlanCreateSwarmContext is like PlanCreateSwarm but the operation is
+// cancelled when ctx is done.
+func (m *Manager) PlanCreateSwarmContext(ctx context.Context, vms VMNodes, force bool) (*Plan, error) {
+	if m.config.Preflight {
+		if err := m.preflight(ctx, vms); err != nil {
+			return nil, err
+		}
+	}
+
 	managers := vms.FilterByTag(RoleTag, ManagerRole)
 
 	if force {
 		log.Warnf("skipping manager validation and forcing creation of cluster with %d managers", len(managers))
 	} else {
 		if !(len(managers) == 3 || len(managers) == 5) {
-			return fmt.Errorf("error expected 3 or 5 managers but got %d", len(managers))
+			return nil, fmt.Errorf("error expected 3 or 5 managers but got %d", len(managers))
 		}
 	}
 
+	if len(managers) == 0 {
+		return nil, fmt.Errorf("error no managers found")
+	}
+
+	// A previous attempt may have failed part way through leaving behind a
+	// partially formed cluster which is completed rather than re-created
+	clusterID, leader, err := m.findCluster(ctx, managers)
+	if err != nil {
+		return nil, err
+	}
+
+	if clusterID != "" {
+		log.Infof("Found existing swarm cluster %s on %s, planning remaining nodes", clusterID, leader.Hostname)
+		return m.planResumeSwarm(ctx, vms, clusterID, leader)
+	}
+
 	workers := vms.FilterByTag(RoleTag, WorkerRole)
 
 	// Pick a random manager out of the candidates
 	randomIndex := rand.Intn(len(managers))
 	manager := managers[randomIndex]
 
-	if err := m.SwitchNode(manager.PublicAddress); err != nil {
-		return fmt.Errorf("error switching to a manager node: %w", err)
+	plan := &Plan{Init: true, Leader: manager, Workers: workers, Swarm: m.config.Swarm}
+
+	for _, newManager := range managers {
+		// Skip the leader we will create the swarm with
+		if newManager.PublicAddress == manager.PublicAddress {
+			continue
+		}
+		plan.Managers = append(plan.Managers, newManager)
 	}
 
-	node, err := m.GetInfo()
+	labels, err := m.planLabels(ctx, vms, nil)
 	if err != nil {
-		return fmt.Errorf("error getting node info: %w", err)
+		return nil, err
 	}
+	plan.Labels = labels
 
-	clusterID := node.Swarm.Cluster.ID
+	return plan, nil
+}
 
-	if clusterID != "" {
-		return fmt.Errorf("error swarm cluster with id %s already exists", clusterID)
+// findCluster looks for a cluster that any of the given managers are already
+// a manager of and returns the cluster's ID and the manager found. An empty
+// cluster ID is returned if none of the managers are part of any cluster.
+func (m *Manager) findCluster(ctx context.Context, managers VMNodes) (string, VMNode, error) {
+	var (
+		clusterID string
+		leader    VMNode
+	)
+
+	for _, manager := range managers {
+		if err := m.SwitchNodeContext(ctx, manager.PublicAddress); err != nil {
+			return "", VMNode{}, fmt.Errorf("error switching to a manager node: %w", err)
+		}
+
+		node, err := m.GetInfoContext(ctx)
+		if err != nil {
+			return "", VMNode{}, fmt.Errorf("error getting node info: %w", err)
+		}
+
+		id := node.Swarm.Cluster.ID
+
+		switch {
+		case id == "" && node.Swarm.LocalNodeState == "active":
+			return "", VMNode{}, fmt.Errorf("error manager %s is already part of a swarm as a worker", manager.Hostname)
+		case id == "":
+			continue
+		case clusterID == "":
+			clusterID, leader = id, manager
+		case id != clusterID:
+			return "", VMNode{}, fmt.Errorf("error managers belong to different swarm clusters %s and %s", clusterID, id)
+		}
 	}
 
-	cmd := fmt.Sprintf(initCommand, manager.PrivateAddress, manager.PrivateAddress)
-	if _, err := m.runCmd(cmd); err != nil {
-		return fmt.Errorf("error running init command: %w", err)
+	return clusterID, leader, nil
+}
+
+// planResumeSwarm computes the Plan for completing a partially formed
+// cluster. Nodes that have already joined the cluster are skipped.
+func (m *Manager) planResumeSwarm(ctx context.Context, vms VMNodes, clusterID string, leader VMNode) (*Plan, error) {
+	if err := m.SwitchNodeContext(ctx, leader.PublicAddress); err != nil {
+		return nil, fmt.Errorf("error switching to a manager node: %w", err)
 	}
 
-	// Refresh node and get new Swarm Clsuter ID
-	node, err = m.GetInfo()
+	nodes, err := m.GetNodesContext(ctx)
 	if err != nil {
-		return fmt.Errorf("error refreshing node info: %w", err)
+		return nil, fmt.Errorf("error getting current nodes: %w", err)
 	}
-	clusterID = node.Swarm.Cluster.ID
 
-	managerToken, err := m.JoinToken(managerToken)
-	if err != nil {
-		return fmt.Errorf("error getting manager join token: %w", err)
+	// Nodes that left (or never finished joining) are listed as down
+	members := make(map[string]NodeStatus)
+	for _, node := range nodes {
+		if !strings.EqualFold(node.Status, "down") {
+			members[node.Hostname] = node
+		}
 	}
 
-	workerToken, err := m.JoinToken(workerToken)
+	node, err := m.GetInfoContext(ctx)
 	if err != nil {
-		return fmt.Errorf("error getting worker join token: %w", err)
+		return nil, fmt.Errorf("error getting node info: %w", err)
 	}
 
-	// Join remaining managers
-	for _, newManager := range managers {
-		// Skip the leader we just created the swarm with
-		if newManager.PublicAddress == manager.PublicAddress {
+	plan := &Plan{
+		ClusterID: clusterID,
+		Leader:    leader,
+		Update:    planSwarmUpdate(node.Swarm.Cluster, m.config.Swarm),
+	}
+
+	for _, vm := range vms {
+		if _, ok := members[vm.Hostname]; ok {
 			continue
 		}
-
-		if err := m.joinSwarm(newManager, manager, managerToken); err != nil {
-			return fmt.Errorf(
-				"error joining manager %s to %s on swarm clsuter %s: %w",
-				newManager.PublicAddress, manager.PublicAddress,
-				clusterID, err,
-			)
+		switch {
+		case vm.HasTag(RoleTag, ManagerRole):
+			plan.Managers = append(plan.Managers, vm)
+		case vm.HasTag(RoleTag, WorkerRole):
+			plan.Workers = append(plan.Workers, vm)
 		}
 	}
 
-	// Join workers
-	for _, worker := range workers {
-		if err := m.joinSwarm(worker, manager, workerToken); err != nil {
-			return fmt.Errorf(
-				"error joining worker %s to %s on swarm clsuter %s: %w",
-				worker.PublicAddress, manager.PublicAddress,
-				clusterID, err,
-			)
-		}
+	labels, err := m.planLabels(ctx, vms, members)
+	if err != nil {
+		return nil, err
 	}
+	plan.Labels = labels
 
-	if err := m.SwitchNode(manager.PublicAddress); err != nil {
-		return fmt.Errorf("error switching to manager node: %w", err)
-	}
+	return plan, nil
+}
+
+// planLabels computes the label changes for the given nodes. The current
+// labels of nodes that are members of the cluster are looked up, other nodes
+// are assumed to have no labels.
+func (m *Manager) planLabels(ctx context.Context, vms VMNodes, members map[string]NodeStatus) ([]LabelChange, error) {
+	var changes []LabelChange
 
-	// Label nodes
 	for _, vm := range vms {
-		if err := m.LabelNode(vm); err != nil {
-			return fmt.Errorf("error labelling node %s: %w", vm, err)
+		var current map[string]string
+
+		if _, ok := members[vm.Hostname]; ok {
+			details, err := m.GetNodeContext(ctx, vm.Hostname)
+			if err != nil {
+				return nil, fmt.Errorf("error getting node details for %s: %w", vm.Hostname, err)
+			}
+			current = details.Spec.Labels
+		}
+
+		change, err := labelChange(vm, current)
+		if err != nil {
+			return nil, err
+		}
+		if change != nil {
+			changes = append(changes, *change)
 		}
 	}
 
-	if err := m.SwitchNode(manager.PublicAddress); err != nil {
-		return fmt.Errorf("error switching to manager node: %w", err)
+	return changes, nil
+}
+
+// CreateSwarm creates a new Docker Swarm cluster given a set of nodes
+func (m *Manager) CreateSwarm(vms VMNodes, force bool) error {
+	return m.CreateSwarmContext(context.Background(), vms, force)
+}
+
+// CreateSwarmContext is like CreateSwarm but the operation is cancelled when
+// ctx is done.
+func (m *Manager) CreateSwarmContext(ctx context.Context, vms VMNodes, force bool) error {
+	plan, err := m.PlanCreateSwarmContext(ctx, vms, force)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	return m.ApplyPlanContext(ctx, plan)
 }
 
-// UpdateSwarm updates an existing Docker Swarm cluster by adding any
-// missing manager or worker nodes that aren't already part of the cluster
-func (m *Manager) UpdateSwarm(vms VMNodes) error {
-	currentNodes := make(map[string]bool)
+// PlanUpdateSwarm computes the Plan for updating an existing Docker Swarm
+// cluster to match the given set of nodes without making any changes.
+func (m *Manager) PlanUpdateSwarm(vms VMNodes) (*Plan, error) {
+	return m.PlanUpdateSwarmContext(context.Background(), vms)
+}
+
+// PlanUpdateSwarmContext is like PlanUpdateSwarm but the operation is
+// cancelled when ctx is done.
+func (m *Manager) PlanUpdateSwarmContext(ctx context.Context, vms VMNodes) (*Plan, error) {
+	if m.config.Preflight {
+		if err := m.preflight(ctx, vms); err != nil {
+			return nil, err
+		}
+	}
+
+	currentNodes := make(map[string]NodeStatus)
 	desiredNodes := make(map[string]bool)
 
-	nodes, err := m.GetNodes()
+	nodes, err := m.GetNodesContext(ctx)
 	if err != nil {
-		return fmt.Errorf("error getting current nodes: %w", err)
+		return nil, fmt.Errorf("error getting current nodes: %w", err)
 	}
 	for _, node := range nodes {
-		currentNodes[node.Hostname] = true
+		currentNodes[node.Hostname] = node
 	}
 	for _, vm := range vms {
 		desiredNodes[vm.Hostname] = true
 	}
 
-	var newNodes VMNodes
+	var (
+		newNodes          VMNodes
+		nodesToPromote    []string
+		nodesToDemote     []string
+		remainingManagers VMNodes
+	)
 
 	for _, vm := range vms {
-		if _, ok := currentNodes[vm.Hostname]; !ok {
+		node, ok := currentNodes[vm.Hostname]
+		if !ok {
 			newNodes = append(newNodes, vm)
+			continue
+		}
+
+		switch {
+		case vm.HasTag(RoleTag, ManagerRole) && !node.IsManager():
+			nodesToPromote = append(nodesToPromote, vm.Hostname)
+		case vm.HasTag(RoleTag, WorkerRole) && node.IsManager():
+			nodesToDemote = append(nodesToDemote, vm.Hostname)
+		case vm.HasTag(RoleTag, ManagerRole) && node.IsManager():
+			remainingManagers = append(remainingManagers, vm)
 		}
 	}
 
-	var nodesToDrain []string
+	var nodesToRemove []string
 
 	for node := range currentNodes {
 		if _, ok := desiredNodes[node]; !ok {
-			nodesToDrain = append(nodesToDrain, node)
+			nodesToRemove = append(nodesToRemove, node)
 		}
 	}
+	sort.Strings(nodesToRemove)
+	sortForQuorum(nodesToRemove, currentNodes)
+	sortForQuorum(nodesToDemote, currentNodes)
 
 	managers := vms.FilterByTag(RoleTag, ManagerRole)
 	if !(len(managers) == 3 || len(managers) == 5) {
-		return fmt.Errorf("error expected 3 or 5 managers but got %d", len(managers))
+		return nil, fmt.Errorf("error expected 3 or 5 managers but got %d", len(managers))
+	}
+
+	// Only managers that are already (and will remain) managers of the
+	// cluster can be joined through
+	if len(remainingManagers) == 0 {
+		return nil, fmt.Errorf("error none of the managers are managers of the cluster")
 	}
 
 	// Pick a random manager out of the candidates
-	randomIndex := rand.Intn(len(managers))
-	manager := managers[randomIndex]
+	randomIndex := rand.Intn(len(remainingManagers))
+	manager := remainingManagers[randomIndex]
 
-	newWorkers := newNodes.FilterByTag(RoleTag, WorkerRole)
-	newManagers := newNodes.FilterByTag(RoleTag, ManagerRole)
+	node, err := m.GetInfoContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error getting node info: %w", err)
+	}
 
-	if err := m.ensureManager(); err != nil {
-		return fmt.Errorf("error connecting to manager node: %w", err)
+	clusterID := node.Swarm.Cluster.ID
+
+	if clusterID == "" {
+		return nil, fmt.Errorf("error no swarm cluster found")
+	}
+
+	plan := &Plan{
+		ClusterID: clusterID,
+		Leader:    manager,
+		Managers:  newNodes.FilterByTag(RoleTag, ManagerRole),
+		Workers:   newNodes.FilterByTag(RoleTag, WorkerRole),
+		Promote:   nodesToPromote,
+		Demote:    nodesToDemote,
+		Remove:    nodesToRemove,
+		Update:    planSwarmUpdate(node.Swarm.Cluster, m.config.Swarm),
 	}
 
-	node, err := m.GetInfo()
+	if err := plan.CheckQuorum(nodes); err != nil {
+		return nil, err
+	}
+
+	// Reconcile labels on every node not just newly joined ones
+	labels, err := m.planLabels(ctx, vms, currentNodes)
 	if err != nil {
-		return fmt.Errorf("error getting node info: %w", err)
+		return nil, err
+	}
+	plan.Labels = labels
+
+	return plan, nil
+}
+
+// UpdateSwarm updates an existing Docker Swarm cluster by adding any
+// missing manager or worker nodes that aren't already part of the cluster,
+// promoting or demoting nodes whose role has changed and removing any nodes
+// that are no longer part of the given set of nodes
+func (m *Manager) UpdateSwarm(vms VMNodes) error {
+	return m.UpdateSwarmContext(context.Background(), vms)
+}
+
+// UpdateSwarmContext is like UpdateSwarm but the operation is cancelled when
+// ctx is done.
+func (m *Manager) UpdateSwarmContext(ctx context.Context, vms VMNodes) error {
+	plan, err := m.PlanUpdateSwarmContext(ctx, vms)
+	if err != nil {
+		return err
 	}
 
+	return m.ApplyPlanContext(ctx, plan)
+}
+
+// ApplyPlan applies a Plan previously computed by PlanCreateSwarm or
+// PlanUpdateSwarm. When updating an existing cluster the plan is refused if
+// the cluster the leader belongs to is not the one the plan was made for.
+//
+// If applying the plan fails and rollback is enabled (see WithRollback)
+// every node that joined the cluster is made to leave it in reverse order
+// and a *RollbackError is returned.
+func (m *Manager) ApplyPlan(plan *Plan) error {
+	return m.ApplyPlanContext(context.Background(), plan)
+}
+
+// ApplyPlanContext is like ApplyPlan but the operation is cancelled when ctx
+// is done.
+func (m *Manager) ApplyPlanContext(ctx context.Context, plan *Plan) error {
+	cp := newCheckpointer(m.config.Checkpoint, plan)
+	joined := &joinedNodes{}
+
+	if err := m.applyPlan(ctx, plan, cp, joined); err != nil {
+		if m.config.Rollback {
+			return m.rollback(plan, joined.list(), cp, err)
+		}
+		return err
+	}
+
+	cp.remove()
+
+	return nil
+}
+
+func (m *Manager) applyPlan(ctx context.Context, plan *Plan, cp *checkpointer, joined *joinedNodes) error {
+	manager := plan.Leader
+
+	if err := m.SwitchNodeContext(ctx, manager.PublicAddress); err != nil {
+		return fmt.Errorf("error switching to a manager node: %w", err)
+	}
+
+	if plan.Init {
+		// Nodes are tracked before joining so a node left part way through
+		// joining is also rolled back
+		joined.add(manager)
+
+		cfg := plan.Swarm
+		cfg.DataPathAddr = m.dataPathAddr(manager)
+		if err := m.engine().SwarmInit(ctx, manager.AdvertiseAddr(), manager.ListenAddr(), cfg); err != nil {
+			return fmt.Errorf("error initializing swarm: %w", err)
+		}
+
+		if m.config.AutolockKey != nil {
+			key, err := m.EnableAutolockContext(ctx)
+			if err != nil {
+				return err
+			}
+			if err := m.config.AutolockKey(key); err != nil {
+				return fmt.Errorf("error writing unlock key: %w", err)
+			}
+		}
+	}
+
+	// Refresh node and get the Swarm Clsuter ID
+	node, err := m.GetInfoContext(ctx)
+	if err != nil {
+		return fmt.Errorf("error refreshing node info: %w", err)
+	}
 	clusterID := node.Swarm.Cluster.ID
 
-	if clusterID == "" {
-		return fmt.Errorf("error no swarm cluster found")
+	if plan.Init {
+		cp.init(clusterID)
 	}
 
-	managerToken, err := m.JoinToken(managerToken)
+	if !plan.Init && clusterID != plan.ClusterID {
+		return fmt.Errorf(
+			"error plan is for swarm cluster %s but %s belongs to %q",
+			plan.ClusterID, manager.PublicAddress, clusterID,
+		)
+	}
+
+	managerToken, err := m.JoinTokenContext(ctx, managerToken)
 	if err != nil {
 		return fmt.Errorf("error getting manager join token: %w", err)
 	}
 
-	workerToken, err := m.JoinToken(workerToken)
+	workerToken, err := m.JoinTokenContext(ctx, workerToken)
 	if err != nil {
 		return fmt.Errorf("error getting worker join token: %w", err)
 	}
 
-	// Join new managers
-	for _, newManager := range newManagers {
-		if err := m.joinSwarm(newManager, manager, managerToken); err != nil {
+	nodes, err := m.GetNodesContext(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting current nodes: %w", err)
+	}
+
+	if !plan.Init {
+		// Re-check the plan against the current state of the cluster
+		if err := plan.CheckQuorum(nodes); err != nil {
+			return err
+		}
+	}
+
+	// New nodes are checked against the engine versions of the managers
+	versions := managerVersions(nodes)
+
+	if plan.Update != nil {
+		if err := m.engine().SwarmUpdate(ctx, *plan.Update); err != nil {
+			return fmt.Errorf("error updating swarm settings: %w", err)
+		}
+		cp.done(stepUpdate)
+		log.Infof("Successfully updated settings of swarm cluster %s", clusterID)
+	}
+
+	// Join new managers one at a time waiting for each to become reachable
+	for _, newManager := range plan.Managers {
+		joined.add(newManager)
+		if err := m.joinSwarm(ctx, newManager, manager, managerToken, versions); err != nil {
 			return fmt.Errorf(
 				"error joining manager %s to %s on swarm clsuter %s: %w",
 				newManager.PublicAddress, manager.PublicAddress,
 				clusterID, err,
 			)
 		}
-		if err := m.LabelNode(newManager); err != nil {
-			return fmt.Errorf("error labelling manager: %w", err)
+
+		if err := m.SwitchNodeContext(ctx, manager.PublicAddress); err != nil {
+			return fmt.Errorf("error switching to manager node: %w", err)
+		}
+
+		if err := m.waitForReachable(ctx, newManager.Hostname); err != nil {
+			return err
+		}
+
+		cp.done(step("join", newManager.Hostname))
+	}
+
+	if err := m.SwitchNodeContext(ctx, manager.PublicAddress); err != nil {
+		return fmt.Errorf("error switching to manager node: %w", err)
+	}
+
+	// Promote workers before any managers are demoted or removed so the
+	// number of managers never drops below what is required for a quorum
+	for _, node := range plan.Promote {
+		if err := m.PromoteNodeContext(ctx, node); err != nil {
+			return fmt.Errorf("error promoting node %s: %w", node, err)
+		}
+
+		if err := m.waitForReachable(ctx, node); err != nil {
+			return err
 		}
+
+		cp.done(step("promote", node))
 	}
 
 	// Join new workers
-	for _, newWorker := range newWorkers {
-		if err := m.joinSwarm(newWorker, manager, workerToken); err != nil {
+	if err := m.forEach(plan.Workers, func(n *Manager, worker VMNode) error {
+		joined.add(worker)
+		if err := n.joinSwarm(ctx, worker, manager, workerToken, versions); err != nil {
 			return fmt.Errorf(
 				"error joining worker %s to %s on swarm clsuter %s: %w",
-				newWorker.PublicAddress, manager.PublicAddress,
+				worker.PublicAddress, manager.PublicAddress,
 				clusterID, err,
 			)
 		}
-		if err := m.LabelNode(newWorker); err != nil {
-			return fmt.Errorf("error labelling worker: %w", err)
+		cp.done(step("join", worker.Hostname))
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if m.config.RotateTokens {
+		// The tokens are no longer needed once every node has joined
+		for _, tokenType := range []string{ManagerRole, WorkerRole} {
+			if _, err := m.RotateJoinTokenContext(ctx, tokenType); err != nil {
+				return err
+			}
 		}
 	}
 
+	// Label nodes
+	var labelled VMNodes
+	for _, label := range plan.Labels {
+		labelled = append(labelled, label.Node)
+	}
+	if err := m.forEach(labelled, func(n *Manager, vm VMNode) error {
+		if err := n.LabelNodeContext(ctx, vm); err != nil {
+			return fmt.Errorf("error labelling node %s: %w", vm.Hostname, err)
+		}
+		cp.done(step("label", vm.Hostname))
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if len(plan.Demote) > 0 || len(plan.Remove) > 0 {
+		if err := m.SwitchNodeContext(ctx, manager.PublicAddress); err != nil {
+			return fmt.Errorf("error switching to manager node: %w", err)
+		}
+	}
+
+	for _, node := range plan.Demote {
+		if err := m.DemoteNodeContext(ctx, node); err != nil {
+			return fmt.Errorf("error demoting node %s: %w", node, err)
+		}
+
+		cp.done(step("demote", node))
+	}
+
 	// Remove old nodes
-	if err := m.DrainNodes(nodesToDrain); err != nil {
-		log.WithError(err).Error("error ddraining old nodes")
-		return fmt.Errorf("error draining old nodes: %w", err)
+	for _, node := range plan.Remove {
+		if err := m.removeNode(ctx, node); err != nil {
+			log.WithError(err).Error("error removing old nodes")
+			return fmt.Errorf("error removing old nodes: error removing node %s: %w", node, err)
+		}
+
+		cp.done(step("remove", node))
 	}
 
-	if err := m.SwitchNode(manager.PublicAddress); err != nil {
+	if err := m.SwitchNodeContext(ctx, manager.PublicAddress); err != nil {
 		return fmt.Errorf("error switching to manager node: %w", err)
 	}
 
 	return nil
 }
 
-func (m *Manager) getTasks(node string) (Tasks, error) {
-	cmd := fmt.Sprintf(tasksCommand, node)
-	stdout, err := m.runCmd(cmd)
-	if err != nil {
-		return nil, fmt.Errorf("error running tasks command: %w", err)
+// forEach calls fn for each of the given nodes running up to the configured
+// concurrency at a time. Each call is given its own fork of the Manager so
+// that it may switch nodes independently. No further calls are started once
+// a call has failed and the error of the first node (in order) that failed
+// is returned.
+func (m *Manager) forEach(vms VMNodes, fn func(n *Manager, vm VMNode) error) error {
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed bool
+	)
+
+	errs := make([]error, len(vms))
+	sem := make(chan struct{}, m.config.Concurrency)
+
+	for i, vm := range vms {
+		sem <- struct{}{}
+
+		mu.Lock()
+		stop := failed
+		mu.Unlock()
+		if stop {
+			<-sem
+			break
+		}
+
+		n, err := m.fork()
+		if err != nil {
+			<-sem
+			errs[i] = err
+			break
+		}
+
+		wg.Add(1)
+		go func(i int, n *Manager, vm VMNode) {
+			defer func() { <-sem }()
+			defer wg.Done()
+
+			if err := fn(n, vm); err != nil {
+				log.WithError(err).Errorf("error on node %s", vm.Hostname)
+				mu.Lock()
+				errs[i] = err
+				failed = true
+				mu.Unlock()
+			}
+		}(i, n, vm)
 	}
 
-	var tasks Tasks
+	wg.Wait()
 
-	if err := jsonlines.Decode(stdout, &tasks); err != nil {
-		return nil, fmt.Errorf("error parsing json data: %s", err)
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
 	}
 
-	return tasks, nil
+	return nil
 }
 
-func (m *Manager) drainNode(node string) error {
-	startedAt := time.Now()
+// JoinToken retrieves the current join token for the given type
+// "manager" or "worker" from any of the managers in the cluster
+func (m *Manager) JoinToken(tokenType string) (string, error) {
+	return m.JoinTokenContext(context.Background(), tokenType)
+}
+
+// JoinTokenContext is like JoinToken but the operation is cancelled when ctx
+// is done.
+func (m *Manager) JoinTokenContext(ctx context.Context, tokenType string) (string, error) {
+	return m.engine().JoinToken(ctx, tokenType)
+}
+
+// RotateJoinToken rotates the join token for the given type "manager" or
+// "worker" and returns the new token. The previous token can no longer be
+// used to join the cluster.
+func (m *Manager) RotateJoinToken(tokenType string) (string, error) {
+	return m.RotateJoinTokenContext(context.Background(), tokenType)
+}
+
+// RotateJoinTokenContext is like RotateJoinToken but the operation is
+// cancelled when ctx is done.
+func (m *Manager) RotateJoinTokenContext(ctx context.Context, tokenType string) (string, error) {
+	if err := m.ensureManager(ctx); err != nil {
+		return "", fmt.Errorf("error connecting to manager node: %w", err)
+	}
 
-	cmd := fmt.Sprintf(updateCommand, fmt.Sprintf(setAvailability, availabilityDrain), node)
-	_, err := m.runCmd(cmd)
+	token, err := m.engine().RotateJoinToken(ctx, tokenType)
 	if err != nil {
-		return fmt.Errorf("error running update command: %w", err)
+		return "", fmt.Errorf("error rotating %s join token: %w", tokenType, err)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	log.Infof("Successfully rotated %s join token", tokenType)
+
+	return token, nil
+}
+
+// waitForNodeDown blocks until the node given by its ID or hostname is
+// reported as down by the cluster or the configured timeout expires
+func (m *Manager) waitForNodeDown(ctx context.Context, node string) error {
+	startedAt := time.Now()
+
+	ctx, cancel := context.WithTimeout(ctx, m.config.Timeout)
 	defer cancel()
 
-	ticker := time.NewTicker(time.Second * 5)
+	ticker := time.NewTicker(m.config.PollInterval)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
-			elapsed := time.Since(startedAt)
-
-			tasks, err := m.getTasks(node)
+			details, err := m.GetNodeContext(ctx, node)
 			if err != nil {
-				log.WithError(err).Warnf("error getting tasks from node %s (retrying)", node)
+				log.WithError(err).Warnf("error inspecting node %s (retrying)", node)
 				continue
 			}
 
-			if tasks.AllShutdown() {
-				log.Infof("Successfully drained %s after %s", node, elapsed)
+			if details.IsDown() {
 				return nil
 			}
 
-			log.Infof("Still waiting for %s to drain after %s ...", node, elapsed)
+			log.Infof("Still waiting for %s to go down after %s ...", node, time.Since(startedAt))
 		case <-ctx.Done():
-			elapsed := time.Since(startedAt)
-			log.Errorf("timed out waiting for %s to drain after %s", node, elapsed)
-			return fmt.Errorf("error timed out waiting for %s to drain after %s", node, elapsed)
+			return fmt.Errorf("error waiting for %s to go down after %s: %w", node, time.Since(startedAt), ctx.Err())
+		}
+	}
+}
+
+// removeNode removes a single node from the cluster. The node is drained
+// (see WithDrainOptions), demoted if it is a manager, made to leave the swarm
+// and is then removed from the cluster. Nodes that are already down (e.g:
+// the VM was destroyed) cannot leave the swarm themselves and are only
+// demoted and removed. If the node is the manager node currently connected
+// to another reachable manager is switched to first.
+func (m *Manager) removeNode(ctx context.Context, node string) error {
+	details, err := m.GetNodeContext(ctx, node)
+	if err != nil {
+		return fmt.Errorf("error getting node details: %w", err)
+	}
+
+	if err := m.switchFrom(ctx, details); err != nil {
+		return err
+	}
+
+	down := details.IsDown()
+
+	if !down {
+		if err := m.drainNode(ctx, node, m.config.Drain); err != nil {
+			return fmt.Errorf("error draining node: %w", err)
+		}
+	}
+
+	if details.IsManager() {
+		if err := m.demoteNode(ctx, node); err != nil {
+			return err
+		}
+	}
+
+	if !down {
+		// Leave the swarm on the node itself via the current manager
+		n, err := m.fork()
+		if err != nil {
+			return err
+		}
+		if err := n.SwitchNodeViaContext(ctx, details.Addr()); err != nil {
+			return fmt.Errorf("error switching to node %s: %w", details.Addr(), err)
+		}
+		if err := n.engine().SwarmLeave(ctx, false); err != nil {
+			return fmt.Errorf("error leaving swarm: %w", err)
+		}
+
+		if err := m.waitForNodeDown(ctx, node); err != nil {
+			return err
 		}
 	}
 
-	// Unreachable
+	if err := m.engine().NodeRemove(ctx, node); err != nil {
+		return fmt.Errorf("error removing node from cluster: %w", err)
+	}
+
+	log.Infof("Successfully removed %s", node)
+
+	return nil
+}
+
+func (m *Manager) demoteNode(ctx context.Context, node string) error {
+	if err := m.ensureQuorum(ctx, node); err != nil {
+		return err
+	}
+
+	if err := m.engine().NodeUpdate(ctx, node, NodeUpdate{Role: WorkerRole}); err != nil {
+		return fmt.Errorf("error demoting node: %w", err)
+	}
+
+	return nil
+}
+
+// PromoteNode promotes a worker node given by its ID or hostname to a manager
+func (m *Manager) PromoteNode(node string) error {
+	return m.PromoteNodeContext(context.Background(), node)
+}
+
+// PromoteNodeContext is like PromoteNode but the operation is cancelled when
+// ctx is done.
+func (m *Manager) PromoteNodeContext(ctx context.Context, node string) error {
+	if err := m.ensureManager(ctx); err != nil {
+		return fmt.Errorf("error connecting to manager node: %w", err)
+	}
+
+	if err := m.engine().NodeUpdate(ctx, node, NodeUpdate{Role: ManagerRole}); err != nil {
+		return fmt.Errorf("error promoting node: %w", err)
+	}
+
+	log.Infof("Successfully promoted %s", node)
+
+	return nil
 }
 
-// DrainNodes drains one or more nodes from an existing Docker Swarm cluster
-// and blocks until there are no more tasks running on thoese nodes.
-func (m *Manager) DrainNodes(nodes []string) error {
-	if err := m.ensureManager(); err != nil {
+// DemoteNode demotes a manager node given by its ID or hostname to a worker.
+// Demoting a manager is refused if the remaining managers would not have a
+// quorum.
+func (m *Manager) DemoteNode(node string) error {
+	return m.DemoteNodeContext(context.Background(), node)
+}
+
+// DemoteNodeContext is like DemoteNode but the operation is cancelled when
+// ctx is done.
+func (m *Manager) DemoteNodeContext(ctx context.Context, node string) error {
+	if err := m.ensureManager(ctx); err != nil {
 		return fmt.Errorf("error connecting to manager node: %w", err)
 	}
 
-	for _, node := range nodes {
-		if err := m.drainNode(node); err != nil {
-			log.WithError(err).Errorf("error draining node: %s", node)
-			return fmt.Errorf("error draining node %s: %w", node, err)
-		}
+	if err := m.demoteNode(ctx, node); err != nil {
+		return err
 	}
 
+	log.Infof("Successfully demoted %s", node)
+
 	return nil
 }
 
-// JoinToken retrieves the current join token for the given type
-// "manager" or "worker" from any of the managers in the cluster
-func (m *Manager) JoinToken(tokenType string) (string, error) {
-	cmd := fmt.Sprintf(tokenCommand, tokenType)
-	stdout, err := m.runCmd(cmd)
-	if err != nil {
-		return "", fmt.Errorf("error running token command: %w", err)
+// RemoveNodes removes one or more nodes from an existing Docker Swarm cluster.
+// Each node is drained (see WithDrainOptions), demoted if it is a manager,
+// leaves the swarm and is finally removed from the cluster's list of nodes.
+func (m *Manager) RemoveNodes(nodes []string) error {
+	return m.RemoveNodesContext(context.Background(), nodes)
+}
+
+// RemoveNodesContext is like RemoveNodes but the operation is cancelled when
+// ctx is done.
+func (m *Manager) RemoveNodesContext(ctx context.Context, nodes []string) error {
+	if err := m.ensureManager(ctx); err != nil {
+		return fmt.Errorf("error connecting to manager node: %w", err)
 	}
 
-	data, err := ioutil.ReadAll(stdout)
-	if err != nil {
-		return "", fmt.Errorf("error reading stdout: %w", err)
+	for _, node := range nodes {
+		if err := m.removeNode(ctx, node); err != nil {
+			log.WithError(err).Errorf("error removing node: %s", node)
+			return fmt.Errorf("error removing node %s: %w", node, err)
+		}
 	}
 
-	return strings.TrimSpace(string(data)), nil
+	return nil
 }
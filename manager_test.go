@@ -0,0 +1,1323 @@
+/*
+	go-swarm is a Go library and ccommand-line tool for managing the creation
+	and maintenance of Docker Swarm cluster.
+
+    Copyright (C) 2021 Sovereign Cloud Australia Pty Ltd
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as published
+    by the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package swarm_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/aucloud/go-swarm"
+	"github.com/aucloud/go-swarm/swarmtest"
+)
+
+// testVMs returns a set of VM nodes with the given number of managers
+// (dm1, dm2, ...) and workers (dw1, dw2, ...)
+func testVMs(managers, workers int) swarm.VMNodes {
+	var vms swarm.VMNodes
+	for i := 1; i <= managers; i++ {
+		vms = append(vms, testVM(fmt.Sprintf("dm%d", i), i, swarm.ManagerRole))
+	}
+	for i := 1; i <= workers; i++ {
+		vms = append(vms, testVM(fmt.Sprintf("dw%d", i), 100+i, swarm.WorkerRole))
+	}
+	return vms
+}
+
+func testVM(hostname string, n int, role string) swarm.VMNode {
+	return swarm.VMNode{
+		Hostname:       hostname,
+		PublicAddress:  fmt.Sprintf("10.0.0.%d", n),
+		PrivateAddress: fmt.Sprintf("172.16.0.%d", n),
+		Tags:           map[string]string{swarm.RoleTag: role},
+	}
+}
+
+func testManager(t *testing.T, cluster *swarmtest.Cluster) *swarm.Manager {
+	m, err := swarm.NewManager(
+		cluster.Switcher(),
+		swarm.WithTimeout(time.Second),
+		swarm.WithPollInterval(time.Millisecond),
+	)
+	require.NoError(t, err)
+	return m
+}
+
+// testSwarm returns the given number of managers and workers with a fake
+// cluster of them on which a swarm has been created and a manager for it
+func testSwarm(t *testing.T, managers, workers int) (swarm.VMNodes, *swarmtest.Cluster, *swarm.Manager) {
+	vms := testVMs(managers, workers)
+	cluster := swarmtest.NewCluster(vms)
+	m := testManager(t, cluster)
+	require.NoError(t, m.CreateSwarm(vms, false))
+	return vms, cluster, m
+}
+
+func roles(cluster *swarmtest.Cluster) map[string]string {
+	roles := make(map[string]string)
+	for _, node := range cluster.Members() {
+		roles[node.Hostname] = node.Role
+	}
+	return roles
+}
+
+// TestCreateSwarm tests creating a new cluster with the roles and labels of the
+// Clusterfile.
+func TestCreateSwarm(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	vms := testVMs(3, 2)
+	vms[3].Tags[swarm.LabelsTag] = "zone=a&ssd"
+
+	cluster := swarmtest.NewCluster(vms)
+	m := testManager(t, cluster)
+
+	require.NoError(m.CreateSwarm(vms, false))
+
+	assert.Equal(swarmtest.ClusterID, cluster.ClusterID())
+	assert.Equal(map[string]string{
+		"dm1": swarm.ManagerRole,
+		"dm2": swarm.ManagerRole,
+		"dm3": swarm.ManagerRole,
+		"dw1": swarm.WorkerRole,
+		"dw2": swarm.WorkerRole,
+	}, roles(cluster))
+	assert.Equal(map[string]string{"zone": "a", "ssd": ""}, cluster.Node("dw1").Labels)
+
+	// Creating the same cluster again is a no-op
+	plan, err := m.PlanCreateSwarm(vms, false)
+	require.NoError(err)
+	assert.True(plan.Empty())
+}
+
+// TestCreateSwarmResume tests a failed create is resumed by planning again
+// without initializing a new swarm.
+func TestCreateSwarmResume(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	vms := testVMs(3, 3)
+	cluster := swarmtest.NewCluster(vms)
+	cluster.FailOnce("dw2", "docker swarm join", errors.New("timeout"))
+	m := testManager(t, cluster)
+
+	require.Error(m.CreateSwarm(vms, false))
+
+	plan, err := m.PlanCreateSwarm(vms, false)
+	require.NoError(err)
+	assert.False(plan.Init)
+	assert.Equal(swarmtest.ClusterID, plan.ClusterID)
+	assert.Empty(plan.Managers)
+
+	// dw3 was never joined as joins stop at the first failure
+	require.Len(plan.Workers, 2)
+	assert.Equal("dw2", plan.Workers[0].Hostname)
+	assert.Equal("dw3", plan.Workers[1].Hostname)
+
+	require.NoError(m.ApplyPlan(plan))
+	assert.Len(cluster.Members(), 6)
+
+	var inits int
+	for _, cmd := range cluster.Commands() {
+		if strings.HasPrefix(cmd.Command, "docker swarm init") {
+			inits++
+		}
+	}
+	assert.Equal(1, inits)
+}
+
+// TestCreateSwarmCheckpoint tests a failed create writes a checkpoint of the
+// remaining plan which is removed once applied.
+func TestCreateSwarmCheckpoint(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	checkpoint := filepath.Join(t.TempDir(), "checkpoint.json")
+
+	vms := testVMs(3, 2)
+	vms[3].Tags[swarm.LabelsTag] = "zone=a"
+	vms[4].Tags[swarm.LabelsTag] = "zone=b"
+
+	cluster := swarmtest.NewCluster(vms)
+	cluster.FailOn("dw2", "docker swarm join", errors.New("timeout"))
+
+	m, err := swarm.NewManager(
+		cluster.Switcher(),
+		swarm.WithPollInterval(time.Millisecond),
+		swarm.WithCheckpoint(checkpoint),
+	)
+	require.NoError(err)
+
+	require.Error(m.CreateSwarm(vms, false))
+
+	cp, err := swarm.ReadCheckpoint(checkpoint)
+	require.NoError(err)
+	assert.Equal(swarmtest.ClusterID, cp.ClusterID)
+
+	plan := cp.Remaining()
+	assert.False(plan.Init)
+	assert.Equal(swarmtest.ClusterID, plan.ClusterID)
+	assert.Empty(plan.Managers)
+	require.Len(plan.Workers, 1)
+	assert.Equal("dw2", plan.Workers[0].Hostname)
+	require.Len(plan.Labels, 2)
+
+	cluster.ClearFailures()
+	require.NoError(m.ApplyPlan(plan))
+
+	assert.Len(cluster.Members(), 5)
+	assert.Equal(map[string]string{"zone": "b"}, cluster.Node("dw2").Labels)
+	assert.NoFileExists(checkpoint)
+}
+
+// TestCreateSwarmParallel tests joining and labelling nodes concurrently.
+func TestCreateSwarmParallel(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	vms := testVMs(3, 10)
+	for _, vm := range vms {
+		vm.Tags[swarm.LabelsTag] = "host=" + vm.Hostname
+	}
+
+	cluster := swarmtest.NewCluster(vms)
+	m, err := swarm.NewManager(
+		cluster.Switcher(),
+		swarm.WithPollInterval(time.Millisecond),
+		swarm.WithConcurrency(4),
+	)
+	require.NoError(err)
+
+	require.NoError(m.CreateSwarm(vms, false))
+
+	assert.Len(cluster.Members(), 13)
+	for _, vm := range vms {
+		assert.Equal(map[string]string{"host": vm.Hostname}, cluster.Node(vm.Hostname).Labels)
+	}
+}
+
+// TestCreateSwarmParallelFailure tests a failed concurrent join is reported.
+func TestCreateSwarmParallelFailure(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	vms := testVMs(3, 6)
+	cluster := swarmtest.NewCluster(vms)
+	cluster.FailOn("dw3", "docker swarm join", errors.New("connection refused"))
+
+	m, err := swarm.NewManager(
+		cluster.Switcher(),
+		swarm.WithPollInterval(time.Millisecond),
+		swarm.WithConcurrency(3),
+	)
+	require.NoError(err)
+
+	err = m.CreateSwarm(vms, false)
+	assert.Error(err)
+	assert.Contains(err.Error(), "10.0.0.103")
+}
+
+// TestCreateSwarmJoinFailure tests a failed join leaves the node unlabelled and
+// outside the cluster.
+func TestCreateSwarmJoinFailure(t *testing.T) {
+	assert := assert.New(t)
+
+	vms := testVMs(3, 1)
+	cluster := swarmtest.NewCluster(vms)
+	cluster.FailOn("dw1", "docker swarm join", errors.New("connection refused"))
+	m := testManager(t, cluster)
+
+	err := m.CreateSwarm(vms, false)
+	assert.Error(err)
+	assert.Contains(err.Error(), "connection refused")
+	assert.Nil(cluster.Node("dw1").Labels)
+	assert.Equal("", cluster.Node("dw1").Role)
+}
+
+// TestUpdateSwarm tests adding, removing and labelling nodes of an existing
+// cluster.
+func TestUpdateSwarm(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	vms := testVMs(3, 3)
+	cluster := swarmtest.NewCluster(append(vms, testVM("dw4", 104, swarm.WorkerRole)))
+	m := testManager(t, cluster)
+
+	require.NoError(m.CreateSwarm(vms, false))
+
+	// Remove dw2, add dw4 and label dw3
+	updated := swarm.VMNodes{vms[0], vms[1], vms[2], vms[3], vms[5], testVM("dw4", 104, swarm.WorkerRole)}
+	updated[4].Tags[swarm.LabelsTag] = "zone=b"
+
+	plan, err := m.PlanUpdateSwarm(updated)
+	require.NoError(err)
+	assert.Equal([]string{"dw2"}, plan.Remove)
+	assert.Len(plan.Workers, 1)
+	assert.Len(plan.Labels, 1)
+
+	require.NoError(m.ApplyPlan(plan))
+
+	assert.Equal(map[string]string{
+		"dm1": swarm.ManagerRole,
+		"dm2": swarm.ManagerRole,
+		"dm3": swarm.ManagerRole,
+		"dw1": swarm.WorkerRole,
+		"dw3": swarm.WorkerRole,
+		"dw4": swarm.WorkerRole,
+	}, roles(cluster))
+	assert.Equal(map[string]string{"zone": "b"}, cluster.Node("dw3").Labels)
+
+	plan, err = m.PlanUpdateSwarm(updated)
+	require.NoError(err)
+	assert.True(plan.Empty())
+}
+
+// TestUpdateSwarmRoles tests promoting and demoting nodes whose role changed.
+func TestUpdateSwarmRoles(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	vms, cluster, m := testSwarm(t, 3, 2)
+
+	// Swap the roles of dm3 and dw1
+	vms[2].Tags[swarm.RoleTag] = swarm.WorkerRole
+	vms[3].Tags[swarm.RoleTag] = swarm.ManagerRole
+
+	require.NoError(m.UpdateSwarm(vms))
+
+	assert.Equal(swarm.WorkerRole, cluster.Node("dm3").Role)
+	assert.Equal(swarm.ManagerRole, cluster.Node("dw1").Role)
+}
+
+// TestCreateSwarmCancelled tests nothing is created once the context is
+// cancelled.
+func TestCreateSwarmCancelled(t *testing.T) {
+	assert := assert.New(t)
+
+	vms := testVMs(3, 1)
+	cluster := swarmtest.NewCluster(vms)
+	m := testManager(t, cluster)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := m.CreateSwarmContext(ctx, vms, false)
+	assert.True(errors.Is(err, context.Canceled))
+	assert.Empty(cluster.ClusterID())
+}
+
+// TestDrainNodesDeadline tests draining stops when the caller's deadline
+// passes.
+func TestDrainNodesDeadline(t *testing.T) {
+	assert := assert.New(t)
+
+	_, cluster, m := testSwarm(t, 3, 1)
+
+	// Tasks can never be listed so the drain never completes
+	cluster.FailOn("", `docker node ps --format "{{ json .}}" dw1`, errors.New("rpc error"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+
+	err := m.DrainNodesContext(ctx, []string{"dw1"}, swarm.DrainOptions{})
+	assert.True(errors.Is(err, context.DeadlineExceeded))
+	assert.Equal("drain", cluster.Node("dw1").Availability)
+}
+
+// TestDrainNodesTimeout tests the drain timeout applies within a later
+// deadline.
+func TestDrainNodesTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	_, cluster, m := testSwarm(t, 3, 1)
+
+	cluster.FailOn("", `docker node ps --format "{{ json .}}" dw1`, errors.New("rpc error"))
+
+	// The drain timeout applies even if the caller's deadline is later
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	startedAt := time.Now()
+	err := m.DrainNodesContext(ctx, []string{"dw1"}, swarm.DrainOptions{Timeout: time.Millisecond * 50})
+	assert.True(errors.Is(err, context.DeadlineExceeded))
+	assert.Less(int64(time.Since(startedAt)), int64(time.Second*10))
+}
+
+// TestDrainNodesOptions tests the concurrency, continue on error and maximum
+// drained percentage options of `DrainOptions`.
+func TestDrainNodesOptions(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	_, cluster, m := testSwarm(t, 3, 3)
+
+	// dw2 never drains so it times out while the others carry on
+	cluster.FailOn("", `docker node ps --format "{{ json .}}" dw2`, errors.New("rpc error"))
+
+	opts := swarm.DrainOptions{
+		Timeout:         time.Millisecond * 50,
+		Concurrency:     2,
+		ContinueOnError: true,
+	}
+	err := m.DrainNodes([]string{"dw1", "dw2", "dw3"}, opts)
+
+	var derr *swarm.DrainError
+	require.True(errors.As(err, &derr))
+	assert.Len(derr.Failed, 1)
+	assert.True(errors.Is(derr.Failed["dw2"], context.DeadlineExceeded))
+	for _, hostname := range []string{"dw1", "dw2", "dw3"} {
+		assert.Equal("drain", cluster.Node(hostname).Availability)
+	}
+
+	// Half the cluster is already drained
+	err = m.DrainNodes([]string{"dm3"}, swarm.DrainOptions{MaxDrainedPercent: 50})
+	assert.Error(err)
+	assert.Equal("active", cluster.Node("dm3").Availability)
+
+	err = m.DrainNodes([]string{"dm3"}, swarm.DrainOptions{MaxDrainedPercent: 101})
+	assert.Error(err)
+}
+
+// TestDrainNodesStopOnError tests draining stops at the first node that fails.
+func TestDrainNodesStopOnError(t *testing.T) {
+	assert := assert.New(t)
+
+	_, cluster, m := testSwarm(t, 3, 2)
+
+	cluster.FailOn("", `docker node ps --format "{{ json .}}" dw1`, errors.New("rpc error"))
+
+	err := m.DrainNodes([]string{"dw1", "dw2"}, swarm.DrainOptions{Timeout: time.Millisecond * 50})
+	assert.True(errors.Is(err, context.DeadlineExceeded))
+	assert.Equal("drain", cluster.Node("dw1").Availability)
+	assert.Equal("active", cluster.Node("dw2").Availability)
+}
+
+// TestDrainNodesCapacity tests nodes are only drained if their tasks fit on the
+// remaining nodes unless forced.
+func TestDrainNodesCapacity(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	_, cluster, m := testSwarm(t, 3, 3)
+
+	replicas := uint64(3)
+	cluster.AddService(swarm.Service{
+		ID: "db-id",
+		Spec: swarm.ServiceSpec{
+			Name: "db",
+			Mode: swarm.ServiceMode{Replicated: &swarm.ReplicatedService{Replicas: &replicas}},
+			TaskTemplate: swarm.TaskSpec{
+				Resources: &swarm.ResourceRequirements{
+					Reservations: &swarm.Resources{NanoCPUs: 1.5e9, MemoryBytes: 1 << 30},
+				},
+				Placement: &swarm.Placement{Constraints: []string{"node.role==worker"}},
+			},
+		},
+	})
+	cluster.AddService(swarm.Service{
+		ID: "agent-id",
+		Spec: swarm.ServiceSpec{
+			Name: "agent",
+			Mode: swarm.ServiceMode{Global: &swarm.GlobalService{}},
+		},
+	})
+
+	for i, hostname := range []string{"dw1", "dw2", "dw3"} {
+		cluster.AddTask(hostname, swarm.TaskStatus{
+			Name:         fmt.Sprintf("db.%d", i+1),
+			CurrentState: "Running 1 minute ago",
+			DesiredState: "Running",
+		})
+		cluster.AddTask(hostname, swarm.TaskStatus{
+			Name:         "agent." + cluster.Node(hostname).ID,
+			CurrentState: "Running 1 minute ago",
+			DesiredState: "Running",
+		})
+	}
+
+	// The other workers only have half a CPU left
+	err := m.DrainNodes([]string{"dw1"})
+
+	var cerr *swarm.CapacityError
+	require.True(errors.As(err, &cerr))
+	require.Len(cerr.Pending, 1)
+	assert.Equal("dw1", cerr.Pending[0].Node)
+	assert.Equal("db.1", cerr.Pending[0].Task)
+	assert.Equal("db", cerr.Pending[0].Service)
+	assert.Contains(cerr.Pending[0].Reason, "insufficient resources on 2 eligible node(s)")
+	assert.Equal("active", cluster.Node("dw1").Availability)
+
+	// With more CPUs the task fits on the other workers
+	cluster.Node("dw2").NCPU = 4
+	assert.NoError(m.DrainNodes([]string{"dw1"}))
+	assert.Equal("drain", cluster.Node("dw1").Availability)
+
+	// Draining the remaining workers leaves no node satisfying the
+	// constraints of their tasks
+	err = m.DrainNodes([]string{"dw2", "dw3"})
+	require.True(errors.As(err, &cerr))
+	require.Len(cerr.Pending, 2)
+	assert.Equal("no active node satisfies its placement constraints", cerr.Pending[0].Reason)
+
+	assert.NoError(m.DrainNodes([]string{"dw2", "dw3"}, swarm.DrainOptions{Force: true}))
+	assert.Equal("drain", cluster.Node("dw3").Availability)
+}
+
+// TestSetAvailability tests setting and validating a node's availability.
+func TestSetAvailability(t *testing.T) {
+	assert := assert.New(t)
+
+	_, cluster, m := testSwarm(t, 3, 1)
+
+	assert.NoError(m.SetAvailability("dw1", swarm.AvailabilityPause))
+	assert.Equal("pause", cluster.Node("dw1").Availability)
+
+	assert.NoError(m.SetAvailability("dw1", swarm.AvailabilityActive))
+	assert.Equal("active", cluster.Node("dw1").Availability)
+
+	assert.Error(m.SetAvailability("dw1", "offline"))
+}
+
+// TestMaintainNode tests draining, maintaining, rebooting and reactivating a
+// node including the manager connected to.
+func TestMaintainNode(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	_, cluster, m := testSwarm(t, 3, 2)
+
+	var patched []string
+	cluster.Handle("apt-get upgrade -y", func(node *swarmtest.Node) (string, error) {
+		// Maintenance runs on the node once it has been drained
+		assert.Equal("drain", node.Availability)
+		patched = append(patched, node.Hostname)
+		return "", nil
+	})
+
+	opts := swarm.MaintenanceOptions{Exec: "apt-get upgrade -y", Reboot: true}
+	require.NoError(m.MaintainNode("dw1", opts))
+	assert.Equal([]string{"dw1"}, patched)
+	assert.Equal(1, cluster.Node("dw1").Boots)
+	assert.Equal("active", cluster.Node("dw1").Availability)
+
+	// A failed maintenance command leaves the node drained
+	cluster.FailOn("dw2", "apt-get", errors.New("exit status 100"))
+	assert.Error(m.MaintainNode("dw2", opts))
+	assert.Equal(0, cluster.Node("dw2").Boots)
+	assert.Equal("drain", cluster.Node("dw2").Availability)
+
+	// Rebooting the manager connected to switches to another manager
+	info, err := m.GetInfo()
+	require.NoError(err)
+	current := info.Name
+
+	require.NoError(m.MaintainNode(current, swarm.MaintenanceOptions{Reboot: true}))
+	assert.Equal(1, cluster.Node(current).Boots)
+	assert.Equal("active", cluster.Node(current).Availability)
+
+	info, err = m.GetInfo()
+	require.NoError(err)
+	assert.NotEqual(current, info.Name)
+	assert.True(info.IsManager())
+}
+
+// TestRollingMaintenance tests maintaining every node in batches with workers
+// first and the leader last.
+func TestRollingMaintenance(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	vms, cluster, m := testSwarm(t, 3, 3)
+
+	replicas := uint64(2)
+	cluster.AddService(swarm.Service{
+		ID: "web-id",
+		Spec: swarm.ServiceSpec{
+			Name: "web",
+			Mode: swarm.ServiceMode{Replicated: &swarm.ReplicatedService{Replicas: &replicas}},
+		},
+	})
+	for i, hostname := range []string{"dw1", "dw2"} {
+		cluster.AddTask(hostname, swarm.TaskStatus{
+			Name:         fmt.Sprintf("web.%d", i+1),
+			CurrentState: "Running 1 minute ago",
+			DesiredState: "Running",
+		})
+	}
+
+	var (
+		leader  string
+		patched []string
+	)
+	for _, node := range cluster.Members() {
+		if node.Leader {
+			leader = node.Hostname
+		}
+	}
+
+	cluster.Handle("uname -r", func(node *swarmtest.Node) (string, error) {
+		patched = append(patched, node.Hostname)
+		return "5.10.0\n", nil
+	})
+
+	opts := swarm.RollingMaintenanceOptions{
+		Maintenance: swarm.MaintenanceOptions{Exec: "uname -r", Reboot: true},
+		Concurrency: 2,
+	}
+	require.NoError(m.RollingMaintenance(vms, opts))
+
+	// Workers first (two at a time) and then managers with the leader last
+	require.Len(patched, 6)
+	assert.ElementsMatch([]string{"dw1", "dw2"}, patched[:2])
+	assert.Equal("dw3", patched[2])
+	assert.ElementsMatch([]string{"dm1", "dm2", "dm3"}, patched[3:])
+	assert.Equal(leader, patched[5])
+
+	// The service's tasks were rescheduled as nodes were drained
+	var running int
+	for _, node := range cluster.Members() {
+		assert.Equal(1, node.Boots, node.Hostname)
+		assert.Equal("active", node.Availability, node.Hostname)
+		for _, task := range node.Tasks {
+			if task.DesiredState == "Running" {
+				running++
+			}
+		}
+	}
+	assert.Equal(2, running)
+
+	// Nothing is maintained while a service has not converged
+	cluster.AddService(swarm.Service{
+		ID: "db-id",
+		Spec: swarm.ServiceSpec{
+			Name: "db",
+			Mode: swarm.ServiceMode{Replicated: &swarm.ReplicatedService{Replicas: &replicas}},
+		},
+	})
+
+	opts.ConvergeTimeout = time.Millisecond * 50
+	err := m.RollingMaintenance(vms, opts)
+	assert.True(errors.Is(err, context.DeadlineExceeded))
+	assert.Contains(err.Error(), "db")
+	assert.Len(patched, 6)
+}
+
+// TestDrainNodesStuckTasks tests the tasks a drain waits for and the ones still
+// running being reported when it times out.
+func TestDrainNodesStuckTasks(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	_, cluster, m := testSwarm(t, 3, 2)
+
+	cluster.AddService(swarm.Service{
+		ID: "agent-id",
+		Spec: swarm.ServiceSpec{
+			Name: "agent",
+			Mode: swarm.ServiceMode{Global: &swarm.GlobalService{}},
+		},
+	})
+
+	// Nodes are drained without their tasks being shutdown
+	for _, hostname := range []string{"dw1", "dw2"} {
+		node := cluster.Node(hostname)
+		cluster.Handle("docker node update --availability drain "+hostname, func(*swarmtest.Node) (string, error) {
+			node.Availability = "drain"
+			return node.ID + "\n", nil
+		})
+	}
+
+	// Failed and unstarted tasks are not waited for
+	tasks := []swarm.TaskStatus{
+		{Name: "web.1", CurrentState: "Failed 1 minute ago", DesiredState: "Running", Error: "task: non-zero exit (1)"},
+		{Name: "web.2", CurrentState: "Rejected 1 minute ago", DesiredState: "Shutdown"},
+		{Name: "web.3", CurrentState: "Pending 1 minute ago", DesiredState: "Shutdown"},
+		{Name: "web.4", CurrentState: "Complete 1 minute ago", DesiredState: "Shutdown"},
+	}
+	for _, task := range tasks {
+		cluster.AddTask("dw1", task)
+	}
+
+	assert.NoError(m.DrainNodes([]string{"dw1"}))
+
+	// Tasks of global services are waited for as they are stopped too
+	cluster.AddTask("dw2", swarm.TaskStatus{
+		Name:         "agent." + cluster.Node("dw2").ID,
+		CurrentState: "Running 1 hour ago",
+		DesiredState: "Shutdown",
+	})
+	cluster.AddTask("dw2", swarm.TaskStatus{
+		Name:         "web.5",
+		Image:        "nginx:1.21",
+		CurrentState: "Running 1 hour ago",
+		DesiredState: "Shutdown",
+	})
+
+	err := m.DrainNodes([]string{"dw2"}, swarm.DrainOptions{Timeout: time.Millisecond * 50})
+	assert.True(errors.Is(err, context.DeadlineExceeded))
+
+	var terr *swarm.DrainTimeoutError
+	require.True(errors.As(err, &terr))
+	assert.Equal("dw2", terr.Node)
+	require.Len(terr.Tasks, 2)
+	assert.Equal("agent."+cluster.Node("dw2").ID, terr.Tasks[0].Name)
+	assert.Equal("web.5", terr.Tasks[1].Name)
+	assert.Contains(err.Error(), "web.5 (nginx:1.21) Running 1 hour ago")
+}
+
+// TestRemoveNodes tests removing nodes including the manager connected to with
+// the configured drain options.
+func TestRemoveNodes(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	_, cluster, m := testSwarm(t, 3, 2)
+
+	// Removing the manager connected to switches to another manager first
+	info, err := m.GetInfo()
+	require.NoError(err)
+	current := info.Name
+
+	require.NoError(m.RemoveNodes([]string{current}))
+	assert.False(cluster.Node(current).Member)
+	assert.Len(cluster.Members(), 4)
+
+	info, err = m.GetInfo()
+	require.NoError(err)
+	assert.NotEqual(current, info.Name)
+	assert.True(info.IsManager())
+
+	// Nodes are drained with the configured options
+	node := cluster.Node("dw1")
+	cluster.Handle("docker node update --availability drain dw1", func(*swarmtest.Node) (string, error) {
+		node.Availability = "drain"
+		return node.ID + "\n", nil
+	})
+	cluster.AddTask("dw1", swarm.TaskStatus{Name: "web.1", CurrentState: "Running 1 hour ago", DesiredState: "Shutdown"})
+
+	require.NoError(m.Configure(swarm.WithDrainOptions(swarm.DrainOptions{Timeout: time.Millisecond * 50})))
+	err = m.RemoveNodes([]string{"dw1"})
+	assert.True(errors.Is(err, context.DeadlineExceeded))
+	assert.True(node.Member)
+}
+
+// TestRollingMaintenanceMaxDrained tests batches of maintenance are limited by
+// the maximum drained percentage.
+func TestRollingMaintenanceMaxDrained(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	vms, cluster, m := testSwarm(t, 3, 4)
+
+	var patched []string
+	cluster.Handle("uname -r", func(node *swarmtest.Node) (string, error) {
+		patched = append(patched, node.Hostname)
+		return "", nil
+	})
+
+	// A batch of three of the seven nodes exceeds the limit even though
+	// each node alone does not
+	opts := swarm.RollingMaintenanceOptions{
+		Maintenance: swarm.MaintenanceOptions{
+			Exec:  "uname -r",
+			Drain: swarm.DrainOptions{MaxDrainedPercent: 30},
+		},
+		Concurrency: 3,
+	}
+	err := m.RollingMaintenance(vms, opts)
+	require.Error(err)
+	assert.Contains(err.Error(), "3 of 7 nodes would be unavailable")
+	assert.Empty(patched)
+
+	opts.Concurrency = 2
+	require.NoError(m.RollingMaintenance(vms, opts))
+	assert.Len(patched, 7)
+}
+
+// TestCreateSwarmRollback tests a failed create rolls back every node that
+// joined.
+func TestCreateSwarmRollback(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	vms := testVMs(3, 3)
+	cluster := swarmtest.NewCluster(vms)
+	cluster.FailOn("dw3", "docker swarm join", errors.New("timeout"))
+
+	m, err := swarm.NewManager(
+		cluster.Switcher(),
+		swarm.WithPollInterval(time.Millisecond),
+		swarm.WithRollback(),
+	)
+	require.NoError(err)
+
+	err = m.CreateSwarm(vms, false)
+	require.Error(err)
+	assert.Contains(err.Error(), "timeout")
+
+	var rerr *swarm.RollbackError
+	require.True(errors.As(err, &rerr))
+	assert.Empty(rerr.Failed)
+	require.Len(rerr.RolledBack, 6)
+
+	// Workers leave first (in reverse order, including dw3 which failed to
+	// join) and the leader leaves last
+	assert.Equal([]string{"dw3", "dw2", "dw1"}, rerr.RolledBack[:3])
+	assert.Equal(cluster.Commands()[len(cluster.Commands())-1].Hostname, rerr.RolledBack[5])
+
+	assert.Empty(cluster.ClusterID())
+	assert.Empty(cluster.Members())
+
+	// The nodes are left clean so create can simply be retried
+	cluster.ClearFailures()
+	require.NoError(m.CreateSwarm(vms, false))
+	assert.Len(cluster.Members(), 6)
+}
+
+// TestUpdateSwarmRollback tests a failed update rolls back only the nodes it
+// added.
+func TestUpdateSwarmRollback(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	vms := testVMs(5, 3)
+	cluster := swarmtest.NewCluster(vms)
+
+	m, err := swarm.NewManager(
+		cluster.Switcher(),
+		swarm.WithTimeout(time.Second),
+		swarm.WithPollInterval(time.Millisecond),
+		swarm.WithRollback(),
+	)
+	require.NoError(err)
+
+	// Add dm4, dm5, dw2 and dw3 to a cluster of dm1-3 and dw1
+	require.NoError(m.CreateSwarm(swarm.VMNodes{vms[0], vms[1], vms[2], vms[5]}, false))
+	before := roles(cluster)
+
+	cluster.FailOn("dw3", "docker swarm join", errors.New("timeout"))
+
+	err = m.UpdateSwarm(vms)
+	require.Error(err)
+
+	var rerr *swarm.RollbackError
+	require.True(errors.As(err, &rerr))
+	assert.Empty(rerr.Failed)
+	assert.Equal([]string{"dw3", "dw2", "dm5", "dm4"}, rerr.RolledBack)
+
+	// The new nodes left and were removed from the cluster
+	assert.Equal(before, roles(cluster))
+	assert.Equal(swarmtest.ClusterID, cluster.ClusterID())
+}
+
+// TestDestroySwarm tests destroying a cluster in order with the leader last.
+func TestDestroySwarm(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	vms, cluster, m := testSwarm(t, 3, 2)
+	cluster.AddService(swarm.Service{ID: "s1", Spec: swarm.ServiceSpec{Name: "web"}})
+
+	// The leader is not the first manager of the Clusterfile
+	for _, node := range cluster.Members() {
+		node.Leader = node.Hostname == "dm3"
+	}
+
+	err := m.DestroySwarm(vms, "some-other-cluster", true)
+	require.Error(err)
+	assert.Contains(err.Error(), "refusing")
+	assert.Len(cluster.Members(), 5)
+
+	require.NoError(m.DestroySwarm(vms, swarmtest.ClusterID, true))
+
+	assert.Empty(cluster.ClusterID())
+	assert.Empty(cluster.Members())
+	assert.Empty(cluster.Services())
+
+	// Workers leave first, then the managers once demoted and the leader
+	// last without being demoted
+	var left, demoted []string
+	for _, cmd := range cluster.Commands() {
+		switch {
+		case strings.HasPrefix(cmd.Command, "docker swarm leave"):
+			left = append(left, cmd.Hostname)
+		case strings.HasPrefix(cmd.Command, "docker node update --role worker"):
+			assert.Len(left, 2, "demoted %s before the workers left", cmd.Command)
+			demoted = append(demoted, cmd.Command)
+		}
+	}
+	require.Len(left, 5)
+	assert.ElementsMatch([]string{"dw1", "dw2"}, left[:2])
+	assert.ElementsMatch([]string{"dm1", "dm2"}, left[2:4])
+	assert.Equal("dm3", left[4])
+	assert.Len(demoted, 2)
+	assert.NotContains(demoted, "docker node update --role worker dm3")
+}
+
+// TestDestroySwarmUnknownNode tests a cluster is not destroyed if any of its
+// nodes are not in the Clusterfile.
+func TestDestroySwarmUnknownNode(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	vms, cluster, m := testSwarm(t, 3, 2)
+
+	err := m.DestroySwarm(vms[:4], swarmtest.ClusterID, false)
+	require.Error(err)
+	assert.Contains(err.Error(), "dw2")
+	assert.Len(cluster.Members(), 5)
+}
+
+// TestRotateJoinToken tests rotating a join token used by nodes joining later.
+func TestRotateJoinToken(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	vms := testVMs(3, 2)
+	cluster := swarmtest.NewCluster(vms)
+	m := testManager(t, cluster)
+
+	require.NoError(m.CreateSwarm(vms[:4], false))
+
+	token, err := m.RotateJoinToken(swarm.WorkerRole)
+	require.NoError(err)
+	assert.NotEqual(swarmtest.WorkerToken, token)
+	assert.Equal(cluster.JoinToken(swarm.WorkerRole), token)
+	assert.Equal(swarmtest.ManagerToken, cluster.JoinToken(swarm.ManagerRole))
+
+	// New nodes join with the rotated token
+	require.NoError(m.UpdateSwarm(vms))
+	assert.Len(cluster.Members(), 5)
+}
+
+// TestCreateSwarmRotateTokens tests the join tokens are rotated after a create.
+func TestCreateSwarmRotateTokens(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	vms := testVMs(3, 2)
+	cluster := swarmtest.NewCluster(vms)
+
+	m, err := swarm.NewManager(
+		cluster.Switcher(),
+		swarm.WithPollInterval(time.Millisecond),
+		swarm.WithTokenRotation(),
+	)
+	require.NoError(err)
+
+	require.NoError(m.CreateSwarm(vms, false))
+	assert.Len(cluster.Members(), 5)
+	assert.NotEqual(swarmtest.ManagerToken, cluster.JoinToken(swarm.ManagerRole))
+	assert.NotEqual(swarmtest.WorkerToken, cluster.JoinToken(swarm.WorkerRole))
+}
+
+// TestAutolock tests enabling autolock on create, unlocking managers,
+// rotating the unlock key and disabling autolock.
+func TestAutolock(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	vms := testVMs(3, 1)
+	cluster := swarmtest.NewCluster(vms)
+
+	var keys []string
+	m, err := swarm.NewManager(
+		cluster.Switcher(),
+		swarm.WithPollInterval(time.Millisecond),
+		swarm.WithAutolock(func(key string) error {
+			keys = append(keys, key)
+			return nil
+		}),
+	)
+	require.NoError(err)
+
+	require.NoError(m.CreateSwarm(vms, false))
+	require.NotEmpty(cluster.UnlockKey())
+	assert.Equal([]string{cluster.UnlockKey()}, keys)
+
+	cluster.Restart("dm1")
+	cluster.Restart("dm2")
+	assert.True(cluster.Node("dm1").Locked)
+	assert.False(cluster.Node("dw1").Locked)
+
+	err = m.UnlockManagers(vms, "SWMKEY-1-wrong")
+	require.Error(err)
+	assert.Contains(err.Error(), "invalid key")
+
+	require.NoError(m.UnlockManagers(vms, cluster.UnlockKey()))
+	assert.False(cluster.Node("dm1").Locked)
+	assert.False(cluster.Node("dm2").Locked)
+
+	// The key is given on stdin rather than on the command line
+	for _, cmd := range cluster.Commands() {
+		assert.NotContains(cmd.Command, cluster.UnlockKey())
+	}
+
+	key, err := m.RotateUnlockKey()
+	require.NoError(err)
+	assert.NotEqual(keys[0], key)
+	assert.Equal(cluster.UnlockKey(), key)
+
+	require.NoError(m.DisableAutolock())
+	assert.Empty(cluster.UnlockKey())
+
+	cluster.Restart("dm3")
+	assert.False(cluster.Node("dm3").Locked)
+}
+
+// TestCA tests reading certificate expiries and managing the cluster's CA.
+func TestCA(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	_, cluster, m := testSwarm(t, 3, 2)
+
+	rootCA, err := m.RootCA()
+	require.NoError(err)
+	assert.Equal(cluster.RootCA(), rootCA)
+
+	day := 24 * time.Hour
+	cluster.Node("dw1").CertNotAfter = time.Now().Add(5 * day)
+
+	expiries, err := m.GetCertExpiry()
+	require.NoError(err)
+	require.Len(expiries, 5)
+
+	var expiring []string
+	for _, expiry := range expiries {
+		require.NoError(expiry.Err)
+		if expiry.ExpiresWithin(30 * day) {
+			expiring = append(expiring, expiry.Hostname)
+		}
+	}
+	assert.Equal([]string{"dw1"}, expiring)
+
+	// Certificates that cannot be read (e.g: without passwordless sudo) are
+	// reported per node
+	cluster.FailOnce("dw2", "sudo -n cat", errors.New("sudo: a password is required"))
+	expiries, err = m.GetCertExpiry()
+	require.NoError(err)
+	for _, expiry := range expiries {
+		if expiry.Hostname == "dw2" {
+			require.Error(expiry.Err)
+			assert.Contains(expiry.Err.Error(), "passwordless sudo is required")
+		} else {
+			assert.NoError(expiry.Err)
+		}
+	}
+
+	require.NoError(m.SetCertExpiry(60 * day))
+	assert.Equal(60*day, cluster.CertExpiry())
+	assert.Error(m.SetCertExpiry(0))
+
+	// Rotating the root CA issues new certificates to every node
+	newRootCA, err := m.RotateCA()
+	require.NoError(err)
+	assert.NotEqual(rootCA, newRootCA)
+	assert.Equal(cluster.RootCA(), newRootCA)
+	assert.True(cluster.Node("dw1").CertNotAfter.After(time.Now().Add(30 * day)))
+
+	ca := swarm.ExternalCA{Protocol: "cfssl", URL: "https://ca.example.com"}
+	require.NoError(m.SetExternalCA(ca))
+	assert.Equal([]swarm.ExternalCA{ca}, cluster.ExternalCAs())
+	assert.Error(m.SetExternalCA(swarm.ExternalCA{URL: "https://ca.example.com"}))
+}
+
+// TestCreateSwarmConfig tests creating a cluster with swarm settings which are
+// reconciled on update.
+func TestCreateSwarmConfig(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	vms := testVMs(3, 1)
+	cluster := swarmtest.NewCluster(vms)
+	m := testManager(t, cluster)
+
+	require.NoError(m.Configure(
+		swarm.WithDefaultAddrPool("10.20.0.0/16"),
+		swarm.WithDefaultAddrPoolMaskLength(26),
+		swarm.WithDataPathPort(7789),
+		swarm.WithTaskHistoryLimit(1),
+		swarm.WithAvailability("drain"),
+	))
+	require.Error(m.Configure(swarm.WithAvailability("sleepy")))
+
+	require.NoError(m.CreateSwarm(vms, false))
+
+	settings := cluster.Settings()
+	assert.Equal([]string{"10.20.0.0/16"}, settings.DefaultAddrPool)
+	assert.Equal(uint32(26), settings.SubnetSize)
+	assert.Equal(uint32(7789), settings.DataPathPort)
+	assert.Equal(int64(1), *settings.Spec.Orchestration.TaskHistoryRetentionLimit)
+
+	var leader string
+	for _, node := range cluster.Members() {
+		if node.Leader {
+			leader = node.Hostname
+			assert.Equal("drain", node.Availability)
+		}
+	}
+	require.NotEmpty(leader)
+
+	// Settings that can be changed are reconciled on update
+	require.NoError(m.Configure(
+		swarm.WithTaskHistoryLimit(10),
+		swarm.WithDispatcherHeartbeat(10*time.Second),
+		swarm.WithDefaultAddrPool("10.30.0.0/16"),
+	))
+
+	plan, err := m.PlanUpdateSwarm(vms)
+	require.NoError(err)
+	require.NotNil(plan.Update)
+	assert.Equal(int64(10), *plan.Update.TaskHistoryLimit)
+	assert.Equal(10*time.Second, plan.Update.DispatcherHeartbeat)
+	assert.Zero(plan.Update.CertExpiry)
+
+	require.NoError(m.ApplyPlan(plan))
+
+	settings = cluster.Settings()
+	assert.Equal(int64(10), *settings.Spec.Orchestration.TaskHistoryRetentionLimit)
+	assert.Equal(10*time.Second, settings.Spec.Dispatcher.HeartbeatPeriod)
+	assert.Equal([]string{"10.20.0.0/16"}, settings.DefaultAddrPool)
+
+	plan, err = m.PlanUpdateSwarm(vms)
+	require.NoError(err)
+	assert.True(plan.Empty())
+}
+
+// TestCreateSwarmAddresses tests the advertise, listen and data path addresses
+// of nodes.
+func TestCreateSwarmAddresses(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	vms := testVMs(3, 1)
+	for i := range vms {
+		vms[i].DataPathAddress = fmt.Sprintf("192.168.0.%d", i+1)
+		vms[i].SwarmPort = 4377
+	}
+	vms[1].AdvertiseAddress = "172.17.0.2"
+	vms[1].ListenAddress = "0.0.0.0"
+
+	cluster := swarmtest.NewCluster(vms)
+	m := testManager(t, cluster)
+
+	require.NoError(m.CreateSwarm(vms, false))
+
+	assert.Equal("172.16.0.1:4377", cluster.Node("dm1").AdvertiseAddr)
+	assert.Equal("192.168.0.1", cluster.Node("dm1").DataPathAddr)
+	assert.Equal("172.17.0.2:4377", cluster.Node("dm2").AdvertiseAddr)
+	assert.Equal("192.168.0.2", cluster.Node("dm2").DataPathAddr)
+	assert.Equal("172.16.0.101:4377", cluster.Node("dw1").AdvertiseAddr)
+
+	var leader *swarmtest.Node
+	for _, node := range cluster.Members() {
+		if node.Leader {
+			leader = node
+		}
+	}
+	require.NotNil(leader)
+
+	var joins int
+	for _, cmd := range cluster.Commands() {
+		if strings.HasPrefix(cmd.Command, "docker swarm join ") {
+			joins++
+			assert.True(strings.HasSuffix(cmd.Command, " "+leader.AdvertiseAddr), cmd.Command)
+		}
+	}
+	assert.Equal(3, joins)
+}
+
+// TestPreflight tests the preflight checks of nodes before they join a swarm.
+func TestPreflight(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	vms := testVMs(3, 2)
+	cluster := swarmtest.NewCluster(vms)
+	m := testManager(t, cluster)
+
+	results, err := m.Preflight(vms)
+	require.NoError(err)
+	require.Len(results, 5)
+	assert.True(results.Passed())
+	for _, result := range results {
+		assert.Len(result.Checks, len(swarm.PreflightChecks))
+	}
+
+	cluster.Node("dw1").ClockOffset = time.Minute
+	cluster.Node("dw2").EngineVersion = "23.0.1"
+	cluster.Block("dm2", "tcp", 2377)
+	cluster.FailOn("dm3", "docker info", fmt.Errorf("Cannot connect to the Docker daemon"))
+
+	results, err = m.Preflight(vms)
+	require.NoError(err)
+	assert.False(results.Passed())
+	assert.Equal([]string{"dm1", "dm3", "dw1", "dw2"}, results.Failed())
+
+	failed := func(hostname, name string) bool {
+		for _, result := range results {
+			if result.Node.Hostname == hostname {
+				check, ok := result.Check(name)
+				return ok && check.Err != nil
+			}
+		}
+		return false
+	}
+
+	assert.True(failed("dm3", swarm.CheckDocker))
+	_, ok := results[2].Check(swarm.CheckPorts)
+	assert.False(ok)
+
+	assert.True(failed("dw1", swarm.CheckClock))
+	assert.False(failed("dm1", swarm.CheckClock))
+	assert.True(failed("dw2", swarm.CheckVersion))
+	assert.False(failed("dm1", swarm.CheckVersion))
+
+	assert.True(failed("dm1", swarm.CheckPorts))
+	assert.False(failed("dm2", swarm.CheckPorts))
+	check, _ := results[0].Check(swarm.CheckPorts)
+	assert.EqualError(check.Err, "unable to reach tcp/2377 on dm2")
+
+	// A dropped UDP probe is only detected before nodes join a swarm
+	cluster.ClearFailures()
+	cluster.Node("dw1").ClockOffset = 0
+	cluster.Node("dw2").EngineVersion = swarmtest.EngineVersion
+	cluster.Block("dw1", "udp", swarm.DefaultDataPathPort)
+
+	results, err = m.Preflight(vms)
+	require.NoError(err)
+	assert.Equal([]string{"dm1", "dm2", "dm3", "dw2"}, results.Failed())
+	assert.False(failed("dw1", swarm.CheckPorts))
+	assert.True(failed("dw2", swarm.CheckPorts))
+
+	// Refuse to plan while checks fail
+	require.NoError(m.Configure(swarm.WithPreflight()))
+	_, err = m.PlanCreateSwarm(vms, false)
+	var perr *swarm.PreflightError
+	require.True(errors.As(err, &perr))
+	assert.Equal(results.Failed(), perr.Results.Failed())
+}
+
+// TestPreflightPorts tests port checks fail without nc unless they are skipped.
+func TestPreflightPorts(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	vms := testVMs(3, 2)
+	cluster := swarmtest.NewCluster(vms)
+	m := testManager(t, cluster)
+
+	// Ports cannot be probed without nc which is not mistaken for a refused
+	// connection
+	cluster.Node("dw1").NoNetcat = true
+
+	results, err := m.Preflight(vms)
+	require.NoError(err)
+	assert.Equal([]string{"dw1"}, results.Failed())
+	check, ok := results[3].Check(swarm.CheckPorts)
+	require.True(ok)
+	assert.Contains(check.Err.Error(), "nc and timeout")
+
+	require.NoError(m.Configure(swarm.WithoutPortChecks()))
+	results, err = m.Preflight(vms)
+	require.NoError(err)
+	assert.True(results.Passed())
+	_, ok = results[3].Check(swarm.CheckPorts)
+	assert.False(ok)
+}
+
+// TestPreflightSwarm tests the preflight checks of nodes already part of a
+// swarm.
+func TestPreflightSwarm(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	vms, _, m := testSwarm(t, 3, 2)
+
+	// Nodes of the cluster described by the Clusterfile pass
+	results, err := m.Preflight(vms)
+	require.NoError(err)
+	assert.True(results.Passed())
+
+	// The workers are part of a different swarm to the one described
+	results, err = m.Preflight(vms[3:])
+	require.NoError(err)
+	assert.Equal([]string{"dw1", "dw2"}, results.Failed())
+	for _, result := range results {
+		check, _ := result.Check(swarm.CheckSwarm)
+		assert.Error(check.Err)
+	}
+
+	// Hostnames must be unique
+	dup := append(swarm.VMNodes{}, vms...)
+	dup[4].Hostname = "dw1"
+	results, err = m.Preflight(dup)
+	require.NoError(err)
+	assert.Equal([]string{"dw1", "dw1"}, results.Failed())
+}
+
+// TestEngineVersionPolicy tests the minimum engine version is enforced or
+// reported.
+func TestEngineVersionPolicy(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	vms := testVMs(3, 2)
+	cluster := swarmtest.NewCluster(vms)
+	cluster.Node("dw1").EngineVersion = "20.10.3"
+	cluster.Node("dw2").EngineVersion = "23.0.1"
+	m := testManager(t, cluster)
+
+	require.NoError(m.Configure(
+		swarm.WithMinEngineVersion("20.10.5"),
+		swarm.WithEngineVersionEnforcement(),
+	))
+
+	err := m.CreateSwarm(vms, false)
+	require.Error(err)
+	assert.True(errors.Is(err, swarm.ErrEngineVersionTooOld) || errors.Is(err, swarm.ErrEngineVersionSkew))
+	assert.False(cluster.Node("dw1").Member)
+	assert.False(cluster.Node("dw2").Member)
+	for _, hostname := range []string{"dm1", "dm2", "dm3"} {
+		assert.True(cluster.Node(hostname).Member)
+	}
+
+	// Without enforcement non-compliant nodes are joined and reported
+	m = testManager(t, cluster)
+	require.NoError(m.Configure(swarm.WithMinEngineVersion("20.10.5")))
+	require.NoError(m.CreateSwarm(vms, false))
+
+	issues, err := m.CheckEngineVersions()
+	require.NoError(err)
+	require.Len(issues, 2)
+	assert.True(errors.Is(issues[cluster.Node("dw1").ID], swarm.ErrEngineVersionTooOld))
+	assert.True(errors.Is(issues[cluster.Node("dw2").ID], swarm.ErrEngineVersionSkew))
+}
@@ -0,0 +1,203 @@
+/*
+	go-swarm is a Go library and ccommand-line tool for managing the creation
+	and maintenance of Docker Swarm cluster.
+
+    Copyright (C) 2021 Sovereign Cloud Australia Pty Ltd
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as published
+    by the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package swarm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// LabelChange describes the Swarm labels that will be added to (or updated)
+// and removed from a node
+type LabelChange struct {
+	Node   VMNode
+	Add    []string
+	Remove []string
+}
+
+// Plan is the set of changes that CreateSwarm or UpdateSwarm would make to a
+// Docker Swarm cluster. A Plan is computed without running any commands that
+// mutate the cluster and can be inspected (or reviewed) before it is applied
+// with ApplyPlan.
+type Plan struct {
+	// ClusterID is the ID of the existing cluster being updated and is empty
+	// when a new cluster is being created.
+	ClusterID string
+
+	// Init is true when the Leader will initialize a new cluster.
+	Init bool
+
+	// Leader is the manager used to initialize the cluster (if Init is set)
+	// and that all new nodes join through.
+	Leader VMNode
+
+	// Managers and Workers are the nodes that will join the cluster.
+	Managers VMNodes
+	Workers  VMNodes
+
+	// Promote and Demote are the hostnames of existing nodes whose role
+	// will be changed to manager and worker respectively.
+	Promote []string
+	Demote  []string
+
+	// Labels are the labels that will be added to or removed from nodes.
+	Labels []LabelChange
+
+	// Remove are the hostnames of nodes that will be drained and removed.
+	Remove []string
+
+	// Swarm is the configuration the cluster is initialized with (if Init
+	// is set).
+	Swarm SwarmConfig
+
+	// Update are the changes to the settings of an existing cluster.
+	Update *SwarmUpdate
+}
+
+// Empty returns true if applying the plan would not change anything
+func (p *Plan) Empty() bool {
+	return !p.Init &&
+		len(p.Managers) == 0 &&
+		len(p.Workers) == 0 &&
+		len(p.Promote) == 0 &&
+		len(p.Demote) == 0 &&
+		len(p.Labels) == 0 &&
+		len(p.Remove) == 0 &&
+		p.Update == nil
+}
+
+// Diff returns a human readable, terraform-style, summary of the plan
+func (p *Plan) Diff() string {
+	var (
+		sb                   strings.Builder
+		add, change, destroy int
+	)
+
+	if p.Init {
+		fmt.Fprintf(&sb, "A new Swarm Cluster will be created:\n\n")
+	} else {
+		fmt.Fprintf(&sb, "Swarm Cluster %s will be updated:\n\n", p.ClusterID)
+	}
+
+	if p.Empty() {
+		fmt.Fprintf(&sb, "  No changes.\n")
+		return sb.String()
+	}
+
+	if p.Init {
+		fmt.Fprintf(&sb, "  + init   %s (%s) as leader\n", p.Leader.Hostname, p.Leader.PrivateAddress)
+		if flags := p.Swarm.initFlags(); len(flags) > 0 {
+			fmt.Fprintf(&sb, "           with %s\n", strings.Join(flags, " "))
+		}
+		add++
+	}
+
+	if p.Update != nil {
+		fmt.Fprintf(&sb, "  ~ update swarm %s\n", strings.Join(p.Update.flags(), " "))
+		change++
+	}
+
+	for _, vm := range p.Managers {
+		fmt.Fprintf(&sb, "  + join   %s (%s) as manager\n", vm.Hostname, vm.PrivateAddress)
+		add++
+	}
+
+	for _, vm := range p.Workers {
+		fmt.Fprintf(&sb, "  + join   %s (%s) as worker\n", vm.Hostname, vm.PrivateAddress)
+		add++
+	}
+
+	for _, hostname := range p.Promote {
+		fmt.Fprintf(&sb, "  ~ promote %s to manager\n", hostname)
+		change++
+	}
+
+	for _, hostname := range p.Demote {
+		fmt.Fprintf(&sb, "  ~ demote  %s to worker\n", hostname)
+		change++
+	}
+
+	for _, label := range p.Labels {
+		fmt.Fprintf(&sb, "  ~ label  %s", label.Node.Hostname)
+		for _, l := range label.Add {
+			fmt.Fprintf(&sb, " +%s", l)
+		}
+		for _, l := range label.Remove {
+			fmt.Fprintf(&sb, " -%s", l)
+		}
+		fmt.Fprintf(&sb, "\n")
+		change++
+	}
+
+	for _, hostname := range p.Remove {
+		fmt.Fprintf(&sb, "  - remove %s\n", hostname)
+		destroy++
+	}
+
+	fmt.Fprintf(&sb, "\nPlan: %d to add, %d to change, %d to destroy.\n", add, change, destroy)
+
+	return sb.String()
+}
+
+// labelChange returns the LabelChange required to reconcile a node's current
+// Swarm labels with the labels given by its labels tag or nil if the labels
+// are already up-to-date.
+func labelChange(vm VMNode, current map[string]string) (*LabelChange, error) {
+	labels, err := ParseLabels(vm.GetTag(LabelsTag))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing labels for %s: %w", vm.Hostname, err)
+	}
+
+	var add, remove []string
+
+	for key, values := range labels {
+		value, ok := current[key]
+		if !ok || value != strings.Join(values, ",") {
+			add = append(add, formatLabel(key, values))
+		}
+	}
+
+	for key := range current {
+		if _, ok := labels[key]; !ok {
+			remove = append(remove, key)
+		}
+	}
+
+	if len(add) == 0 && len(remove) == 0 {
+		return nil, nil
+	}
+
+	sort.Strings(add)
+	sort.Strings(remove)
+
+	return &LabelChange{Node: vm, Add: add, Remove: remove}, nil
+}
+
+// formatLabel formats a label key and its values as used by
+// `docker node update --label-add`
+func formatLabel(key string, values []string) string {
+	label := key
+	if len(values) > 0 {
+		label += fmt.Sprintf("=%s", strings.Join(values, ","))
+	}
+	return label
+}
@@ -0,0 +1,127 @@
+/*
+	go-swarm is a Go library and ccommand-line tool for managing the creation
+	and maintenance of Docker Swarm cluster.
+
+    Copyright (C) 2021 Sovereign Cloud Australia Pty Ltd
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as published
+    by the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package swarm
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestPlanDiff tests the `Plan.Diff()` output for a new cluster.
+func TestPlanDiff(t *testing.T) {
+	assert := assert.New(t)
+
+	dm1 := VMNode{Hostname: "dm1", PrivateAddress: "172.16.0.1"}
+	dm2 := VMNode{Hostname: "dm2", PrivateAddress: "172.16.0.2"}
+	dw1 := VMNode{Hostname: "dw1", PrivateAddress: "172.16.0.3"}
+
+	plan := &Plan{
+		Init:     true,
+		Leader:   dm1,
+		Managers: VMNodes{dm2},
+		Workers:  VMNodes{dw1},
+		Promote:  []string{"dw2"},
+		Demote:   []string{"dm3"},
+		Labels:   []LabelChange{{Node: dw1, Add: []string{"zone=a"}, Remove: []string{"old"}}},
+		Remove:   []string{"dw9"},
+	}
+
+	expected := `A new Swarm Cluster will be created:
+
+  + init   dm1 (172.16.0.1) as leader
+  + join   dm2 (172.16.0.2) as manager
+  + join   dw1 (172.16.0.3) as worker
+  ~ promote dw2 to manager
+  ~ demote  dm3 to worker
+  ~ label  dw1 +zone=a -old
+  - remove dw9
+
+Plan: 3 to add, 3 to change, 1 to destroy.
+`
+	assert.Equal(expected, plan.Diff())
+}
+
+// TestPlanDiffEmpty tests the `Plan.Diff()` output when nothing changes.
+func TestPlanDiffEmpty(t *testing.T) {
+	assert := assert.New(t)
+
+	plan := &Plan{ClusterID: "abc"}
+	assert.True(plan.Empty())
+	assert.Equal("Swarm Cluster abc will be updated:\n\n  No changes.\n", plan.Diff())
+}
+
+// TestLabelChange tests parsing a node's labels tag into a `LabelChange`.
+func TestLabelChange(t *testing.T) {
+	assert := assert.New(t)
+
+	change, err := labelChange(VMNode{}, nil)
+	assert.Nil(err)
+	assert.Nil(change)
+
+	vm := VMNode{Tags: map[string]string{LabelsTag: "zone=a&role=db&role=web"}}
+	change, err = labelChange(vm, nil)
+	assert.Nil(err)
+	assert.Equal([]string{"role=db,web", "zone=a"}, change.Add)
+	assert.Empty(change.Remove)
+}
+
+// TestLabelChangeReconcile tests reconciling a node's current labels.
+func TestLabelChangeReconcile(t *testing.T) {
+	assert := assert.New(t)
+
+	vm := VMNode{Tags: map[string]string{LabelsTag: "zone=a&role=db&role=web"}}
+
+	change, err := labelChange(vm, map[string]string{"zone": "a", "role": "db,web"})
+	assert.Nil(err)
+	assert.Nil(change)
+
+	change, err = labelChange(vm, map[string]string{"zone": "b", "role": "db,web", "old": ""})
+	assert.Nil(err)
+	assert.Equal([]string{"zone=a"}, change.Add)
+	assert.Equal([]string{"old"}, change.Remove)
+
+	change, err = labelChange(VMNode{}, map[string]string{"old": ""})
+	assert.Nil(err)
+	assert.Empty(change.Add)
+	assert.Equal([]string{"old"}, change.Remove)
+}
+
+// TestPlanDiffSwarm tests the `Plan.Diff()` output for swarm settings.
+func TestPlanDiffSwarm(t *testing.T) {
+	assert := assert.New(t)
+
+	dm1 := VMNode{Hostname: "dm1", PrivateAddress: "172.16.0.1"}
+
+	plan := &Plan{
+		Init:   true,
+		Leader: dm1,
+		Swarm:  SwarmConfig{DefaultAddrPool: []string{"10.20.0.0/16"}, DefaultAddrPoolMaskLength: 26},
+	}
+	assert.Contains(plan.Diff(), "  + init   dm1 (172.16.0.1) as leader\n           with --default-addr-pool 10.20.0.0/16 --default-addr-pool-mask-length 26\n")
+
+	limit := int64(10)
+	plan = &Plan{ClusterID: "abc", Update: &SwarmUpdate{TaskHistoryLimit: &limit, DispatcherHeartbeat: 10 * time.Second}}
+	assert.False(plan.Empty())
+	assert.Contains(plan.Diff(), "  ~ update swarm --task-history-limit 10 --dispatcher-heartbeat 10s\n")
+	assert.Contains(plan.Diff(), "Plan: 0 to add, 1 to change, 0 to destroy.\n")
+}
@@ -0,0 +1,521 @@
+/*
+	go-swarm is a Go library and ccommand-line tool for managing the creation
+	and maintenance of Docker Swarm cluster.
+
+    Copyright (C) 2021 Sovereign Cloud Australia Pty Ltd
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as published
+    by the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package swarm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Pre-flight checks in the order they are run
+const (
+	// CheckDocker checks that the Docker daemon of a node is reachable
+	CheckDocker = "docker"
+
+	// CheckVersion checks that the node's engine version is not below the
+	// minimum engine version or more than one minor version apart from the
+	// managers (see CheckEngineVersions)
+	CheckVersion = "version"
+
+	// CheckSwarm checks that the node is not part of a different swarm
+	CheckSwarm = "swarm"
+
+	// CheckHostname checks that the node's hostname is unique
+	CheckHostname = "hostname"
+
+	// CheckClock checks that the node's clock does not differ from the other
+	// nodes by more than MaxClockSkew
+	CheckClock = "clock"
+
+	// CheckPorts checks that the ports used by Swarm on every other node are
+	// reachable from the node
+	CheckPorts = "ports"
+)
+
+// PreflightChecks are the names of all pre-flight checks in the order they
+// are run
+var PreflightChecks = []string{
+	CheckDocker, CheckVersion, CheckSwarm, CheckHostname, CheckClock, CheckPorts,
+}
+
+const (
+	// MaxClockSkew is the maximum difference between the clock of a node and
+	// the clocks of the other nodes
+	MaxClockSkew = 5 * time.Second
+
+	// DefaultDataPathPort is the default port used for overlay network
+	// (VXLAN) traffic
+	DefaultDataPathPort = 4789
+
+	// gossipPort is the port used by nodes to exchange network state
+	gossipPort = 7946
+
+	// probeTimeout is the number of seconds after which a port probe is
+	// considered to have been dropped
+	probeTimeout = 3
+)
+
+// probeResult is the outcome of probing a port on another node
+type probeResult int
+
+const (
+	// probeOpen means a connection was made or for UDP that no error was
+	// received (the port may be open or the probe dropped)
+	probeOpen probeResult = iota
+
+	// probeRefused means the other node refused the connection and is
+	// therefore reachable even though nothing is listening on the port
+	probeRefused
+
+	// probeTimeout means the probe timed out and was likely dropped
+	probeTimedOut
+)
+
+var (
+	errProbeUnsupported = errors.New("port probes are not available with the Docker Engine API")
+	errProbeUnavailable = errors.New("port probes require nc and timeout which are not available on the node")
+)
+
+// PreflightCheck is the result of a single pre-flight check, Err is nil if
+// the check passed
+type PreflightCheck struct {
+	Name string
+	Err  error
+}
+
+// PreflightResult are the results of the pre-flight checks run for a node.
+// Checks that could not be run (e.g: because the Docker daemon is not
+// reachable) are missing.
+type PreflightResult struct {
+	Node   VMNode
+	Checks []PreflightCheck
+}
+
+// Check returns the result of the check given by name and whether it was run
+func (r PreflightResult) Check(name string) (PreflightCheck, bool) {
+	for _, check := range r.Checks {
+		if check.Name == name {
+			return check, true
+		}
+	}
+	return PreflightCheck{}, false
+}
+
+// Passed returns true if every check that was run passed
+func (r PreflightResult) Passed() bool {
+	for _, check := range r.Checks {
+		if check.Err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *PreflightResult) add(name string, err error) {
+	r.Checks = append(r.Checks, PreflightCheck{Name: name, Err: err})
+}
+
+// PreflightResults are the results of the pre-flight checks of every node
+type PreflightResults []PreflightResult
+
+// Passed returns true if every check passed on every node
+func (rs PreflightResults) Passed() bool {
+	for _, r := range rs {
+		if !r.Passed() {
+			return false
+		}
+	}
+	return true
+}
+
+// Failed returns the hostnames of the nodes that failed a check
+func (rs PreflightResults) Failed() []string {
+	var hostnames []string
+	for _, r := range rs {
+		if !r.Passed() {
+			hostnames = append(hostnames, r.Node.Hostname)
+		}
+	}
+	return hostnames
+}
+
+// PreflightError is returned when planning to create or update a cluster
+// with pre-flight checks enabled (see WithPreflight) and a check failed
+type PreflightError struct {
+	Results PreflightResults
+}
+
+func (e *PreflightError) Error() string {
+	return fmt.Sprintf("error pre-flight checks failed on %s", strings.Join(e.Results.Failed(), ", "))
+}
+
+// WithPreflight runs the pre-flight checks (see Preflight) before planning
+// to create or update a cluster and refuses to continue if any check fails
+func WithPreflight() Option {
+	return func(cfg *Config) error {
+		cfg.Preflight = true
+		return nil
+	}
+}
+
+// WithoutPortChecks skips the port checks of the pre-flight checks (e.g:
+// when talking to the Docker Engine API where ports cannot be probed)
+func WithoutPortChecks() Option {
+	return func(cfg *Config) error {
+		cfg.SkipPortChecks = true
+		return nil
+	}
+}
+
+// preflightNode is the state of a node gathered for the pre-flight checks
+type preflightNode struct {
+	vm     VMNode
+	info   NodeInfo
+	offset time.Duration
+	err    error
+}
+
+// Preflight connects to every node and checks that the Docker daemon is
+// reachable, engine versions are compatible, nodes are not already part of a
+// different swarm, hostnames are unique, clocks are in sync and the ports
+// used by Swarm are reachable between nodes. No changes are made.
+//
+// The ports checked are TCP 2377 on managers, TCP and UDP 7946 and UDP 4789
+// (or the configured data path port) on every node. A port that refuses
+// connections is considered reachable. As UDP probes are not acknowledged a
+// dropped UDP probe can only be detected for nodes not yet part of a swarm.
+// Ports are probed with nc on the nodes so the port checks fail when talking
+// to the Docker Engine API unless they are skipped (see WithoutPortChecks).
+func (m *Manager) Preflight(vms VMNodes) (PreflightResults, error) {
+	return m.PreflightContext(context.Background(), vms)
+}
+
+// PreflightContext is like Preflight but the operation is cancelled when ctx
+// is done.
+func (m *Manager) PreflightContext(ctx context.Context, vms VMNodes) (PreflightResults, error) {
+	nodes := make([]*preflightNode, len(vms))
+	err := m.preflightEach(vms, func(i int, n *Manager) {
+		nodes[i] = n.preflightNode(ctx, vms[i])
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error running pre-flight checks: %w", err)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("error running pre-flight checks: %w", err)
+	}
+
+	var (
+		reachable []*preflightNode
+		offsets   []time.Duration
+		versions  []string
+	)
+
+	hostnames := make(map[string]int)
+
+	for _, node := range nodes {
+		hostnames[node.vm.Hostname]++
+		if node.err != nil {
+			continue
+		}
+		reachable = append(reachable, node)
+		if node.info.Name != node.vm.Hostname {
+			hostnames[node.info.Name]++
+		}
+		if node.vm.HasTag(RoleTag, ManagerRole) {
+			versions = append(versions, node.info.ServerVersion)
+		}
+		if !node.info.SystemTime.IsZero() {
+			offsets = append(offsets, node.offset)
+		}
+	}
+
+	clock := median(offsets)
+	addrs := clusterAddrs(vms)
+
+	results := make(PreflightResults, len(nodes))
+	for i, node := range nodes {
+		result := &results[i]
+		result.Node = node.vm
+
+		result.add(CheckDocker, node.err)
+		if node.err != nil {
+			continue
+		}
+
+		result.add(CheckVersion, m.config.checkEngineVersion(node.info.ServerVersion, versions))
+		result.add(CheckSwarm, checkSwarm(node.info, addrs))
+		result.add(CheckHostname, checkHostname(node, hostnames))
+		result.add(CheckClock, checkClock(node, clock))
+	}
+
+	if m.config.SkipPortChecks {
+		log.Warn("skipping port checks")
+		return results, nil
+	}
+
+	ports := make([]error, len(nodes))
+	err = m.preflightEach(vms, func(i int, n *Manager) {
+		if nodes[i].err != nil {
+			return
+		}
+		ports[i] = n.checkPorts(ctx, nodes[i], reachable)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error running pre-flight checks: %w", err)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("error running pre-flight checks: %w", err)
+	}
+
+	for i, node := range nodes {
+		if node.err != nil {
+			continue
+		}
+		results[i].add(CheckPorts, ports[i])
+	}
+
+	return results, nil
+}
+
+// preflight runs the pre-flight checks and returns a *PreflightError if any
+// check failed
+func (m *Manager) preflight(ctx context.Context, vms VMNodes) error {
+	results, err := m.PreflightContext(ctx, vms)
+	if err != nil {
+		return err
+	}
+	if !results.Passed() {
+		return &PreflightError{Results: results}
+	}
+	return nil
+}
+
+// preflightEach calls fn concurrently (up to the configured concurrency) for
+// every node with the node's index and a forked Manager. Unlike forEach every
+// node is visited regardless of errors. An error is only returned if the
+// Manager cannot be forked in which case no node is visited.
+func (m *Manager) preflightEach(vms VMNodes, fn func(i int, n *Manager)) error {
+	var wg sync.WaitGroup
+
+	forks := make([]*Manager, len(vms))
+	for i := range vms {
+		n, err := m.fork()
+		if err != nil {
+			return err
+		}
+		forks[i] = n
+	}
+
+	sem := make(chan struct{}, m.config.Concurrency)
+
+	for i := range vms {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer func() { <-sem }()
+			defer wg.Done()
+			fn(i, forks[i])
+		}(i)
+	}
+
+	wg.Wait()
+
+	return nil
+}
+
+func (m *Manager) preflightNode(ctx context.Context, vm VMNode) *preflightNode {
+	node := &preflightNode{vm: vm}
+
+	if err := m.SwitchNodeContext(ctx, vm.PublicAddress); err != nil {
+		node.err = err
+		return node
+	}
+
+	info, err := m.GetInfoContext(ctx)
+	if err != nil {
+		node.err = fmt.Errorf("error getting node info: %w", err)
+		return node
+	}
+
+	node.info = info
+	node.offset = info.SystemTime.Sub(time.Now())
+
+	return node
+}
+
+func median(ds []time.Duration) time.Duration {
+	if len(ds) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), ds...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[len(sorted)/2]
+}
+
+// clusterAddrs returns the addresses managers of the cluster described by
+// vms could be advertising
+func clusterAddrs(vms VMNodes) map[string]bool {
+	addrs := make(map[string]bool)
+	for _, vm := range vms {
+		addrs[vm.PrivateAddress] = true
+		addrs[vm.PublicAddress] = true
+		if vm.AdvertiseAddress != "" {
+			addrs[vm.AdvertiseAddress] = true
+		}
+	}
+	return addrs
+}
+
+func checkSwarm(info NodeInfo, addrs map[string]bool) error {
+	switch info.Swarm.LocalNodeState {
+	case "", "inactive":
+		return nil
+	case "locked":
+		return fmt.Errorf("node is locked and must be unlocked")
+	}
+
+	for _, manager := range info.Swarm.RemoteManagers {
+		host, _, err := net.SplitHostPort(manager.Addr)
+		if err == nil && addrs[host] {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("node is %s in a different swarm (node id %s)", info.Swarm.LocalNodeState, info.Swarm.NodeID)
+}
+
+func checkHostname(node *preflightNode, hostnames map[string]int) error {
+	if hostnames[node.vm.Hostname] > 1 {
+		return fmt.Errorf("hostname %s is not unique", node.vm.Hostname)
+	}
+	if hostnames[node.info.Name] > 1 {
+		return fmt.Errorf("hostname %s of %s is not unique", node.info.Name, node.vm.Hostname)
+	}
+	return nil
+}
+
+func checkClock(node *preflightNode, clock time.Duration) error {
+	if node.info.SystemTime.IsZero() {
+		return nil
+	}
+	skew := node.offset - clock
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > MaxClockSkew {
+		return fmt.Errorf("clock differs from other nodes by %s", skew.Round(time.Second))
+	}
+	return nil
+}
+
+// probeTarget is a port on another node to probe
+type probeTarget struct {
+	node    *preflightNode
+	network string
+	host    string
+	port    int
+}
+
+func (t probeTarget) String() string {
+	return fmt.Sprintf("%s/%d on %s", t.network, t.port, t.node.vm.Hostname)
+}
+
+// probeTargets returns the ports used by Swarm on node
+func (m *Manager) probeTargets(node *preflightNode) []probeTarget {
+	host := node.vm.AdvertiseAddress
+	if host == "" {
+		host = node.vm.PrivateAddress
+	}
+
+	dataHost := host
+	if net.ParseIP(node.vm.DataPathAddress) != nil {
+		dataHost = node.vm.DataPathAddress
+	}
+
+	dataPort := DefaultDataPathPort
+	if m.config.Swarm.DataPathPort != 0 {
+		dataPort = int(m.config.Swarm.DataPathPort)
+	}
+
+	var targets []probeTarget
+	if node.vm.HasTag(RoleTag, ManagerRole) {
+		remoteHost, remotePort, _ := net.SplitHostPort(node.vm.RemoteAddr())
+		port, _ := strconv.Atoi(remotePort)
+		targets = append(targets, probeTarget{node, "tcp", remoteHost, port})
+	}
+	return append(
+		targets,
+		probeTarget{node, "tcp", host, gossipPort},
+		probeTarget{node, "udp", host, gossipPort},
+		probeTarget{node, "udp", dataHost, dataPort},
+	)
+}
+
+// checkPorts probes the ports used by Swarm on every other node from the
+// current node
+func (m *Manager) checkPorts(ctx context.Context, node *preflightNode, others []*preflightNode) error {
+	if err := m.SwitchNodeContext(ctx, node.vm.PublicAddress); err != nil {
+		return err
+	}
+
+	var blocked []string
+
+	for _, other := range others {
+		if other == node {
+			continue
+		}
+
+		// A node that is part of a swarm may be listening on a UDP port so a
+		// probe that was not refused is not necessarily dropped
+		active := other.info.Swarm.LocalNodeState == "active"
+
+		for _, target := range m.probeTargets(other) {
+			result, err := m.engine().Probe(ctx, target.network, target.host, target.port)
+			if err != nil {
+				return err
+			}
+
+			switch {
+			case result == probeTimedOut:
+				blocked = append(blocked, target.String())
+			case result == probeOpen && target.network == "udp" && !active:
+				blocked = append(blocked, target.String())
+			}
+		}
+	}
+
+	if len(blocked) > 0 {
+		return fmt.Errorf("unable to reach %s", strings.Join(blocked, ", "))
+	}
+
+	return nil
+}
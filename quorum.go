@@ -0,0 +1,182 @@
+/*
+	go-swarm is a Go library and ccommand-line tool for managing the creation
+	and maintenance of Docker Swarm cluster.
+
+    Copyright (C) 2021 Sovereign Cloud Australia Pty Ltd
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as published
+    by the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package swarm
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// quorum returns the number of managers required for a majority in a cluster
+// with the given number of managers
+func quorum(managers int) int {
+	return managers/2 + 1
+}
+
+// managerCounts returns the number of managers and the number of those
+// managers that are reachable
+func managerCounts(nodes []NodeStatus) (managers, reachable int) {
+	for _, node := range nodes {
+		if node.IsManager() {
+			managers++
+			if node.IsReachable() {
+				reachable++
+			}
+		}
+	}
+	return
+}
+
+// CheckQuorum checks that applying the plan to a cluster with the given nodes
+// would preserve a quorum of reachable managers at every step. New managers
+// are joined and workers promoted before any managers are demoted or removed
+// and managers are demoted or removed one at a time.
+func (p *Plan) CheckQuorum(nodes []NodeStatus) error {
+	byHostname := make(map[string]NodeStatus)
+	for _, node := range nodes {
+		byHostname[node.Hostname] = node
+	}
+
+	managers, reachable := managerCounts(nodes)
+	if !p.Init && reachable < quorum(managers) {
+		return fmt.Errorf(
+			"error cluster has lost quorum with only %d of %d reachable managers",
+			reachable, managers,
+		)
+	}
+
+	// Joins and promotions add reachable managers
+	managers += len(p.Managers) + len(p.Promote)
+	reachable += len(p.Managers) + len(p.Promote)
+
+	for _, hostname := range append(append([]string{}, p.Demote...), p.Remove...) {
+		node, ok := byHostname[hostname]
+		if !ok || !node.IsManager() {
+			continue
+		}
+
+		managers--
+		if node.IsReachable() {
+			reachable--
+		}
+
+		if reachable < quorum(managers) {
+			return fmt.Errorf(
+				"error removing manager %s would leave %d of %d reachable managers (quorum is %d)",
+				hostname, reachable, managers, quorum(managers),
+			)
+		}
+	}
+
+	return nil
+}
+
+// sortForQuorum orders the hostnames of nodes to be demoted or removed so that
+// unreachable managers are removed first followed by reachable managers and
+// finally workers. Removing unreachable managers first never reduces the
+// number of reachable managers.
+func sortForQuorum(hostnames []string, nodes map[string]NodeStatus) {
+	rank := func(hostname string) int {
+		node := nodes[hostname]
+		switch {
+		case node.IsManager() && !node.IsReachable():
+			return 0
+		case node.IsManager():
+			return 1
+		default:
+			return 2
+		}
+	}
+
+	sort.SliceStable(hostnames, func(i, j int) bool {
+		return rank(hostnames[i]) < rank(hostnames[j])
+	})
+}
+
+// ensureQuorum checks that the cluster retains a quorum of reachable
+// managers if the manager given by its ID or hostname is demoted or removed
+func (m *Manager) ensureQuorum(ctx context.Context, node string) error {
+	nodes, err := m.GetNodesContext(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting current nodes: %w", err)
+	}
+
+	var managers, reachable int
+	for _, n := range nodes {
+		if !n.IsManager() {
+			continue
+		}
+		managers++
+		if n.ID != node && n.Hostname != node && n.IsReachable() {
+			reachable++
+		}
+	}
+
+	if reachable < quorum(managers-1) {
+		return fmt.Errorf(
+			"error removing manager %s would leave %d of %d reachable managers (quorum is %d)",
+			node, reachable, managers-1, quorum(managers-1),
+		)
+	}
+
+	return nil
+}
+
+// waitForReachable blocks until the manager given by its ID or hostname is
+// reported as reachable by the cluster or the configured timeout expires
+func (m *Manager) waitForReachable(ctx context.Context, node string) error {
+	startedAt := time.Now()
+
+	ctx, cancel := context.WithTimeout(ctx, m.config.Timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(m.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			nodes, err := m.GetNodesContext(ctx)
+			if err != nil {
+				log.WithError(err).Warnf("error getting nodes (retrying)")
+				continue
+			}
+
+			for _, n := range nodes {
+				if (n.ID == node || n.Hostname == node) && n.IsReachable() {
+					log.Infof("Manager %s is reachable after %s", node, time.Since(startedAt))
+					return nil
+				}
+			}
+
+			log.Infof("Still waiting for manager %s to be reachable after %s ...", node, time.Since(startedAt))
+		case <-ctx.Done():
+			return fmt.Errorf(
+				"error waiting for manager %s to be reachable after %s: %w",
+				node, time.Since(startedAt), ctx.Err(),
+			)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+/*
+	go-swarm is a Go library and ccommand-line tool for managing the creation
+	and maintenance of Docker Swarm cluster.
+
+    Copyright (C) 2021 Sovereign Cloud Australia Pty Ltd
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as published
+    by the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package swarm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testNodes() []NodeStatus {
+	return []NodeStatus{
+		{ID: "1", Hostname: "dm1", ManagerStatus: "Leader"},
+		{ID: "2", Hostname: "dm2", ManagerStatus: "Reachable"},
+		{ID: "3", Hostname: "dm3", ManagerStatus: "Reachable"},
+		{ID: "4", Hostname: "dw1"},
+		{ID: "5", Hostname: "dw2"},
+	}
+}
+
+// TestQuorum tests the number of managers required for a majority.
+func TestQuorum(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(1, quorum(1))
+	assert.Equal(2, quorum(2))
+	assert.Equal(2, quorum(3))
+	assert.Equal(3, quorum(4))
+	assert.Equal(3, quorum(5))
+}
+
+// TestCheckQuorum tests that replacing managers one at a time is permitted.
+func TestCheckQuorum(t *testing.T) {
+	assert := assert.New(t)
+
+	plan := &Plan{
+		Managers: VMNodes{{Hostname: "dm4"}},
+		Promote:  []string{"dw1"},
+		Demote:   []string{"dm2"},
+		Remove:   []string{"dm3"},
+	}
+	assert.Nil(plan.CheckQuorum(testNodes()))
+}
+
+// TestCheckQuorumUnreachable tests that a plan that would leave fewer than a
+// majority of reachable managers is refused.
+func TestCheckQuorumUnreachable(t *testing.T) {
+	assert := assert.New(t)
+
+	nodes := testNodes()
+	nodes[2].ManagerStatus = "Unreachable"
+
+	// Removing the unreachable manager is fine
+	assert.Nil((&Plan{Remove: []string{"dm3"}}).CheckQuorum(nodes))
+
+	// Removing a reachable manager leaves 1 of 2 reachable managers
+	assert.Error((&Plan{Remove: []string{"dm2"}}).CheckQuorum(nodes))
+
+	// Unless a new manager is joined first
+	plan := &Plan{Managers: VMNodes{{Hostname: "dm4"}}, Remove: []string{"dm2"}}
+	assert.Nil(plan.CheckQuorum(nodes))
+}
+
+// TestSortForQuorum tests that unreachable managers are removed first.
+func TestSortForQuorum(t *testing.T) {
+	assert := assert.New(t)
+
+	nodes := make(map[string]NodeStatus)
+	for _, node := range testNodes() {
+		nodes[node.Hostname] = node
+	}
+	n := nodes["dm3"]
+	n.ManagerStatus = "Unreachable"
+	nodes["dm3"] = n
+
+	hostnames := []string{"dw1", "dm2", "dm3"}
+	sortForQuorum(hostnames, nodes)
+	assert.Equal([]string{"dm3", "dm2", "dw1"}, hostnames)
+}
@@ -0,0 +1,197 @@
+/*
+	go-swarm is a Go library and ccommand-line tool for managing the creation
+	and maintenance of Docker Swarm cluster.
+
+    Copyright (C) 2021 Sovereign Cloud Australia Pty Ltd
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as published
+    by the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package swarm
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// RollbackError is returned by ApplyPlan when applying a plan failed and the
+// nodes that joined the cluster were rolled back
+type RollbackError struct {
+	// Err is the error that caused the rollback
+	Err error
+
+	// RolledBack are the hostnames of the nodes that left the cluster in the
+	// order they left
+	RolledBack []string
+
+	// Failed are the hostnames of the nodes that could not be rolled back
+	// and the errors encountered
+	Failed map[string]error
+}
+
+func (e *RollbackError) Error() string {
+	var sb strings.Builder
+
+	sb.WriteString(e.Err.Error())
+
+	if len(e.RolledBack) > 0 {
+		fmt.Fprintf(&sb, " (rolled back %s)", strings.Join(e.RolledBack, ", "))
+	}
+	var failed []string
+	for hostname := range e.Failed {
+		failed = append(failed, hostname)
+	}
+	sort.Strings(failed)
+
+	for _, hostname := range failed {
+		fmt.Fprintf(&sb, " (error rolling back %s: %s)", hostname, e.Failed[hostname])
+	}
+
+	return sb.String()
+}
+
+func (e *RollbackError) Unwrap() error {
+	return e.Err
+}
+
+// joinedNodes are the nodes that joined (or were being joined to) a cluster
+// while applying a plan in the order they were joined
+type joinedNodes struct {
+	sync.Mutex
+	nodes VMNodes
+}
+
+func (j *joinedNodes) add(vm VMNode) {
+	j.Lock()
+	defer j.Unlock()
+	j.nodes = append(j.nodes, vm)
+}
+
+func (j *joinedNodes) list() VMNodes {
+	j.Lock()
+	defer j.Unlock()
+	return append(VMNodes{}, j.nodes...)
+}
+
+// rollback undoes the joins made while applying a plan that failed with err.
+// Nodes leave in the reverse order to which they were joined and each node
+// is given the configured timeout. When a new cluster was initialized the
+// leader leaves last which dissolves the cluster.
+func (m *Manager) rollback(plan *Plan, joined VMNodes, cp *checkpointer, err error) error {
+	rerr := &RollbackError{Err: err, Failed: make(map[string]error)}
+
+	if len(joined) == 0 {
+		return rerr
+	}
+
+	log.WithError(err).Warnf("error applying plan, rolling back %d nodes", len(joined))
+
+	for i := len(joined) - 1; i >= 0; i-- {
+		vm := joined[i]
+
+		// Rollback even if the plan failed because it was cancelled
+		ctx, cancel := context.WithTimeout(context.Background(), m.config.Timeout)
+		err := m.rollbackNode(ctx, plan, vm)
+		cancel()
+
+		if err != nil {
+			log.WithError(err).Errorf("error rolling back %s", vm.Hostname)
+			rerr.Failed[vm.Hostname] = err
+			continue
+		}
+
+		log.Infof("Rolled back %s", vm.Hostname)
+		rerr.RolledBack = append(rerr.RolledBack, vm.Hostname)
+	}
+
+	if plan.Init && len(rerr.Failed) == 0 {
+		// The cluster is gone so there is nothing left to resume
+		cp.remove()
+	}
+
+	return rerr
+}
+
+// rollbackNode forces a node that joined (or was being joined to) a cluster
+// while applying plan to leave the cluster and removes the node via the
+// plan's leader. Managers are demoted first so the remaining managers keep
+// their quorum. Nodes whose join failed may not be part of the cluster.
+func (m *Manager) rollbackNode(ctx context.Context, plan *Plan, vm VMNode) error {
+	// The leader of a new cluster leaves last which dissolves the cluster
+	leader := vm.Hostname == plan.Leader.Hostname
+
+	var node NodeStatus
+
+	if !leader {
+		if err := m.SwitchNodeContext(ctx, plan.Leader.PublicAddress); err != nil {
+			return fmt.Errorf("error switching to manager node: %w", err)
+		}
+
+		nodes, err := m.GetNodesContext(ctx)
+		if err != nil {
+			return fmt.Errorf("error getting current nodes: %w", err)
+		}
+
+		for _, n := range nodes {
+			if n.Hostname == vm.Hostname && !strings.EqualFold(n.Status, "down") {
+				node = n
+				break
+			}
+		}
+
+		if node.IsManager() {
+			if err := m.demoteNode(ctx, node.ID); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := m.SwitchNodeContext(ctx, vm.PublicAddress); err != nil {
+		return fmt.Errorf("error switching to node %s: %w", vm.PublicAddress, err)
+	}
+
+	info, err := m.GetInfoContext(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting node info: %w", err)
+	}
+
+	if info.Swarm.LocalNodeState != "inactive" {
+		if err := m.engine().SwarmLeave(ctx, true); err != nil {
+			return fmt.Errorf("error leaving swarm: %w", err)
+		}
+	}
+
+	if node.ID == "" {
+		return nil
+	}
+
+	if err := m.SwitchNodeContext(ctx, plan.Leader.PublicAddress); err != nil {
+		return fmt.Errorf("error switching to manager node: %w", err)
+	}
+
+	if err := m.waitForNodeDown(ctx, node.ID); err != nil {
+		return err
+	}
+
+	if err := m.engine().NodeRemove(ctx, node.ID); err != nil {
+		return fmt.Errorf("error removing node from cluster: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,380 @@
+/*
+	go-swarm is a Go library and ccommand-line tool for managing the creation
+	and maintenance of Docker Swarm cluster.
+
+    Copyright (C) 2021 Sovereign Cloud Australia Pty Ltd
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as published
+    by the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package swarm
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultConvergeTimeout is how long to wait by default for services to
+// converge after nodes were maintained
+const DefaultConvergeTimeout = time.Minute * 5 // 5 minutes
+
+// RollingMaintenanceOptions control how RollingMaintenance maintains the
+// nodes of a cluster
+type RollingMaintenanceOptions struct {
+	// Maintenance controls what is done to each node (see MaintainNode)
+	Maintenance MaintenanceOptions
+
+	// Concurrency is the maximum number of workers maintained at once (one
+	// if zero). Managers are always maintained one at a time.
+	Concurrency int
+
+	// ConvergeTimeout is how long to wait for services to converge after
+	// each batch of nodes (DefaultConvergeTimeout if zero)
+	ConvergeTimeout time.Duration
+}
+
+// RollingMaintenance performs maintenance (see MaintainNode) on every node of
+// an existing Docker Swarm cluster given by vms. Workers are maintained first
+// in batches of up to opts.Concurrency nodes followed by the managers one at
+// a time (the leader last). Before the first and after each batch services
+// must converge (all of their tasks running) before carrying on. Maintenance
+// stops at the first node that fails, which is left drained.
+func (m *Manager) RollingMaintenance(vms VMNodes, opts RollingMaintenanceOptions) error {
+	return m.RollingMaintenanceContext(context.Background(), vms, opts)
+}
+
+// RollingMaintenanceContext is like RollingMaintenance but the operation is
+// cancelled when ctx is done.
+func (m *Manager) RollingMaintenanceContext(ctx context.Context, vms VMNodes, opts RollingMaintenanceOptions) error {
+	if opts.Concurrency < 0 {
+		return fmt.Errorf("error invalid concurrency: %d", opts.Concurrency)
+	}
+	if opts.Concurrency == 0 {
+		opts.Concurrency = 1
+	}
+	if opts.ConvergeTimeout == 0 {
+		opts.ConvergeTimeout = DefaultConvergeTimeout
+	}
+
+	if err := opts.Maintenance.Drain.Validate(); err != nil {
+		return err
+	}
+
+	id, leader, err := m.findCluster(ctx, vms.FilterByTag(RoleTag, ManagerRole))
+	if err != nil {
+		return err
+	}
+
+	if id == "" {
+		return fmt.Errorf("error no swarm cluster found")
+	}
+
+	if err := m.SwitchNodeContext(ctx, leader.PublicAddress); err != nil {
+		return fmt.Errorf("error switching to manager node: %w", err)
+	}
+
+	nodes, err := m.GetNodesContext(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting current nodes: %w", err)
+	}
+
+	current := make(map[string]NodeStatus)
+	for _, node := range nodes {
+		if !strings.EqualFold(node.Status, "down") {
+			current[node.Hostname] = node
+		}
+	}
+
+	// Every node must be up to start with so the cluster is not degraded
+	// any further than by the nodes being maintained
+	var managers, workers VMNodes
+
+	for _, vm := range vms {
+		node, ok := current[vm.Hostname]
+		if !ok {
+			return fmt.Errorf("error node %s is not an active node of swarm cluster %s", vm.Hostname, id)
+		}
+		if node.IsManager() {
+			managers = append(managers, vm)
+		} else {
+			workers = append(workers, vm)
+		}
+	}
+
+	// The leader goes last so that leadership changes at most once
+	isLeader := func(vm VMNode) bool { return current[vm.Hostname].ManagerStatus == "Leader" }
+	sort.SliceStable(managers, func(i, j int) bool {
+		return !isLeader(managers[i]) && isLeader(managers[j])
+	})
+
+	if err := m.waitConverged(ctx, opts.ConvergeTimeout); err != nil {
+		return err
+	}
+
+	for i := 0; i < len(workers); i += opts.Concurrency {
+		end := i + opts.Concurrency
+		if end > len(workers) {
+			end = len(workers)
+		}
+
+		if err := m.maintainBatch(ctx, workers[i:end], leader, opts.Maintenance); err != nil {
+			return err
+		}
+
+		if err := m.waitConverged(ctx, opts.ConvergeTimeout); err != nil {
+			return err
+		}
+	}
+
+	for _, vm := range managers {
+		// Maintain each manager from another manager as the node being
+		// maintained may be rebooted
+		var via VMNode
+		for _, other := range managers {
+			if other.Hostname != vm.Hostname {
+				via = other
+				break
+			}
+		}
+
+		if via.Hostname == "" {
+			return fmt.Errorf("error no other manager to maintain manager %s from", vm.Hostname)
+		}
+
+		if err := m.SwitchNodeContext(ctx, via.PublicAddress); err != nil {
+			return fmt.Errorf("error switching to manager node: %w", err)
+		}
+
+		if err := m.ensureQuorumWithout(ctx, vm.Hostname); err != nil {
+			return err
+		}
+
+		log.Infof("Maintaining manager %s ...", vm.Hostname)
+
+		if err := m.MaintainNodeContext(ctx, vm.Hostname, opts.Maintenance); err != nil {
+			return fmt.Errorf("error maintaining node %s: %w", vm.Hostname, err)
+		}
+
+		if err := m.waitForReachable(ctx, vm.Hostname); err != nil {
+			return err
+		}
+
+		if err := m.waitConverged(ctx, opts.ConvergeTimeout); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// maintainBatch maintains the worker nodes of batch concurrently using the
+// manager node given by via
+func (m *Manager) maintainBatch(ctx context.Context, batch VMNodes, via VMNode, opts MaintenanceOptions) error {
+	var hostnames []string
+	for _, vm := range batch {
+		hostnames = append(hostnames, vm.Hostname)
+	}
+
+	log.Infof("Maintaining workers %s ...", strings.Join(hostnames, ", "))
+
+	// The percentage and capacity checks are done for the batch as a whole
+	// as the nodes are drained at the same time
+	if pct := opts.Drain.MaxDrainedPercent; pct > 0 {
+		if err := m.checkDrained(ctx, hostnames, pct); err != nil {
+			return err
+		}
+		opts.Drain.MaxDrainedPercent = 0
+	}
+
+	if !opts.Drain.Force {
+		if err := m.checkCapacity(ctx, hostnames); err != nil {
+			return err
+		}
+		opts.Drain.Force = true
+	}
+
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, len(batch))
+	)
+
+	for i, vm := range batch {
+		n, err := m.fork()
+		if err != nil {
+			return err
+		}
+		if err := n.SwitchNodeContext(ctx, via.PublicAddress); err != nil {
+			return fmt.Errorf("error switching to manager node: %w", err)
+		}
+
+		wg.Add(1)
+		go func(i int, n *Manager, vm VMNode) {
+			defer wg.Done()
+			if err := n.MaintainNodeContext(ctx, vm.Hostname, opts); err != nil {
+				log.WithError(err).Errorf("error maintaining node %s", vm.Hostname)
+				errs[i] = fmt.Errorf("error maintaining node %s: %w", vm.Hostname, err)
+			}
+		}(i, n, vm)
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ensureQuorumWithout checks that the cluster retains a quorum of reachable
+// managers while the manager given by its ID or hostname is unavailable
+func (m *Manager) ensureQuorumWithout(ctx context.Context, node string) error {
+	nodes, err := m.GetNodesContext(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting current nodes: %w", err)
+	}
+
+	var managers, reachable int
+	for _, n := range nodes {
+		if !n.IsManager() {
+			continue
+		}
+		managers++
+		if n.ID != node && n.Hostname != node && n.IsReachable() {
+			reachable++
+		}
+	}
+
+	if reachable < quorum(managers) {
+		return fmt.Errorf(
+			"error maintaining manager %s would leave %d of %d reachable managers (quorum is %d)",
+			node, reachable, managers, quorum(managers),
+		)
+	}
+
+	return nil
+}
+
+// unconverged returns the names of the services that have not converged,
+// that is services with tasks that are meant to be running but are not or
+// replicated services with fewer running tasks than replicas
+func (m *Manager) unconverged(ctx context.Context) ([]string, error) {
+	services, err := m.engine().ServiceList(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error listing services: %w", err)
+	}
+
+	if len(services) == 0 {
+		return nil, nil
+	}
+
+	byName := make(map[string]Service)
+	for _, service := range services {
+		byName[service.ID] = service
+		byName[service.Spec.Name] = service
+	}
+
+	nodes, err := m.GetNodesContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error getting current nodes: %w", err)
+	}
+
+	var (
+		running = make(map[string]int)
+		pending = make(map[string]int)
+	)
+
+	for _, node := range nodes {
+		if strings.EqualFold(node.Status, "down") {
+			continue
+		}
+
+		tasks, err := m.engine().NodeTasks(ctx, node.ID)
+		if err != nil {
+			return nil, fmt.Errorf("error getting tasks of node %s: %w", node.Hostname, err)
+		}
+
+		for _, task := range tasks {
+			service, ok := byName[task.serviceName()]
+			if !ok || !task.desiredRunning() {
+				continue
+			}
+			if task.currentlyRunning() {
+				running[service.ID]++
+			} else {
+				pending[service.ID]++
+			}
+		}
+	}
+
+	var names []string
+	for _, service := range services {
+		replicas := service.Spec.Mode.Replicated
+		switch {
+		case pending[service.ID] > 0:
+		case replicas != nil && replicas.Replicas != nil && uint64(running[service.ID]) < *replicas.Replicas:
+		default:
+			continue
+		}
+		names = append(names, service.Spec.Name)
+	}
+
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// waitConverged blocks until all services have converged or timeout expires
+func (m *Manager) waitConverged(ctx context.Context, timeout time.Duration) error {
+	startedAt := time.Now()
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(m.config.PollInterval)
+	defer ticker.Stop()
+
+	var names []string
+
+	for {
+		select {
+		case <-ticker.C:
+			unconverged, err := m.unconverged(ctx)
+			if err != nil {
+				log.WithError(err).Warn("error checking services (retrying)")
+				continue
+			}
+
+			if len(unconverged) == 0 {
+				return nil
+			}
+			names = unconverged
+
+			log.Infof("Still waiting for services %s to converge after %s ...", strings.Join(names, ", "), time.Since(startedAt))
+		case <-ctx.Done():
+			return fmt.Errorf(
+				"error waiting for services %s to converge after %s: %w",
+				strings.Join(names, ", "), time.Since(startedAt), ctx.Err(),
+			)
+		}
+	}
+}
@@ -0,0 +1,324 @@
+/*
+	go-swarm is a Go library and ccommand-line tool for managing the creation
+	and maintenance of Docker Swarm cluster.
+
+    Copyright (C) 2021 Sovereign Cloud Australia Pty Ltd
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as published
+    by the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package swarm
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Duration is a time.Duration that is encoded in JSON as a string such as
+// "5s" or "2160h"
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("error duration must be a string such as \"5s\": %w", err)
+	}
+
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(duration)
+
+	return nil
+}
+
+// SwarmConfig is the configuration of a cluster given by the `swarm` section
+// of a Clusterfile. Empty fields use Docker's defaults. Every field is
+// applied when the cluster is initialized but only the task history limit,
+// dispatcher heartbeat and certificate expiry can be changed afterwards.
+type SwarmConfig struct {
+	// DataPathAddr is the address or interface used for overlay network
+	// traffic by nodes that do not have their own data path address
+	DataPathAddr string `json:"data_path_addr,omitempty"`
+
+	// DataPathPort is the port used for overlay network traffic
+	DataPathPort uint32 `json:"data_path_port,omitempty"`
+
+	// DefaultAddrPool are the address pools (CIDRs) overlay networks are
+	// allocated from and DefaultAddrPoolMaskLength the size of each network
+	DefaultAddrPool           []string `json:"default_addr_pool,omitempty"`
+	DefaultAddrPoolMaskLength uint32   `json:"default_addr_pool_mask_length,omitempty"`
+
+	TaskHistoryLimit    *int64   `json:"task_history_limit,omitempty"`
+	DispatcherHeartbeat Duration `json:"dispatcher_heartbeat,omitempty"`
+	CertExpiry          Duration `json:"cert_expiry,omitempty"`
+
+	// Availability is the availability of the node initializing the cluster
+	Availability string `json:"availability,omitempty"`
+}
+
+// Options returns the Manager options for the non-empty fields of the config
+func (c SwarmConfig) Options() []Option {
+	var options []Option
+
+	if c.DataPathAddr != "" {
+		options = append(options, WithDataPathAddr(c.DataPathAddr))
+	}
+	if c.DataPathPort != 0 {
+		options = append(options, WithDataPathPort(c.DataPathPort))
+	}
+	if len(c.DefaultAddrPool) > 0 {
+		options = append(options, WithDefaultAddrPool(c.DefaultAddrPool...))
+	}
+	if c.DefaultAddrPoolMaskLength != 0 {
+		options = append(options, WithDefaultAddrPoolMaskLength(c.DefaultAddrPoolMaskLength))
+	}
+	if c.TaskHistoryLimit != nil {
+		options = append(options, WithTaskHistoryLimit(*c.TaskHistoryLimit))
+	}
+	if c.DispatcherHeartbeat != 0 {
+		options = append(options, WithDispatcherHeartbeat(time.Duration(c.DispatcherHeartbeat)))
+	}
+	if c.CertExpiry != 0 {
+		options = append(options, WithCertExpiry(time.Duration(c.CertExpiry)))
+	}
+	if c.Availability != "" {
+		options = append(options, WithAvailability(c.Availability))
+	}
+
+	return options
+}
+
+// Validate returns an error if any of the fields of the config are invalid
+func (c SwarmConfig) Validate() error {
+	cfg := NewDefaultConfig()
+	for _, option := range c.Options() {
+		if err := option(cfg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// initFlags returns the flags of `docker swarm init` for the config
+func (c SwarmConfig) initFlags() []string {
+	var flags []string
+
+	if c.DataPathAddr != "" {
+		flags = append(flags, "--data-path-addr", c.DataPathAddr)
+	}
+	if c.DataPathPort != 0 {
+		flags = append(flags, "--data-path-port", strconv.FormatUint(uint64(c.DataPathPort), 10))
+	}
+	for _, pool := range c.DefaultAddrPool {
+		flags = append(flags, "--default-addr-pool", pool)
+	}
+	if c.DefaultAddrPoolMaskLength != 0 {
+		flags = append(flags, "--default-addr-pool-mask-length", strconv.FormatUint(uint64(c.DefaultAddrPoolMaskLength), 10))
+	}
+	if c.Availability != "" {
+		flags = append(flags, "--availability", c.Availability)
+	}
+
+	return append(flags, c.update().flags()...)
+}
+
+// update returns the settings of the config that can be changed with
+// `docker swarm update`
+func (c SwarmConfig) update() SwarmUpdate {
+	return SwarmUpdate{
+		TaskHistoryLimit:    c.TaskHistoryLimit,
+		DispatcherHeartbeat: time.Duration(c.DispatcherHeartbeat),
+		CertExpiry:          time.Duration(c.CertExpiry),
+	}
+}
+
+// SwarmUpdate are changes to the settings of an existing cluster made with
+// `docker swarm update`. Empty fields are left unchanged.
+type SwarmUpdate struct {
+	TaskHistoryLimit    *int64
+	DispatcherHeartbeat time.Duration
+	CertExpiry          time.Duration
+}
+
+// Empty returns true if the update does not change anything
+func (u SwarmUpdate) Empty() bool {
+	return u.TaskHistoryLimit == nil && u.DispatcherHeartbeat == 0 && u.CertExpiry == 0
+}
+
+// flags returns the flags of `docker swarm update` for the update
+func (u SwarmUpdate) flags() []string {
+	var flags []string
+
+	if u.TaskHistoryLimit != nil {
+		flags = append(flags, "--task-history-limit", strconv.FormatInt(*u.TaskHistoryLimit, 10))
+	}
+	if u.DispatcherHeartbeat != 0 {
+		flags = append(flags, "--dispatcher-heartbeat", u.DispatcherHeartbeat.String())
+	}
+	if u.CertExpiry != 0 {
+		flags = append(flags, "--cert-expiry", u.CertExpiry.String())
+	}
+
+	return flags
+}
+
+// planSwarmUpdate computes the changes required to reconcile the settings
+// of an existing cluster with the config or nil if none are required.
+// Differences in settings that can only be set when the cluster is
+// initialized are logged as warnings.
+func planSwarmUpdate(cluster ClusterInfo, c SwarmConfig) *SwarmUpdate {
+	if c.DataPathPort != 0 && cluster.DataPathPort != 0 && c.DataPathPort != cluster.DataPathPort {
+		log.Warnf(
+			"data path port of swarm cluster %s is %d not %d and can only be set when the cluster is initialized",
+			cluster.ID, cluster.DataPathPort, c.DataPathPort,
+		)
+	}
+	if len(c.DefaultAddrPool) > 0 && strings.Join(c.DefaultAddrPool, ",") != strings.Join(cluster.DefaultAddrPool, ",") {
+		log.Warnf(
+			"default address pool of swarm cluster %s is %s not %s and can only be set when the cluster is initialized",
+			cluster.ID, strings.Join(cluster.DefaultAddrPool, ","), strings.Join(c.DefaultAddrPool, ","),
+		)
+	}
+	if c.DefaultAddrPoolMaskLength != 0 && c.DefaultAddrPoolMaskLength != cluster.SubnetSize {
+		log.Warnf(
+			"default address pool mask length of swarm cluster %s is %d not %d and can only be set when the cluster is initialized",
+			cluster.ID, cluster.SubnetSize, c.DefaultAddrPoolMaskLength,
+		)
+	}
+
+	var update SwarmUpdate
+
+	current := cluster.Spec.Orchestration.TaskHistoryRetentionLimit
+	if c.TaskHistoryLimit != nil && (current == nil || *current != *c.TaskHistoryLimit) {
+		update.TaskHistoryLimit = c.TaskHistoryLimit
+	}
+	if d := time.Duration(c.DispatcherHeartbeat); d != 0 && d != cluster.Spec.Dispatcher.HeartbeatPeriod {
+		update.DispatcherHeartbeat = d
+	}
+	if d := time.Duration(c.CertExpiry); d != 0 && d != cluster.Spec.CAConfig.NodeCertExpiry {
+		update.CertExpiry = d
+	}
+
+	if update.Empty() {
+		return nil
+	}
+
+	return &update
+}
+
+// WithDataPathAddr sets the address or interface used for overlay network
+// traffic by nodes that do not have their own data path address
+func WithDataPathAddr(addr string) Option {
+	return func(cfg *Config) error {
+		cfg.Swarm.DataPathAddr = addr
+		return nil
+	}
+}
+
+// WithDataPathPort sets the port used for overlay network traffic when a new
+// cluster is initialized
+func WithDataPathPort(port uint32) Option {
+	return func(cfg *Config) error {
+		if port < 1024 || port > 49151 {
+			return fmt.Errorf("error invalid data path port: %d (must be within 1024-49151)", port)
+		}
+		cfg.Swarm.DataPathPort = port
+		return nil
+	}
+}
+
+// WithDefaultAddrPool sets the address pools (CIDRs) overlay networks are
+// allocated from when a new cluster is initialized
+func WithDefaultAddrPool(pools ...string) Option {
+	return func(cfg *Config) error {
+		for _, pool := range pools {
+			if _, _, err := net.ParseCIDR(pool); err != nil {
+				return fmt.Errorf("error invalid default address pool: %w", err)
+			}
+		}
+		cfg.Swarm.DefaultAddrPool = pools
+		return nil
+	}
+}
+
+// WithDefaultAddrPoolMaskLength sets the size of overlay networks allocated
+// from the default address pools when a new cluster is initialized
+func WithDefaultAddrPoolMaskLength(length uint32) Option {
+	return func(cfg *Config) error {
+		if length < 1 || length > 32 {
+			return fmt.Errorf("error invalid default address pool mask length: %d", length)
+		}
+		cfg.Swarm.DefaultAddrPoolMaskLength = length
+		return nil
+	}
+}
+
+// WithTaskHistoryLimit sets how many tasks are retained for each task slot
+func WithTaskHistoryLimit(limit int64) Option {
+	return func(cfg *Config) error {
+		if limit < 0 {
+			return fmt.Errorf("error invalid task history limit: %d", limit)
+		}
+		cfg.Swarm.TaskHistoryLimit = &limit
+		return nil
+	}
+}
+
+// WithDispatcherHeartbeat sets how often nodes report their status
+func WithDispatcherHeartbeat(heartbeat time.Duration) Option {
+	return func(cfg *Config) error {
+		if heartbeat <= 0 {
+			return fmt.Errorf("error invalid dispatcher heartbeat: %s", heartbeat)
+		}
+		cfg.Swarm.DispatcherHeartbeat = Duration(heartbeat)
+		return nil
+	}
+}
+
+// WithCertExpiry sets how long node certificates are valid for
+func WithCertExpiry(expiry time.Duration) Option {
+	return func(cfg *Config) error {
+		if expiry <= 0 {
+			return fmt.Errorf("error invalid certificate expiry: %s", expiry)
+		}
+		cfg.Swarm.CertExpiry = Duration(expiry)
+		return nil
+	}
+}
+
+// WithAvailability sets the availability ("active", "pause" or "drain") of
+// the node initializing a new cluster
+func WithAvailability(availability string) Option {
+	return func(cfg *Config) error {
+		switch availability {
+		case "active", "pause", "drain":
+		default:
+			return fmt.Errorf("error invalid availability: %q", availability)
+		}
+		cfg.Swarm.Availability = availability
+		return nil
+	}
+}
@@ -0,0 +1,162 @@
+/*
+	go-swarm is a Go library and ccommand-line tool for managing the creation
+	and maintenance of Docker Swarm cluster.
+
+    Copyright (C) 2021 Sovereign Cloud Australia Pty Ltd
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as published
+    by the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package swarmtest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/aucloud/go-swarm"
+)
+
+// DefaultCertExpiry is how long node certificates are valid for unless
+// changed with `docker swarm update --cert-expiry`
+const DefaultCertExpiry = 90 * 24 * time.Hour
+
+// RootCA returns the cluster's root CA certificate (PEM encoded) or an empty
+// string if no cluster has been initialized
+func (c *Cluster) RootCA() string {
+	c.Lock()
+	defer c.Unlock()
+	return c.rootCA
+}
+
+// CertExpiry returns how long node certificates are valid for
+func (c *Cluster) CertExpiry() time.Duration {
+	c.Lock()
+	defer c.Unlock()
+	return c.settings.Spec.CAConfig.NodeCertExpiry
+}
+
+// ExternalCAs returns the external CAs configured for the cluster
+func (c *Cluster) ExternalCAs() []swarm.ExternalCA {
+	c.Lock()
+	defer c.Unlock()
+	return append([]swarm.ExternalCA{}, c.externalCAs...)
+}
+
+// issue issues a new certificate to node that expires after the cluster's
+// certificate expiry
+func (c *Cluster) issue(node *Node) {
+	node.CertNotAfter = time.Now().Add(c.settings.Spec.CAConfig.NodeCertExpiry)
+}
+
+func (c *Cluster) swarmCA(node *Node, args []string) (string, error) {
+	if !node.manager() {
+		return "", fmt.Errorf(notManagerError)
+	}
+
+	for _, arg := range args {
+		if arg != "--rotate" {
+			continue
+		}
+
+		rootCA, err := newRootCA()
+		if err != nil {
+			return "", err
+		}
+		c.rootCA = rootCA
+
+		for _, n := range c.nodes {
+			if n.active() {
+				c.issue(n)
+			}
+		}
+	}
+
+	return c.rootCA, nil
+}
+
+func (c *Cluster) nodeCertificate(node *Node) (string, error) {
+	if !node.active() {
+		return "", fmt.Errorf("cat: can't open '%s': No such file or directory", swarm.NodeCertificatePath)
+	}
+	return certificate(node.ID, node.CertNotAfter, false)
+}
+
+// newRootCA returns a new root CA certificate (PEM encoded) valid for 20
+// years as with Docker
+func newRootCA() (string, error) {
+	return certificate("swarm-ca", time.Now().Add(20*365*24*time.Hour), true)
+}
+
+// certificate returns a new self-signed certificate (PEM encoded) with the
+// given common name that expires at notAfter
+func certificate(cn string, notAfter time.Time, isCA bool) (string, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", fmt.Errorf("error generating key: %w", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              notAfter,
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return "", fmt.Errorf("error creating certificate: %w", err)
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})), nil
+}
+
+// parseExternalCA parses an external CA in the form used by
+// `docker swarm update --external-ca`
+func parseExternalCA(value string) (swarm.ExternalCA, error) {
+	var ca swarm.ExternalCA
+	for _, field := range strings.Split(value, ",") {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return ca, fmt.Errorf("invalid field '%s' must be a key=value pair", field)
+		}
+
+		switch kv[0] {
+		case "protocol":
+			ca.Protocol = kv[1]
+		case "url":
+			ca.URL = kv[1]
+		default:
+			if ca.Options == nil {
+				ca.Options = make(map[string]string)
+			}
+			ca.Options[kv[0]] = kv[1]
+		}
+	}
+
+	if ca.Protocol == "" || ca.URL == "" {
+		return ca, fmt.Errorf("protocol and url are required")
+	}
+
+	return ca, nil
+}
@@ -0,0 +1,1166 @@
+/*
+	go-swarm is a Go library and ccommand-line tool for managing the creation
+	and maintenance of Docker Swarm cluster.
+
+    Copyright (C) 2021 Sovereign Cloud Australia Pty Ltd
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as published
+    by the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package swarmtest provides an in-memory fake Docker Swarm cluster and
+// Switcher, as well as recording and replaying Switchers, for testing code
+// built on swarm.Manager without real Docker nodes.
+package swarmtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anmitsu/go-shlex"
+
+	"github.com/aucloud/go-swarm"
+)
+
+const (
+	// ClusterID is the ID of the cluster once initialized
+	ClusterID = "swarmtest-cluster"
+
+	// ManagerToken and WorkerToken are the cluster's join tokens when it is
+	// initialized (rotating a token appends a counter)
+	ManagerToken = "SWMTKN-1-swarmtest-manager"
+	WorkerToken  = "SWMTKN-1-swarmtest-worker"
+
+	// EngineVersion is the default engine version of nodes
+	EngineVersion = "20.10.12"
+
+	// NCPU and MemTotal are the default CPUs and memory (in bytes) of nodes
+	NCPU     = 2
+	MemTotal = 4 << 30
+
+	// UnlockKey is the cluster's unlock key when autolock is enabled
+	// (rotating the key appends a counter)
+	UnlockKey = "SWMKEY-1-swarmtest"
+
+	bootIDPath = "/proc/sys/kernel/random/boot_id"
+
+	notManagerError = "Error response from daemon: This node is not a swarm manager."
+	lockedError     = "Error response from daemon: Swarm is encrypted and needs to be unlocked before it can be used. Please use \"docker swarm unlock\" to unlock it."
+)
+
+// Node is a simulated Docker node
+type Node struct {
+	Hostname       string
+	PublicAddress  string
+	PrivateAddress string
+	EngineVersion  string
+
+	// NCPU and MemTotal are the node's CPUs and memory (in bytes)
+	NCPU     int
+	MemTotal int64
+
+	// ID is the node's ID once it has joined the cluster
+	ID string
+
+	// Role is the node's role in the cluster, empty if not part of a cluster
+	Role string
+
+	// AdvertiseAddr (host:port) and DataPathAddr are the addresses the node
+	// joined the cluster with
+	AdvertiseAddr string
+	DataPathAddr  string
+
+	// Member is true while the node is listed by the cluster (it remains
+	// listed as down after leaving until it is removed)
+	Member bool
+
+	Leader      bool
+	Down        bool
+	Unreachable bool
+
+	// Locked is true while a manager of an autolocked cluster is waiting to
+	// be unlocked after it was restarted
+	Locked bool
+
+	Availability string
+	Labels       map[string]string
+	Tasks        swarm.Tasks
+
+	// CertNotAfter is when the node's TLS certificate expires
+	CertNotAfter time.Time
+
+	// ClockOffset is how far the node's clock is ahead of SystemTime
+	ClockOffset time.Duration
+
+	// NoNetcat is true if nc is not installed on the node
+	NoNetcat bool
+
+	// Boots is the number of times the node was rebooted
+	Boots int
+}
+
+// active returns true if the node is part of a swarm
+func (n *Node) active() bool {
+	return n.Member && !n.Down
+}
+
+// manager returns true if the node is an active (and unlocked) manager of
+// a swarm
+func (n *Node) manager() bool {
+	return n.active() && n.Role == swarm.ManagerRole && !n.Locked
+}
+
+// Command is a command run on a node
+type Command struct {
+	Hostname string
+	Command  string
+}
+
+type handler struct {
+	command string
+	fn      func(node *Node) (string, error)
+}
+
+type failure struct {
+	hostname string
+	command  string
+	err      error
+	once     bool
+}
+
+// Cluster is an in-memory simulation of a set of Docker nodes that may form
+// a Docker Swarm cluster. Commands run with the Cluster's Switcher are
+// interpreted against the simulated nodes and produce the same output as the
+// `docker` CLI commands used by swarm.Manager.
+type Cluster struct {
+	sync.Mutex
+
+	clusterID string
+	tokens    map[string]string
+	unlockKey string
+	rotations int
+
+	rootCA      string
+	externalCAs []swarm.ExternalCA
+	settings    swarm.ClusterInfo
+
+	nodes    []*Node
+	services []swarm.Service
+	commands []Command
+	failures []failure
+	handlers []handler
+	blocked  map[string]bool
+}
+
+// NewCluster constructs a new Cluster of nodes (not yet part of any swarm)
+// from the given VM nodes
+func NewCluster(vms swarm.VMNodes) *Cluster {
+	c := &Cluster{settings: defaultSettings()}
+	for _, vm := range vms {
+		c.nodes = append(c.nodes, &Node{
+			Hostname:       vm.Hostname,
+			PublicAddress:  vm.PublicAddress,
+			PrivateAddress: vm.PrivateAddress,
+			EngineVersion:  EngineVersion,
+			NCPU:           NCPU,
+			MemTotal:       MemTotal,
+			Availability:   "active",
+		})
+	}
+	return c
+}
+
+// ClusterID returns the ID of the cluster or an empty string if no cluster
+// has been initialized
+func (c *Cluster) ClusterID() string {
+	c.Lock()
+	defer c.Unlock()
+	return c.clusterID
+}
+
+// JoinToken returns the current join token of the given type "manager" or
+// "worker" or an empty string if no cluster has been initialized
+func (c *Cluster) JoinToken(tokenType string) string {
+	c.Lock()
+	defer c.Unlock()
+	return c.tokens[tokenType]
+}
+
+// UnlockKey returns the current unlock key or an empty string if autolock
+// is not enabled
+func (c *Cluster) UnlockKey() string {
+	c.Lock()
+	defer c.Unlock()
+	return c.unlockKey
+}
+
+// Restart simulates restarting the Docker daemon of the node with the given
+// hostname. Managers of an autolocked cluster are locked until unlocked with
+// `docker swarm unlock`.
+func (c *Cluster) Restart(hostname string) {
+	c.Lock()
+	defer c.Unlock()
+	for _, node := range c.nodes {
+		if node.Hostname == hostname {
+			c.restart(node)
+		}
+	}
+}
+
+func (c *Cluster) restart(node *Node) {
+	if node.manager() && c.unlockKey != "" {
+		node.Locked = true
+	}
+}
+
+// Settings returns the settings of the cluster as reported by `docker info`
+// on a manager
+func (c *Cluster) Settings() swarm.ClusterInfo {
+	c.Lock()
+	defer c.Unlock()
+	settings := c.settings
+	settings.ID = c.clusterID
+	return settings
+}
+
+// defaultSettings returns Docker's default settings of a new cluster
+func defaultSettings() swarm.ClusterInfo {
+	limit := int64(5)
+	return swarm.ClusterInfo{
+		Spec: swarm.ClusterSpec{
+			Orchestration: swarm.OrchestrationConfig{TaskHistoryRetentionLimit: &limit},
+			Dispatcher:    swarm.DispatcherConfig{HeartbeatPeriod: 5 * time.Second},
+			CAConfig:      swarm.CAConfig{NodeCertExpiry: DefaultCertExpiry},
+		},
+		DefaultAddrPool: []string{"10.0.0.0/8"},
+		SubnetSize:      24,
+		DataPathPort:    4789,
+	}
+}
+
+// Node returns the node with the given hostname or nil
+func (c *Cluster) Node(hostname string) *Node {
+	c.Lock()
+	defer c.Unlock()
+	for _, node := range c.nodes {
+		if node.Hostname == hostname {
+			return node
+		}
+	}
+	return nil
+}
+
+// Members returns the nodes currently listed by the cluster
+func (c *Cluster) Members() []*Node {
+	c.Lock()
+	defer c.Unlock()
+	var members []*Node
+	for _, node := range c.nodes {
+		if node.Member {
+			members = append(members, node)
+		}
+	}
+	return members
+}
+
+// AddService adds a service to the cluster
+func (c *Cluster) AddService(service swarm.Service) {
+	c.Lock()
+	defer c.Unlock()
+	c.services = append(c.services, service)
+}
+
+// AddTask adds a task to the node with the given hostname
+func (c *Cluster) AddTask(hostname string, task swarm.TaskStatus) {
+	c.Lock()
+	defer c.Unlock()
+	if n := c.lookup(hostname); n != nil {
+		task.Node = n.Hostname
+		n.Tasks = append(n.Tasks, task)
+	}
+}
+
+// Services returns the services of the cluster
+func (c *Cluster) Services() []swarm.Service {
+	c.Lock()
+	defer c.Unlock()
+	return append([]swarm.Service{}, c.services...)
+}
+
+// Commands returns all commands run so far
+func (c *Cluster) Commands() []Command {
+	c.Lock()
+	defer c.Unlock()
+	return append([]Command{}, c.commands...)
+}
+
+// FailOn causes commands starting with command run on the node with the
+// given hostname (or any node if hostname is empty) to fail with err
+func (c *Cluster) FailOn(hostname, command string, err error) {
+	c.Lock()
+	defer c.Unlock()
+	c.failures = append(c.failures, failure{hostname: hostname, command: command, err: err})
+}
+
+// FailOnce is like FailOn but only fails the first matching command
+func (c *Cluster) FailOnce(hostname, command string, err error) {
+	c.Lock()
+	defer c.Unlock()
+	c.failures = append(c.failures, failure{hostname: hostname, command: command, err: err, once: true})
+}
+
+// Handle causes commands starting with command (that are not failed by
+// FailOn or FailOnce) to be handled by fn which is called with the node the
+// command is run on (and the Cluster locked)
+func (c *Cluster) Handle(command string, fn func(node *Node) (string, error)) {
+	c.Lock()
+	defer c.Unlock()
+	c.handlers = append(c.handlers, handler{command: command, fn: fn})
+}
+
+// ClearFailures removes all failures added by FailOn, FailOnce or Block
+func (c *Cluster) ClearFailures() {
+	c.Lock()
+	defer c.Unlock()
+	c.failures = nil
+	c.blocked = nil
+}
+
+// Switcher returns a new Switcher for the cluster
+func (c *Cluster) Switcher() swarm.Switcher {
+	return &Switcher{cluster: c}
+}
+
+func (c *Cluster) nodeByAddr(addr string) *Node {
+	c.Lock()
+	defer c.Unlock()
+	for _, node := range c.nodes {
+		if node.PublicAddress == addr || node.PrivateAddress == addr {
+			return node
+		}
+		if host, _, err := net.SplitHostPort(node.AdvertiseAddr); err == nil && host == addr {
+			return node
+		}
+	}
+	return nil
+}
+
+func (c *Cluster) lookup(ref string) *Node {
+	for _, node := range c.nodes {
+		if node.Member && (node.ID == ref || node.Hostname == ref) {
+			return node
+		}
+	}
+	return nil
+}
+
+func (c *Cluster) managers() []*Node {
+	var managers []*Node
+	for _, node := range c.nodes {
+		if node.Member && node.Role == swarm.ManagerRole {
+			managers = append(managers, node)
+		}
+	}
+	return managers
+}
+
+func (c *Cluster) details(node *Node) swarm.NodeDetails {
+	state := "ready"
+	if node.Down || node.Locked {
+		state = "down"
+	}
+
+	details := swarm.NodeDetails{
+		ID: node.ID,
+		Spec: swarm.NodeSpec{
+			Labels:       node.Labels,
+			Role:         node.Role,
+			Availability: node.Availability,
+		},
+		Description: swarm.NodeDescription{
+			Hostname: node.Hostname,
+			Platform: swarm.Platform{Architecture: "x86_64", OS: "linux"},
+			Resources: swarm.Resources{
+				NanoCPUs:    int64(node.NCPU) * 1e9,
+				MemoryBytes: node.MemTotal,
+			},
+			Engine: swarm.EngineDescription{EngineVersion: node.EngineVersion},
+		},
+		Status: swarm.NodeState{State: state, Addr: node.host()},
+	}
+
+	if node.Role == swarm.ManagerRole {
+		reachability := "reachable"
+		if node.Unreachable || node.Down || node.Locked {
+			reachability = "unreachable"
+		}
+		details.ManagerStatus = &swarm.ManagerStatus{
+			Leader:       node.Leader,
+			Reachability: reachability,
+			Addr:         node.AdvertiseAddr,
+		}
+	}
+
+	return details
+}
+
+// run interprets cmd on node with the given stdin and returns its output
+func (c *Cluster) run(node *Node, cmd, stdin string) (string, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.commands = append(c.commands, Command{Hostname: node.Hostname, Command: cmd})
+
+	for i, f := range c.failures {
+		if (f.hostname == "" || f.hostname == node.Hostname) && strings.HasPrefix(cmd, f.command) {
+			if f.once {
+				c.failures = append(c.failures[:i], c.failures[i+1:]...)
+			}
+			return "", f.err
+		}
+	}
+
+	for _, h := range c.handlers {
+		if strings.HasPrefix(cmd, h.command) {
+			return h.fn(node)
+		}
+	}
+
+	if cmd == swarm.DefaultRebootCommand {
+		return c.reboot(node)
+	}
+
+	args, err := shlex.Split(cmd, true)
+	if err != nil {
+		return "", fmt.Errorf("error parsing command %q: %w", cmd, err)
+	}
+
+	if len(args) == 2 && args[0] == "cat" && args[1] == bootIDPath {
+		return fmt.Sprintf("boot-%s-%d\n", node.Hostname, node.Boots), nil
+	}
+
+	if len(args) == 4 && strings.Join(args[:3], " ") == "sudo -n cat" && args[3] == swarm.NodeCertificatePath {
+		return c.nodeCertificate(node)
+	}
+
+	if len(args) == 3 && args[0] == "sh" && args[1] == "-c" {
+		return c.probe(node, args[2])
+	}
+
+	if len(args) < 2 || args[0] != "docker" {
+		return "", fmt.Errorf("unknown command %q", cmd)
+	}
+
+	command := strings.Join(args[1:min(3, len(args))], " ")
+	if node.Locked && command != "info --format" && command != "swarm unlock" {
+		return "", fmt.Errorf(lockedError)
+	}
+
+	switch command {
+	case "info --format":
+		return c.info(node)
+	case "node ls":
+		return c.nodeList(node)
+	case "node inspect":
+		return c.nodeInspect(node, args[len(args)-1])
+	case "node ps":
+		return c.nodePs(node, args[len(args)-1])
+	case "node update":
+		return c.nodeUpdate(node, args[3:])
+	case "node rm":
+		return c.nodeRemove(node, args[len(args)-1])
+	case "service ls":
+		return c.serviceList(node)
+	case "service inspect":
+		return c.serviceInspect(node, args[5:])
+	case "service rm":
+		return c.serviceRemove(node, args[3:])
+	case "swarm init":
+		return c.swarmInit(node, args[3:])
+	case "swarm join":
+		return c.swarmJoin(node, args[3:])
+	case "swarm join-token":
+		return c.joinToken(node, args[3:])
+	case "swarm leave":
+		return c.swarmLeave(node, args[3:])
+	case "swarm update":
+		return c.swarmUpdate(node, args[3:])
+	case "swarm unlock-key":
+		return c.swarmUnlockKey(node, args[3:])
+	case "swarm unlock":
+		return c.swarmUnlock(node, stdin)
+	case "swarm ca":
+		return c.swarmCA(node, args[3:])
+	}
+
+	return "", fmt.Errorf("unknown command %q", cmd)
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func (c *Cluster) info(node *Node) (string, error) {
+	info := swarm.NodeInfo{
+		ID:            fmt.Sprintf("engine-%s", node.Hostname),
+		Name:          node.Hostname,
+		NCPU:          node.NCPU,
+		MemTotal:      node.MemTotal,
+		ServerVersion: node.EngineVersion,
+		SystemTime:    SystemTime.Add(node.ClockOffset),
+		Swarm:         swarm.SwarmInfo{LocalNodeState: "inactive"},
+	}
+
+	if node.Locked {
+		info.Swarm.LocalNodeState = "locked"
+	} else if node.active() {
+		info.Swarm.NodeID = node.ID
+		info.Swarm.NodeAddr = node.host()
+		info.Swarm.LocalNodeState = "active"
+
+		for _, manager := range c.managers() {
+			info.Swarm.RemoteManagers = append(info.Swarm.RemoteManagers, swarm.RemoteManager{
+				NodeID: manager.ID,
+				Addr:   manager.AdvertiseAddr,
+			})
+		}
+
+		if node.Role == swarm.ManagerRole {
+			info.Swarm.ControlAvailable = true
+			info.Swarm.Cluster = c.settings
+			info.Swarm.Cluster.ID = c.clusterID
+			for _, n := range c.nodes {
+				if n.Member {
+					info.Swarm.Nodes++
+					if n.Role == swarm.ManagerRole {
+						info.Swarm.Managers++
+					}
+				}
+			}
+		}
+	}
+
+	data, err := json.Marshal(info)
+	return string(data) + "\n", err
+}
+
+func (c *Cluster) nodeList(node *Node) (string, error) {
+	if !node.manager() {
+		return "", fmt.Errorf(notManagerError)
+	}
+
+	var sb strings.Builder
+	for _, n := range c.nodes {
+		if !n.Member {
+			continue
+		}
+		data, err := json.Marshal(c.details(n).NodeStatus())
+		if err != nil {
+			return "", err
+		}
+		sb.Write(data)
+		sb.WriteString("\n")
+	}
+
+	return sb.String(), nil
+}
+
+func (c *Cluster) nodeInspect(node *Node, ref string) (string, error) {
+	if !node.manager() {
+		return "", fmt.Errorf(notManagerError)
+	}
+
+	n := c.lookup(ref)
+	if n == nil {
+		return "", fmt.Errorf("Error: No such node: %s", ref)
+	}
+
+	data, err := json.Marshal(c.details(n))
+	return string(data) + "\n", err
+}
+
+func (c *Cluster) nodePs(node *Node, ref string) (string, error) {
+	if !node.manager() {
+		return "", fmt.Errorf(notManagerError)
+	}
+
+	n := c.lookup(ref)
+	if n == nil {
+		return "", fmt.Errorf("Error: No such node: %s", ref)
+	}
+
+	var sb strings.Builder
+	for _, task := range n.Tasks {
+		data, err := json.Marshal(task)
+		if err != nil {
+			return "", err
+		}
+		sb.Write(data)
+		sb.WriteString("\n")
+	}
+
+	return sb.String(), nil
+}
+
+func (c *Cluster) nodeUpdate(node *Node, args []string) (string, error) {
+	if !node.manager() {
+		return "", fmt.Errorf(notManagerError)
+	}
+
+	if len(args) == 0 {
+		return "", fmt.Errorf("error no node given")
+	}
+
+	n := c.lookup(args[len(args)-1])
+	if n == nil {
+		return "", fmt.Errorf("Error: No such node: %s", args[len(args)-1])
+	}
+
+	for i := 0; i+1 < len(args)-1; i += 2 {
+		value := args[i+1]
+		switch args[i] {
+		case "--availability":
+			n.Availability = value
+			if value == "drain" {
+				c.drain(n)
+			}
+		case "--role":
+			if value == swarm.WorkerRole && n.Role == swarm.ManagerRole {
+				var reachable int
+				for _, m := range c.managers() {
+					if m != n && !m.Unreachable && !m.Down {
+						reachable++
+					}
+				}
+				if reachable < (len(c.managers())-1)/2+1 {
+					return "", fmt.Errorf("Error response from daemon: can't remove member from the raft: this would result in a loss of quorum")
+				}
+			}
+			n.Role = value
+			if n.Leader && value == swarm.WorkerRole {
+				n.Leader = false
+				for _, m := range c.managers() {
+					if !m.Unreachable && !m.Down {
+						m.Leader = true
+						break
+					}
+				}
+			}
+		case "--label-add":
+			if n.Labels == nil {
+				n.Labels = make(map[string]string)
+			}
+			tokens := strings.SplitN(value, "=", 2)
+			if len(tokens) == 2 {
+				n.Labels[tokens[0]] = tokens[1]
+			} else {
+				n.Labels[tokens[0]] = ""
+			}
+		case "--label-rm":
+			delete(n.Labels, value)
+		default:
+			return "", fmt.Errorf("unknown flag: %s", args[i])
+		}
+	}
+
+	return n.ID + "\n", nil
+}
+
+func (c *Cluster) nodeRemove(node *Node, ref string) (string, error) {
+	if !node.manager() {
+		return "", fmt.Errorf(notManagerError)
+	}
+
+	n := c.lookup(ref)
+	if n == nil {
+		return "", fmt.Errorf("Error: No such node: %s", ref)
+	}
+
+	if !n.Down {
+		return "", fmt.Errorf("Error response from daemon: rpc error: node %s is not down and can't be removed", n.ID)
+	}
+
+	n.Member = false
+	n.ID = ""
+	n.Role = ""
+
+	return ref + "\n", nil
+}
+
+func (c *Cluster) swarmInit(node *Node, args []string) (string, error) {
+	if node.active() {
+		return "", fmt.Errorf("Error response from daemon: This node is already part of a swarm.")
+	}
+
+	c.settings = defaultSettings()
+	availability := "active"
+
+	var (
+		pools                       []string
+		advertise, listen, dataPath string
+	)
+
+	for i := 0; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return "", fmt.Errorf("flag needs an argument: %s", args[i])
+		}
+
+		switch flag, value := args[i], args[i+1]; flag {
+		case "--advertise-addr":
+			advertise = value
+		case "--listen-addr":
+			listen = value
+		case "--data-path-addr":
+			dataPath = value
+		case "--data-path-port":
+			port, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				return "", fmt.Errorf("invalid argument %q for %s: %w", value, flag, err)
+			}
+			c.settings.DataPathPort = uint32(port)
+		case "--default-addr-pool":
+			pools = append(pools, value)
+		case "--default-addr-pool-mask-length":
+			length, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				return "", fmt.Errorf("invalid argument %q for %s: %w", value, flag, err)
+			}
+			c.settings.SubnetSize = uint32(length)
+		case "--availability":
+			availability = value
+		default:
+			if err := c.setting(flag, value); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	rootCA, err := newRootCA()
+	if err != nil {
+		return "", err
+	}
+
+	c.clusterID = ClusterID
+	c.rootCA = rootCA
+	c.tokens = map[string]string{
+		swarm.ManagerRole: ManagerToken,
+		swarm.WorkerRole:  WorkerToken,
+	}
+	if len(pools) > 0 {
+		c.settings.DefaultAddrPool = pools
+	}
+
+	c.join(node, swarm.ManagerRole, advertise, listen, dataPath)
+	node.Leader = true
+	node.Availability = availability
+
+	return fmt.Sprintf("Swarm initialized: current node (%s) is now a manager.\n", node.ID), nil
+}
+
+func (c *Cluster) join(node *Node, role, advertise, listen, dataPath string) {
+	node.ID = fmt.Sprintf("id-%s", node.Hostname)
+	node.Role = role
+	node.AdvertiseAddr = advertiseAddr(advertise, listen)
+	node.DataPathAddr = dataPath
+	node.Member = true
+	node.Down = false
+	node.Availability = "active"
+	c.issue(node)
+}
+
+// advertiseAddr returns the host:port a node advertises given the values of
+// --advertise-addr and --listen-addr, where the port defaults to that of the
+// listen address and then 2377 like Docker does
+func advertiseAddr(advertise, listen string) string {
+	port := "2377"
+	if _, p, err := net.SplitHostPort(listen); err == nil {
+		port = p
+	}
+	if _, _, err := net.SplitHostPort(advertise); err == nil {
+		return advertise
+	}
+	return net.JoinHostPort(advertise, port)
+}
+
+// host returns the host part of the node's advertise address
+func (n *Node) host() string {
+	host, _, _ := net.SplitHostPort(n.AdvertiseAddr)
+	return host
+}
+
+func (c *Cluster) swarmJoin(node *Node, args []string) (string, error) {
+	if node.active() {
+		return "", fmt.Errorf("Error response from daemon: This node is already part of a swarm.")
+	}
+
+	if len(args) == 0 {
+		return "", fmt.Errorf("\"docker swarm join\" requires exactly 1 argument.")
+	}
+
+	var token, advertise, listen, dataPath string
+	for i := 0; i+1 < len(args)-1; i += 2 {
+		switch flag, value := args[i], args[i+1]; flag {
+		case "--token":
+			token = value
+		case "--advertise-addr":
+			advertise = value
+		case "--listen-addr":
+			listen = value
+		case "--data-path-addr":
+			dataPath = value
+		default:
+			return "", fmt.Errorf("unknown flag: %s", flag)
+		}
+	}
+
+	remote := args[len(args)-1]
+	var found bool
+	for _, manager := range c.managers() {
+		if manager.AdvertiseAddr == remote {
+			found = true
+		}
+	}
+	if !found {
+		return "", fmt.Errorf("Error response from daemon: Timeout was reached before node joined.")
+	}
+
+	switch token {
+	case c.tokens[swarm.ManagerRole]:
+		c.join(node, swarm.ManagerRole, advertise, listen, dataPath)
+	case c.tokens[swarm.WorkerRole]:
+		c.join(node, swarm.WorkerRole, advertise, listen, dataPath)
+	default:
+		return "", fmt.Errorf("Error response from daemon: invalid join token")
+	}
+
+	return fmt.Sprintf("This node joined a swarm as a %s.\n", node.Role), nil
+}
+
+func (c *Cluster) joinToken(node *Node, args []string) (string, error) {
+	if !node.manager() {
+		return "", fmt.Errorf(notManagerError)
+	}
+
+	var rotate bool
+	for _, arg := range args {
+		if arg == "--rotate" {
+			rotate = true
+		}
+	}
+
+	tokenType := args[len(args)-1]
+	token, ok := c.tokens[tokenType]
+	if !ok {
+		return "", fmt.Errorf("unknown role %s", tokenType)
+	}
+
+	if rotate {
+		c.rotations++
+		if tokenType == swarm.ManagerRole {
+			token = fmt.Sprintf("%s-%d", ManagerToken, c.rotations)
+		} else {
+			token = fmt.Sprintf("%s-%d", WorkerToken, c.rotations)
+		}
+		c.tokens[tokenType] = token
+	}
+
+	return token + "\n", nil
+}
+
+func (c *Cluster) swarmUpdate(node *Node, args []string) (string, error) {
+	if !node.manager() {
+		return "", fmt.Errorf(notManagerError)
+	}
+
+	for i := 0; i < len(args); i++ {
+		switch arg := args[i]; arg {
+		case "--autolock=true", "--autolock":
+			if c.unlockKey == "" {
+				c.unlockKey = UnlockKey
+			}
+		case "--autolock=false":
+			c.unlockKey = ""
+		default:
+			if i+1 >= len(args) {
+				return "", fmt.Errorf("flag needs an argument: %s", arg)
+			}
+			if err := c.setting(arg, args[i+1]); err != nil {
+				return "", err
+			}
+			i++
+		}
+	}
+
+	return "Swarm updated.\n", nil
+}
+
+// setting changes a setting of the cluster given by a flag of
+// `docker swarm update` (that is also accepted by `docker swarm init`)
+func (c *Cluster) setting(flag, value string) error {
+	switch flag {
+	case "--task-history-limit":
+		limit, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid argument %q for %s: %w", value, flag, err)
+		}
+		c.settings.Spec.Orchestration.TaskHistoryRetentionLimit = &limit
+	case "--dispatcher-heartbeat":
+		heartbeat, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid argument %q for %s: %w", value, flag, err)
+		}
+		c.settings.Spec.Dispatcher.HeartbeatPeriod = heartbeat
+	case "--cert-expiry":
+		expiry, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid argument %q for %s: %w", value, flag, err)
+		}
+		c.settings.Spec.CAConfig.NodeCertExpiry = expiry
+	case "--external-ca":
+		ca, err := parseExternalCA(value)
+		if err != nil {
+			return err
+		}
+		c.externalCAs = []swarm.ExternalCA{ca}
+	default:
+		return fmt.Errorf("unknown flag %s", flag)
+	}
+
+	return nil
+}
+
+func (c *Cluster) swarmUnlockKey(node *Node, args []string) (string, error) {
+	if !node.manager() {
+		return "", fmt.Errorf(notManagerError)
+	}
+
+	if c.unlockKey == "" {
+		return "", fmt.Errorf("Error response from daemon: no unlock key is set")
+	}
+
+	for _, arg := range args {
+		if arg == "--rotate" {
+			c.rotations++
+			c.unlockKey = fmt.Sprintf("%s-%d", UnlockKey, c.rotations)
+		}
+	}
+
+	return c.unlockKey + "\n", nil
+}
+
+func (c *Cluster) swarmUnlock(node *Node, stdin string) (string, error) {
+	if !node.Locked {
+		return "", fmt.Errorf("Error response from daemon: swarm is not locked")
+	}
+
+	if strings.TrimSpace(stdin) != c.unlockKey {
+		return "", fmt.Errorf("Error response from daemon: invalid key")
+	}
+
+	node.Locked = false
+
+	return "", nil
+}
+
+func (c *Cluster) swarmLeave(node *Node, args []string) (string, error) {
+	force := len(args) > 0 && args[0] == "--force"
+
+	if !node.active() {
+		return "", fmt.Errorf("Error response from daemon: This node is not part of a swarm")
+	}
+	if node.Role == swarm.ManagerRole && !force {
+		return "", fmt.Errorf("Error response from daemon: You are attempting to leave the swarm on a node that is participating as a manager.")
+	}
+
+	// The node remains listed (as down) by the rest of the cluster
+	node.Down = true
+
+	if node.Leader {
+		node.Leader = false
+		for _, m := range c.managers() {
+			if m.manager() && !m.Unreachable {
+				m.Leader = true
+				break
+			}
+		}
+	}
+
+	// The cluster is gone once all of its managers have left, workers that
+	// have not left still think they are part of it
+	var managers int
+	for _, m := range c.managers() {
+		if m.manager() {
+			managers++
+		}
+	}
+	if managers == 0 {
+		c.clusterID = ""
+		c.tokens = nil
+		c.unlockKey = ""
+		c.rootCA = ""
+		for _, n := range c.nodes {
+			if n.Down {
+				n.Member = false
+				n.Down = false
+				n.ID = ""
+				n.Role = ""
+			}
+		}
+	}
+
+	return "Node left the swarm.\n", nil
+}
+
+func (c *Cluster) serviceList(node *Node) (string, error) {
+	if !node.manager() {
+		return "", fmt.Errorf(notManagerError)
+	}
+
+	var sb strings.Builder
+	for _, service := range c.services {
+		sb.WriteString(service.ID + "\n")
+	}
+
+	return sb.String(), nil
+}
+
+func (c *Cluster) serviceInspect(node *Node, refs []string) (string, error) {
+	if !node.manager() {
+		return "", fmt.Errorf(notManagerError)
+	}
+
+	var sb strings.Builder
+	for _, ref := range refs {
+		service := c.service(ref)
+		if service == nil {
+			return "", fmt.Errorf("Error: no such service: %s", ref)
+		}
+		data, err := json.Marshal(service)
+		if err != nil {
+			return "", err
+		}
+		sb.Write(data)
+		sb.WriteString("\n")
+	}
+
+	return sb.String(), nil
+}
+
+func (c *Cluster) serviceRemove(node *Node, refs []string) (string, error) {
+	if !node.manager() {
+		return "", fmt.Errorf(notManagerError)
+	}
+
+	var sb strings.Builder
+	for _, ref := range refs {
+		service := c.service(ref)
+		if service == nil {
+			return "", fmt.Errorf("Error: No such service: %s", ref)
+		}
+
+		var services []swarm.Service
+		for _, s := range c.services {
+			if s.ID != service.ID {
+				services = append(services, s)
+			}
+		}
+		c.services = services
+
+		sb.WriteString(ref + "\n")
+	}
+
+	return sb.String(), nil
+}
+
+func (c *Cluster) service(ref string) *swarm.Service {
+	for i, service := range c.services {
+		if service.ID == ref || service.Spec.Name == ref {
+			return &c.services[i]
+		}
+	}
+	return nil
+}
+
+// reboot reboots the node which comes back up immediately (locked if it is
+// a manager of an autolocked cluster)
+func (c *Cluster) reboot(node *Node) (string, error) {
+	node.Boots++
+	c.restart(node)
+	return "", nil
+}
+
+// drain shuts down the tasks of node (tasks that already stopped, e.g:
+// failed tasks, keep their state) and reschedules the running tasks of
+// replicated services on the active node with the fewest running tasks.
+// Tasks of services with placement constraints are not rescheduled as
+// constraints (and resources) are not simulated.
+func (c *Cluster) drain(node *Node) {
+	for i, task := range node.Tasks {
+		node.Tasks[i].DesiredState = "Shutdown"
+		if !task.Terminal() {
+			node.Tasks[i].CurrentState = "Shutdown 1 second ago"
+		}
+
+		if task.DesiredState != "Running" {
+			continue
+		}
+
+		name := task.Name
+		if i := strings.LastIndex(name, "."); i > 0 {
+			name = name[:i]
+		}
+
+		service := c.service(name)
+		if service == nil || service.IsGlobal() {
+			continue
+		}
+		if p := service.Spec.TaskTemplate.Placement; p != nil && len(p.Constraints) > 0 {
+			continue
+		}
+
+		var target *Node
+		for _, n := range c.nodes {
+			if n == node || !n.active() || n.Availability != "active" {
+				continue
+			}
+			if target == nil || running(n) < running(target) {
+				target = n
+			}
+		}
+
+		if target != nil {
+			task.Node = target.Hostname
+			task.CurrentState = "Running 1 second ago"
+			target.Tasks = append(target.Tasks, task)
+		}
+	}
+}
+
+// running returns the number of tasks meant to be running on node
+func running(node *Node) int {
+	var n int
+	for _, task := range node.Tasks {
+		if task.DesiredState == "Running" {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,115 @@
+/*
+	go-swarm is a Go library and ccommand-line tool for managing the creation
+	and maintenance of Docker Swarm cluster.
+
+    Copyright (C) 2021 Sovereign Cloud Australia Pty Ltd
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as published
+    by the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package swarmtest
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aucloud/go-swarm"
+)
+
+// SystemTime is the time reported by the clock of every node (plus the
+// node's ClockOffset)
+var SystemTime = time.Date(2021, time.June, 1, 0, 0, 0, 0, time.UTC)
+
+// Block drops connections to the given port ("tcp" or "udp" network) on the
+// node with the given hostname as a firewall would
+func (c *Cluster) Block(hostname, network string, port int) {
+	c.Lock()
+	defer c.Unlock()
+	if c.blocked == nil {
+		c.blocked = make(map[string]bool)
+	}
+	c.blocked[blockKey(hostname, network, port)] = true
+}
+
+func blockKey(hostname, network string, port int) string {
+	return fmt.Sprintf("%s %s/%d", hostname, network, port)
+}
+
+// listening returns true if the node is listening on the given port
+func (n *Node) listening(network string, port int) bool {
+	switch {
+	case !n.active():
+		return false
+	case port == 2377:
+		return network == "tcp" && n.Role == swarm.ManagerRole
+	case port == 7946:
+		return true
+	case port == swarm.DefaultDataPathPort:
+		return network == "udp"
+	}
+	return false
+}
+
+// probe interprets the port probe script run by swarm.Manager's pre-flight
+// checks (`...; timeout N nc -z[u] host port; echo $?`) and outputs the
+// status nc exits with (or 124 if the probe timed out) or "unavailable" if
+// the node has no nc
+func (c *Cluster) probe(node *Node, script string) (string, error) {
+	var args []string
+	for _, part := range strings.Split(script, ";") {
+		if fields := strings.Fields(part); len(fields) > 0 && fields[0] == "timeout" {
+			args = fields
+		}
+	}
+	if len(args) != 6 || args[0] != "timeout" || args[2] != "nc" {
+		return "", fmt.Errorf("unknown command %q", script)
+	}
+
+	if node.NoNetcat {
+		return "unavailable\n", nil
+	}
+
+	network := "tcp"
+	if args[3] == "-zu" {
+		network = "udp"
+	}
+
+	host := args[4]
+	port, err := strconv.Atoi(args[5])
+	if err != nil {
+		return "", fmt.Errorf("invalid port %q", args[5])
+	}
+
+	var target *Node
+	for _, n := range c.nodes {
+		advertise, _, _ := net.SplitHostPort(n.AdvertiseAddr)
+		if n.PrivateAddress == host || n.PublicAddress == host || advertise == host || n.DataPathAddr == host {
+			target = n
+		}
+	}
+
+	switch {
+	case target == nil, target.Down, c.blocked[blockKey(target.Hostname, network, port)]:
+		return "124\n", nil
+	case target.listening(network, port):
+		return "0\n", nil
+	}
+
+	// Nothing is listening so the connection is refused (or for UDP an ICMP
+	// port unreachable is returned)
+	return "1\n", nil
+}
@@ -0,0 +1,288 @@
+/*
+	go-swarm is a Go library and ccommand-line tool for managing the creation
+	and maintenance of Docker Swarm cluster.
+
+    Copyright (C) 2021 Sovereign Cloud Australia Pty Ltd
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as published
+    by the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package swarmtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/aucloud/go-runcmd"
+
+	"github.com/aucloud/go-swarm"
+)
+
+// Entry is a single command run on a node (given by the address it was
+// switched to) and its result
+type Entry struct {
+	Addr    string `json:"addr"`
+	Command string `json:"command"`
+	Stdout  string `json:"stdout,omitempty"`
+	Stderr  string `json:"stderr,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Recording is a sequence of commands and their results that can be saved
+// to and loaded from a golden file
+type Recording struct {
+	Entries []Entry `json:"entries"`
+}
+
+// Save writes the recording to a golden file given by path
+func (r *Recording) Save(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding recording: %w", err)
+	}
+
+	if err := ioutil.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("error writing recording %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// LoadRecording reads a recording from a golden file given by path
+func LoadRecording(path string) (*Recording, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading recording %s: %w", path, err)
+	}
+
+	var r Recording
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("error decoding recording %s: %w", path, err)
+	}
+
+	return &r, nil
+}
+
+// Recorder records all commands run with its Switchers and their results
+type Recorder struct {
+	sync.Mutex
+
+	entries []Entry
+}
+
+// NewRecorder constructs a new empty Recorder
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// Switcher returns a Switcher that wraps switcher and records all commands
+// run on any of the nodes it switches to
+func (r *Recorder) Switcher(switcher swarm.Switcher) swarm.Switcher {
+	return &recordingSwitcher{Switcher: switcher, recorder: r}
+}
+
+// Recording returns the commands recorded so far
+func (r *Recorder) Recording() *Recording {
+	r.Lock()
+	defer r.Unlock()
+	return &Recording{Entries: append([]Entry{}, r.entries...)}
+}
+
+func (r *Recorder) record(entry Entry) {
+	r.Lock()
+	defer r.Unlock()
+	r.entries = append(r.entries, entry)
+}
+
+type recordingSwitcher struct {
+	swarm.Switcher
+
+	recorder *Recorder
+	addr     string
+}
+
+func (s *recordingSwitcher) Switch(ctx context.Context, nodeAddr string) error {
+	if err := s.Switcher.Switch(ctx, nodeAddr); err != nil {
+		return err
+	}
+	s.addr = nodeAddr
+	return nil
+}
+
+func (s *recordingSwitcher) SwitchVia(ctx context.Context, nodeAddr string) error {
+	if err := s.Switcher.SwitchVia(ctx, nodeAddr); err != nil {
+		return err
+	}
+	s.addr = nodeAddr
+	return nil
+}
+
+func (s *recordingSwitcher) Runner() runcmd.Runner {
+	runner := s.Switcher.Runner()
+	if runner == nil {
+		return nil
+	}
+	return &recordingRunner{runner: runner, recorder: s.recorder, addr: s.addr}
+}
+
+func (s *recordingSwitcher) Clone() swarm.Switcher {
+	cloner, ok := s.Switcher.(swarm.Cloner)
+	if !ok {
+		return nil
+	}
+	switcher := cloner.Clone()
+	if switcher == nil {
+		return nil
+	}
+	return &recordingSwitcher{Switcher: switcher, recorder: s.recorder, addr: s.addr}
+}
+
+type recordingRunner struct {
+	runner   runcmd.Runner
+	recorder *Recorder
+	addr     string
+}
+
+func (r *recordingRunner) Command(cmd string) (runcmd.CmdWorker, error) {
+	return runFunc(func(cmd, input string) (string, string, error) {
+		worker, err := r.runner.Command(cmd)
+		if err != nil {
+			return "", "", err
+		}
+
+		var stdout, stderr bytes.Buffer
+		worker.SetStdout(&stdout)
+		worker.SetStderr(&stderr)
+
+		// Stdin is passed on but not recorded as it may contain secrets
+		// (e.g: unlock keys)
+		var stdin io.WriteCloser
+		if input != "" {
+			if stdin, err = worker.StdinPipe(); err != nil {
+				return "", "", err
+			}
+		}
+
+		if err = worker.Start(); err == nil {
+			if stdin != nil {
+				go func() {
+					defer stdin.Close()
+					io.WriteString(stdin, input)
+				}()
+			}
+			err = worker.Wait()
+		}
+
+		entry := Entry{Addr: r.addr, Command: cmd, Stdout: stdout.String(), Stderr: stderr.String()}
+		if err != nil {
+			entry.Error = err.Error()
+		}
+		r.recorder.record(entry)
+
+		return stdout.String(), stderr.String(), err
+	}).Command(cmd)
+}
+
+// Replayer replays a Recording. Commands run with its Switchers must match
+// the commands recorded for each node in the order they were recorded and
+// produce the recorded results. Commands run on different nodes may be
+// interleaved in any order.
+type Replayer struct {
+	sync.Mutex
+
+	queues map[string][]Entry
+}
+
+// NewReplayer constructs a new Replayer for the given recording
+func NewReplayer(recording *Recording) *Replayer {
+	r := &Replayer{queues: make(map[string][]Entry)}
+	for _, entry := range recording.Entries {
+		r.queues[entry.Addr] = append(r.queues[entry.Addr], entry)
+	}
+	return r
+}
+
+// Switcher returns a new Switcher that replays the recording
+func (r *Replayer) Switcher() swarm.Switcher {
+	return &replaySwitcher{replayer: r}
+}
+
+// Remaining returns the recorded commands that have not been replayed yet
+func (r *Replayer) Remaining() []Entry {
+	r.Lock()
+	defer r.Unlock()
+
+	var entries []Entry
+	for _, queue := range r.queues {
+		entries = append(entries, queue...)
+	}
+	return entries
+}
+
+func (r *Replayer) replay(addr, cmd string) (string, string, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	queue := r.queues[addr]
+	if len(queue) == 0 {
+		return "", "", fmt.Errorf("error unexpected command on %q: %s", addr, cmd)
+	}
+
+	entry := queue[0]
+	if entry.Command != cmd {
+		return "", "", fmt.Errorf("error unexpected command on %q: %s (expected %s)", addr, cmd, entry.Command)
+	}
+	r.queues[addr] = queue[1:]
+
+	if entry.Error != "" {
+		return entry.Stdout, entry.Stderr, errors.New(entry.Error)
+	}
+	return entry.Stdout, entry.Stderr, nil
+}
+
+type replaySwitcher struct {
+	replayer *Replayer
+	addr     string
+}
+
+func (s *replaySwitcher) String() string {
+	return fmt.Sprintf("replay://%s", s.addr)
+}
+
+func (s *replaySwitcher) Switch(ctx context.Context, nodeAddr string) error {
+	s.addr = nodeAddr
+	return nil
+}
+
+func (s *replaySwitcher) SwitchVia(ctx context.Context, nodeAddr string) error {
+	s.addr = nodeAddr
+	return nil
+}
+
+func (s *replaySwitcher) Runner() runcmd.Runner {
+	addr := s.addr
+	return runFunc(func(cmd, stdin string) (string, string, error) {
+		return s.replayer.replay(addr, cmd)
+	})
+}
+
+func (s *replaySwitcher) Clone() swarm.Switcher {
+	return &replaySwitcher{replayer: s.replayer, addr: s.addr}
+}
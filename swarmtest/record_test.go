@@ -0,0 +1,98 @@
+/*
+	go-swarm is a Go library and ccommand-line tool for managing the creation
+	and maintenance of Docker Swarm cluster.
+
+    Copyright (C) 2021 Sovereign Cloud Australia Pty Ltd
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as published
+    by the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package swarmtest
+
+import (
+	"flag"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/aucloud/go-swarm"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+var testVMs = swarm.VMNodes{
+	{
+		Hostname:       "dm1",
+		PublicAddress:  "10.0.0.1",
+		PrivateAddress: "172.16.0.1",
+		Tags:           map[string]string{"role": "manager"},
+	},
+	{
+		Hostname:       "dw1",
+		PublicAddress:  "10.0.0.2",
+		PrivateAddress: "172.16.0.2",
+		Tags:           map[string]string{"role": "worker", "labels": "zone=a"},
+	},
+}
+
+func newManager(t *testing.T, switcher swarm.Switcher) *swarm.Manager {
+	m, err := swarm.NewManager(switcher, swarm.WithTimeout(time.Second), swarm.WithPollInterval(time.Millisecond))
+	require.NoError(t, err)
+	return m
+}
+
+// TestRecordReplay records creating a cluster on a fake Cluster, compares
+// the recording against a golden file and replays the golden file.
+func TestRecordReplay(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	golden := filepath.Join("testdata", "create.json")
+
+	cluster := NewCluster(testVMs)
+	recorder := NewRecorder()
+	m := newManager(t, recorder.Switcher(cluster.Switcher()))
+	require.NoError(m.CreateSwarm(testVMs, true))
+
+	if *update {
+		require.NoError(recorder.Recording().Save(golden))
+	}
+
+	expected, err := LoadRecording(golden)
+	require.NoError(err)
+	assert.Equal(expected, recorder.Recording())
+
+	replayer := NewReplayer(expected)
+	m = newManager(t, replayer.Switcher())
+	assert.NoError(m.CreateSwarm(testVMs, true))
+	assert.Empty(replayer.Remaining())
+}
+
+// TestReplayMismatch ensures unexpected commands fail when replaying
+func TestReplayMismatch(t *testing.T) {
+	assert := assert.New(t)
+
+	replayer := NewReplayer(&Recording{Entries: []Entry{
+		{Addr: "10.0.0.1", Command: "docker swarm leave", Stdout: "Node left the swarm.\n"},
+	}})
+
+	m := newManager(t, replayer.Switcher())
+	assert.NoError(m.SwitchNode("10.0.0.1"))
+	_, err := m.GetInfo()
+	assert.Error(err)
+	assert.Len(replayer.Remaining(), 1)
+}
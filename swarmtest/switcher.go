@@ -0,0 +1,214 @@
+/*
+	go-swarm is a Go library and ccommand-line tool for managing the creation
+	and maintenance of Docker Swarm cluster.
+
+    Copyright (C) 2021 Sovereign Cloud Australia Pty Ltd
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as published
+    by the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package swarmtest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/aucloud/go-runcmd"
+
+	"github.com/aucloud/go-swarm"
+)
+
+// Switcher is a swarm.Switcher that switches between the simulated nodes
+// of a Cluster by their public or private address
+type Switcher struct {
+	sync.RWMutex
+
+	cluster *Cluster
+	node    *Node
+}
+
+func (s *Switcher) String() string {
+	s.RLock()
+	defer s.RUnlock()
+
+	if s.node == nil {
+		return "swarmtest://"
+	}
+	return fmt.Sprintf("swarmtest://%s", s.node.Hostname)
+}
+
+func (s *Switcher) Switch(ctx context.Context, nodeAddr string) error {
+	node := s.cluster.nodeByAddr(nodeAddr)
+	if node == nil {
+		return fmt.Errorf("error connecting to %s: no such node", nodeAddr)
+	}
+
+	s.Lock()
+	s.node = node
+	s.Unlock()
+
+	return nil
+}
+
+func (s *Switcher) SwitchVia(ctx context.Context, nodeAddr string) error {
+	return s.Switch(ctx, nodeAddr)
+}
+
+func (s *Switcher) Runner() runcmd.Runner {
+	s.RLock()
+	defer s.RUnlock()
+
+	if s.node == nil {
+		return nil
+	}
+
+	node := s.node
+	return runFunc(func(cmd, stdin string) (string, string, error) {
+		return output(s.cluster.run(node, cmd, stdin))
+	})
+}
+
+func (s *Switcher) Clone() swarm.Switcher {
+	s.RLock()
+	defer s.RUnlock()
+
+	return &Switcher{cluster: s.cluster, node: s.node}
+}
+
+// RunnerFunc is a runcmd.Runner that runs commands by calling the function
+// which returns the command's output. A non-nil error is written to the
+// command's stderr and returned when waiting on the command.
+type RunnerFunc func(cmd string) (string, error)
+
+func (f RunnerFunc) Command(cmd string) (runcmd.CmdWorker, error) {
+	return runFunc(func(cmd, stdin string) (string, string, error) {
+		return output(f(cmd))
+	}).Command(cmd)
+}
+
+// output returns the stdout, stderr and error of a command that returned
+// stdout and err with err written to stderr
+func output(stdout string, err error) (string, string, error) {
+	if err != nil {
+		return stdout, err.Error() + "\n", err
+	}
+	return stdout, "", nil
+}
+
+// runFunc is a runcmd.Runner that runs commands by calling the function
+// with the command's stdin which returns the command's stdout, stderr and
+// error
+type runFunc func(cmd, stdin string) (stdout, stderr string, err error)
+
+func (f runFunc) Command(cmd string) (runcmd.CmdWorker, error) {
+	return newWorker(cmd, f), nil
+}
+
+// worker is a runcmd.CmdWorker that runs a command with a runFunc
+type worker struct {
+	cmd string
+	run runFunc
+
+	stdin   io.Reader
+	stdout  io.Writer
+	stderr  io.Writer
+	closers []io.Closer
+
+	done chan struct{}
+	err  error
+}
+
+func newWorker(cmd string, run runFunc) *worker {
+	return &worker{
+		cmd:    cmd,
+		run:    run,
+		stdin:  strings.NewReader(""),
+		stdout: io.Discard,
+		stderr: io.Discard,
+	}
+}
+
+func (w *worker) Run() ([]string, error) {
+	var buf bytes.Buffer
+	w.stdout = &buf
+	w.stderr = &buf
+
+	if err := w.Start(); err != nil {
+		return nil, err
+	}
+	err := w.Wait()
+
+	return strings.Split(buf.String(), "\n"), err
+}
+
+func (w *worker) Start() error {
+	if w.done != nil {
+		return fmt.Errorf("error command already started")
+	}
+
+	w.done = make(chan struct{})
+	go func() {
+		defer close(w.done)
+
+		// The command's stdin is read in full (until it is closed) before
+		// the command is run
+		stdin, _ := io.ReadAll(w.stdin)
+
+		stdout, stderr, err := w.run(w.cmd, string(stdin))
+		io.WriteString(w.stdout, stdout)
+		io.WriteString(w.stderr, stderr)
+		for _, c := range w.closers {
+			c.Close()
+		}
+		w.err = err
+	}()
+
+	return nil
+}
+
+func (w *worker) Wait() error {
+	if w.done == nil {
+		return fmt.Errorf("error command not started")
+	}
+	<-w.done
+	return w.err
+}
+
+func (w *worker) StdinPipe() (io.WriteCloser, error) {
+	r, pw := io.Pipe()
+	w.stdin = r
+	return pw, nil
+}
+
+func (w *worker) StdoutPipe() (io.Reader, error) {
+	r, pw := io.Pipe()
+	w.stdout = pw
+	w.closers = append(w.closers, pw)
+	return r, nil
+}
+
+func (w *worker) StderrPipe() (io.Reader, error) {
+	r, pw := io.Pipe()
+	w.stderr = pw
+	w.closers = append(w.closers, pw)
+	return r, nil
+}
+
+func (w *worker) SetStdout(buffer io.Writer) { w.stdout = buffer }
+func (w *worker) SetStderr(buffer io.Writer) { w.stderr = buffer }
+func (w *worker) GetCommandLine() string     { return w.cmd }
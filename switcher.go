@@ -22,14 +22,18 @@ package swarm
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/aucloud/go-runcmd"
-	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
 )
 
 // Switcher is the interface that describes how to switch between Docker Nodes
@@ -42,6 +46,23 @@ type Switcher interface {
 	Runner() runcmd.Runner
 }
 
+// Cloner is implemented by Switchers that can be cloned so that operations
+// can be performed on several nodes at once. `Clone()` must return a new
+// Switcher with the same configuration that can switch nodes independently
+// of the original (e.g: via the current node). Operations that need to do
+// so fail with Switchers that do not implement Cloner.
+type Cloner interface {
+	Clone() Switcher
+}
+
+// APISwitcher is a Switcher that talks to the Docker Engine API of the
+// current node directly rather than running `docker` commands with a Runner.
+// The Runner of an APISwitcher may be nil.
+type APISwitcher interface {
+	Switcher
+	Client() *APIClient
+}
+
 type nullSwitcher struct{}
 
 func NewNullSwitcher() (Switcher, error)                                 { return &nullSwitcher{}, nil }
@@ -49,6 +70,7 @@ func (s *nullSwitcher) String() string                                   { retur
 func (s *nullSwitcher) Switch(ctx context.Context, addr string) error    { return nil }
 func (s *nullSwitcher) SwitchVia(ctx context.Context, addr string) error { return nil }
 func (s *nullSwitcher) Runner() runcmd.Runner                            { return nil }
+func (s *nullSwitcher) Clone() Switcher                                  { return s }
 
 type localSwitcher struct {
 	sync.RWMutex
@@ -72,14 +94,8 @@ func (s *localSwitcher) Runner() runcmd.Runner {
 }
 
 func (s *localSwitcher) Switch(ctx context.Context, host string) error {
-	runner, err := runcmd.NewLocalRunner()
-	if err != nil {
-		log.WithError(err).Error("error creating local runner")
-		return fmt.Errorf("error creating local runner: %w", err)
-	}
-
 	s.Lock()
-	s.runner = runner
+	s.runner = &localRunner{}
 	s.Unlock()
 
 	return nil
@@ -89,6 +105,10 @@ func (s *localSwitcher) SwitchVia(ctx context.Context, host string) error {
 	return s.Switch(ctx, host)
 }
 
+func (s *localSwitcher) Clone() Switcher {
+	return &localSwitcher{}
+}
+
 type sshSwitcher struct {
 	sync.RWMutex
 	runner runcmd.Runner
@@ -131,20 +151,22 @@ func (s *sshSwitcher) Runner() runcmd.Runner {
 	return s.runner
 }
 
+func (s *sshSwitcher) Clone() Switcher {
+	s.RLock()
+	defer s.RUnlock()
+	return &sshSwitcher{
+		user: s.user,
+		addr: s.addr,
+		jump: s.jump,
+		key:  s.key,
+	}
+}
+
 func (s *sshSwitcher) Switch(ctx context.Context, nodeAddr string) error {
-	_, port, err := net.SplitHostPort(s.addr)
+	addr, err := sshHostPort(s.addr, nodeAddr)
 	if err != nil {
-		if addrError, ok := err.(*net.AddrError); ok && addrError.Err == "missing port in address" {
-			port = "22"
-		} else {
-			return fmt.Errorf("error parsing addr: %w", err)
-		}
+		return err
 	}
-	if port == "" {
-		port = "22"
-	}
-
-	addr := fmt.Sprintf("%s:%s", nodeAddr, port)
 
 	runner, err := runcmd.NewRemoteKeyAuthRunner(ctx, s.user, addr, s.key)
 	if err != nil {
@@ -160,30 +182,395 @@ func (s *sshSwitcher) Switch(ctx context.Context, nodeAddr string) error {
 }
 
 func (s *sshSwitcher) SwitchVia(ctx context.Context, nodeAddr string) error {
-	_, port, err := net.SplitHostPort(s.addr)
+	addr, err := sshHostPort(s.addr, nodeAddr)
+	if err != nil {
+		return err
+	}
+
+	runner, err := runcmd.NewRemoteKeyAuthRunnerViaJumphost(ctx, s.user, addr, s.addr, s.key)
+	if err != nil {
+		return fmt.Errorf("error creating remote runner: %w", err)
+	}
+
+	s.Lock()
+	s.jump = s.addr
+	s.addr = addr
+	s.runner = runner
+	s.Unlock()
+
+	return nil
+}
+
+type unixSwitcher struct {
+	sync.RWMutex
+	client *APIClient
+
+	sockPath string
+	timeout  time.Duration
+}
+
+// NewUnixSwitcher constructs a new Switcher that talks directly to the Docker
+// Engine API on a local Docker UNIX Socket on a single-node
+func NewUnixSwitcher(sockPath string, timeout time.Duration) (Switcher, error) {
+	return &unixSwitcher{sockPath: sockPath, timeout: timeout}, nil
+}
+
+func (s *unixSwitcher) String() string {
+	return fmt.Sprintf("unix://%s", s.sockPath)
+}
+
+func (s *unixSwitcher) Runner() runcmd.Runner {
+	return nil
+}
+
+func (s *unixSwitcher) Client() *APIClient {
+	s.RLock()
+	defer s.RUnlock()
+	return s.client
+}
+
+func (s *unixSwitcher) Clone() Switcher {
+	return &unixSwitcher{sockPath: s.sockPath, timeout: s.timeout}
+}
+
+func (s *unixSwitcher) Switch(ctx context.Context, host string) error {
+	client := NewUnixAPIClient(s.sockPath, s.timeout)
+	if err := client.Ping(ctx); err != nil {
+		return fmt.Errorf("error connecting to docker api on %s: %w", s.sockPath, err)
+	}
+
+	s.Lock()
+	s.client = client
+	s.Unlock()
+
+	return nil
+}
+
+func (s *unixSwitcher) SwitchVia(ctx context.Context, host string) error {
+	return s.Switch(ctx, host)
+}
+
+// sshHostPort returns nodeAddr with the SSH port of addr (or 22 by default)
+func sshHostPort(addr, nodeAddr string) (string, error) {
+	_, port, err := net.SplitHostPort(addr)
 	if err != nil {
 		if addrError, ok := err.(*net.AddrError); ok && addrError.Err == "missing port in address" {
 			port = "22"
 		} else {
-			return fmt.Errorf("error parsing addr: %w", err)
+			return "", fmt.Errorf("error parsing addr: %w", err)
 		}
 	}
 	if port == "" {
 		port = "22"
 	}
 
-	addr := fmt.Sprintf("%s:%s", nodeAddr, port)
+	return net.JoinHostPort(nodeAddr, port), nil
+}
 
-	runner, err := runcmd.NewRemoteKeyAuthRunnerViaJumphost(ctx, s.user, addr, s.addr, s.key)
+// sshClientConfig returns the configuration for SSH connections with the
+// given user and private key (like runcmd host keys are not verified)
+func sshClientConfig(user, key string) (*ssh.ClientConfig, error) {
+	pemBytes, err := ioutil.ReadFile(key)
 	if err != nil {
-		return fmt.Errorf("error creating remote runner: %w", err)
+		return nil, fmt.Errorf("error reading private ssh key %s: %w", key, err)
+	}
+	signer, err := ssh.ParsePrivateKey(pemBytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing private ssh key %s: %w", key, err)
+	}
+
+	return &ssh.ClientConfig{
+		User:            user,
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+	}, nil
+}
+
+// sshDial establishes a SSH connection to addr optionally through an existing
+// SSH connection to a jump host
+func sshDial(ctx context.Context, config *ssh.ClientConfig, addr string, jump *ssh.Client) (*ssh.Client, error) {
+	var (
+		conn net.Conn
+		err  error
+	)
+
+	if jump != nil {
+		conn, err = jump.Dial("tcp", addr)
+	} else {
+		var d net.Dialer
+		conn, err = d.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to %s: %w", addr, err)
+	}
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to establish an SSH connection to %s: %w", addr, err)
+	}
+
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+type sshAPISwitcher struct {
+	sync.RWMutex
+	client *APIClient
+	conns  []*ssh.Client
+
+	user     string
+	addr     string
+	key      string
+	sockPath string
+	timeout  time.Duration
+}
+
+// NewSSHAPISwitcher constructs a new Switcher that talks to the Docker Engine
+// API of remote Docker nodes' UNIX Sockets forwarded over SSH
+func NewSSHAPISwitcher(user, addr, key, sockPath string, timeout time.Duration) (Switcher, error) {
+	key = os.ExpandEnv(key)
+
+	s := &sshAPISwitcher{
+		user:     user,
+		addr:     addr,
+		key:      key,
+		sockPath: sockPath,
+		timeout:  timeout,
+	}
+
+	if addr != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		if err := s.Switch(ctx, addr); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+func (s *sshAPISwitcher) String() string {
+	return fmt.Sprintf("ssh://%s@%s%s", s.user, s.current(), s.sockPath)
+}
+
+// current returns the address of the node currently connected to
+func (s *sshAPISwitcher) current() string {
+	s.RLock()
+	defer s.RUnlock()
+	return s.addr
+}
+
+func (s *sshAPISwitcher) Runner() runcmd.Runner {
+	return nil
+}
+
+func (s *sshAPISwitcher) Client() *APIClient {
+	s.RLock()
+	defer s.RUnlock()
+	return s.client
+}
+
+func (s *sshAPISwitcher) Clone() Switcher {
+	s.RLock()
+	defer s.RUnlock()
+	return &sshAPISwitcher{
+		user:     s.user,
+		addr:     s.addr,
+		key:      s.key,
+		sockPath: s.sockPath,
+		timeout:  s.timeout,
+	}
+}
+
+// connect forwards the Docker UNIX Socket over the SSH connection(s) conns
+// where the last connection is to the node itself and replaces (and closes)
+// any existing connections including those to jump hosts.
+func (s *sshAPISwitcher) connect(ctx context.Context, addr string, conns ...*ssh.Client) error {
+	conn := conns[len(conns)-1]
+	dial := func(ctx context.Context, network, _ string) (net.Conn, error) {
+		return conn.Dial("unix", s.sockPath)
+	}
+
+	client := NewAPIClient(dial, s.timeout)
+	if err := client.Ping(ctx); err != nil {
+		for _, conn := range conns {
+			conn.Close()
+		}
+		return fmt.Errorf("error connecting to docker api on %s: %w", addr, err)
 	}
 
 	s.Lock()
-	s.jump = s.addr
+	defer s.Unlock()
+
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+
 	s.addr = addr
-	s.runner = runner
+	s.conns = conns
+	s.client = client
+
+	return nil
+}
+
+func (s *sshAPISwitcher) Switch(ctx context.Context, nodeAddr string) error {
+	addr, err := sshHostPort(s.current(), nodeAddr)
+	if err != nil {
+		return err
+	}
+
+	config, err := sshClientConfig(s.user, s.key)
+	if err != nil {
+		return err
+	}
+
+	conn, err := sshDial(ctx, config, addr, nil)
+	if err != nil {
+		return err
+	}
+
+	return s.connect(ctx, addr, conn)
+}
+
+func (s *sshAPISwitcher) SwitchVia(ctx context.Context, nodeAddr string) error {
+	current := s.current()
+
+	addr, err := sshHostPort(current, nodeAddr)
+	if err != nil {
+		return err
+	}
+
+	config, err := sshClientConfig(s.user, s.key)
+	if err != nil {
+		return err
+	}
+
+	jump, err := sshDial(ctx, config, current, nil)
+	if err != nil {
+		return err
+	}
+
+	conn, err := sshDial(ctx, config, addr, jump)
+	if err != nil {
+		jump.Close()
+		return err
+	}
+
+	return s.connect(ctx, addr, jump, conn)
+}
+
+// DefaultTLSPort is the default port of the Docker Engine API protected by TLS
+const DefaultTLSPort = "2376"
+
+type tlsSwitcher struct {
+	sync.RWMutex
+	client *APIClient
+
+	addr    string
+	config  *tls.Config
+	timeout time.Duration
+}
+
+// NewTLSSwitcher constructs a new Switcher that talks to the Docker Engine API
+// of remote Docker nodes exposed over TCP (e.g: tcp://host:2376) protected by
+// mutual TLS with the given CA, certificate and key (as found in
+// `DOCKER_CERT_PATH`). Nodes must be reachable directly as there is no
+// "bastion" host to jump through.
+func NewTLSSwitcher(addr, caFile, certFile, keyFile string, timeout time.Duration) (Switcher, error) {
+	config, err := tlsClientConfig(os.ExpandEnv(caFile), os.ExpandEnv(certFile), os.ExpandEnv(keyFile))
+	if err != nil {
+		return nil, err
+	}
+
+	s := &tlsSwitcher{
+		addr:    strings.TrimPrefix(addr, "tcp://"),
+		config:  config,
+		timeout: timeout,
+	}
+
+	if s.addr != "" {
+		host, _, err := net.SplitHostPort(s.addr)
+		if err != nil {
+			host = s.addr
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		if err := s.Switch(ctx, host); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// tlsClientConfig returns the TLS configuration for mutual TLS with the CA,
+// certificate and key given by their paths
+func tlsClientConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	caCert, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading tls ca %s: %w", caFile, err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caCert) {
+		return nil, fmt.Errorf("error parsing tls ca %s: no certificates found", caFile)
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading tls cert %s and key %s: %w", certFile, keyFile, err)
+	}
+
+	return &tls.Config{
+		RootCAs:      pool,
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+func (s *tlsSwitcher) String() string {
+	return fmt.Sprintf("tcp://%s", s.addr)
+}
+
+func (s *tlsSwitcher) Runner() runcmd.Runner {
+	return nil
+}
+
+func (s *tlsSwitcher) Client() *APIClient {
+	s.RLock()
+	defer s.RUnlock()
+	return s.client
+}
+
+func (s *tlsSwitcher) Clone() Switcher {
+	s.RLock()
+	defer s.RUnlock()
+	return &tlsSwitcher{addr: s.addr, config: s.config, timeout: s.timeout}
+}
+
+func (s *tlsSwitcher) Switch(ctx context.Context, nodeAddr string) error {
+	_, port, err := net.SplitHostPort(s.addr)
+	if err != nil || port == "" {
+		port = DefaultTLSPort
+	}
+
+	addr := net.JoinHostPort(nodeAddr, port)
+
+	client := NewTLSAPIClient(addr, s.config, s.timeout)
+	if err := client.Ping(ctx); err != nil {
+		return fmt.Errorf("error connecting to docker api on %s: %w", addr, err)
+	}
+
+	s.Lock()
+	s.addr = addr
+	s.client = client
 	s.Unlock()
 
 	return nil
 }
+
+// SwitchVia switches directly to the node as the Docker Engine API cannot be
+// used as a "bastion" host
+func (s *tlsSwitcher) SwitchVia(ctx context.Context, nodeAddr string) error {
+	return s.Switch(ctx, nodeAddr)
+}
@@ -21,12 +21,44 @@
 package swarm
 
 import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
 	"strings"
+	"time"
 )
 
+// OrchestrationConfig is the orchestration configuration of the cluster
+type OrchestrationConfig struct {
+	TaskHistoryRetentionLimit *int64 `json:",omitempty"`
+}
+
+// DispatcherConfig is the dispatcher configuration of the cluster
+type DispatcherConfig struct {
+	HeartbeatPeriod time.Duration `json:",omitempty"`
+}
+
+// CAConfig is the CA configuration of the cluster
+type CAConfig struct {
+	NodeCertExpiry time.Duration `json:",omitempty"`
+}
+
+// ClusterSpec is the subset of the cluster's spec that is managed
+type ClusterSpec struct {
+	Orchestration OrchestrationConfig
+	Dispatcher    DispatcherConfig
+	CAConfig      CAConfig
+}
+
 type ClusterInfo struct {
 	ID        string
 	CreatedAt string
+
+	Spec            ClusterSpec
+	DefaultAddrPool []string
+	SubnetSize      uint32
+	DataPathPort    uint32
 }
 
 type RemoteManager struct {
@@ -61,6 +93,7 @@ type NodeInfo struct {
 	MemTotal int64
 
 	ServerVersion string
+	SystemTime    time.Time
 
 	Swarm SwarmInfo
 }
@@ -78,8 +111,124 @@ type NodeStatus struct {
 	Status        string
 }
 
+// IsManager returns true if the node is a manager (reachable or not)
+func (node NodeStatus) IsManager() bool {
+	return node.ManagerStatus != ""
+}
+
+// IsReachable returns true if the node is a manager that is reachable by
+// the other managers
+func (node NodeStatus) IsReachable() bool {
+	return node.ManagerStatus == "Leader" || node.ManagerStatus == "Reachable"
+}
+
 type Nodes []NodeStatus
 
+// NodeSpec is the desired state of a node as configured in the cluster
+type NodeSpec struct {
+	Name         string
+	Labels       map[string]string
+	Role         string
+	Availability string
+}
+
+type EngineDescription struct {
+	EngineVersion string
+	Labels        map[string]string `json:",omitempty"`
+}
+
+// Platform is the operating system and architecture of a node
+type Platform struct {
+	Architecture string
+	OS           string
+}
+
+// Resources is an amount of CPU (in units of 10^-9 CPUs) and memory (in
+// bytes) available on a node or reserved by a task
+type Resources struct {
+	NanoCPUs    int64 `json:",omitempty"`
+	MemoryBytes int64 `json:",omitempty"`
+}
+
+type NodeDescription struct {
+	Hostname  string
+	Platform  Platform
+	Resources Resources
+	Engine    EngineDescription
+}
+
+// NodeState is the current state of a node as reported by the cluster
+type NodeState struct {
+	State   string
+	Message string
+	Addr    string
+}
+
+type ManagerStatus struct {
+	Leader       bool
+	Reachability string
+	Addr         string
+}
+
+// ObjectVersion is the version of an object in the cluster used to avoid
+// conflicting updates
+type ObjectVersion struct {
+	Index uint64
+}
+
+// NodeDetails is the detailed information about a node in the cluster as
+// returned by `docker node inspect`
+type NodeDetails struct {
+	ID      string
+	Version ObjectVersion
+
+	Spec          NodeSpec
+	Description   NodeDescription
+	Status        NodeState
+	ManagerStatus *ManagerStatus
+}
+
+func (node NodeDetails) IsManager() bool {
+	return node.ManagerStatus != nil
+}
+
+func (node NodeDetails) IsDown() bool {
+	return strings.ToLower(node.Status.State) == "down"
+}
+
+// NodeStatus returns the node's status in the same form as `docker node ls`
+func (node NodeDetails) NodeStatus() NodeStatus {
+	status := NodeStatus{
+		ID:            node.ID,
+		Hostname:      node.Description.Hostname,
+		EngineVersion: node.Description.Engine.EngineVersion,
+		Availability:  capitalize(node.Spec.Availability),
+		Status:        capitalize(node.Status.State),
+	}
+
+	if node.ManagerStatus != nil {
+		if node.ManagerStatus.Leader {
+			status.ManagerStatus = "Leader"
+		} else {
+			status.ManagerStatus = capitalize(node.ManagerStatus.Reachability)
+		}
+	}
+
+	return status
+}
+
+// Addr returns the address of the node used for cluster communication
+func (node NodeDetails) Addr() string {
+	// Managers may report 0.0.0.0 as their address so prefer the manager's
+	// advertised address instead
+	if node.ManagerStatus != nil && (node.Status.Addr == "" || node.Status.Addr == "0.0.0.0") {
+		if host, _, err := net.SplitHostPort(node.ManagerStatus.Addr); err == nil {
+			return host
+		}
+	}
+	return node.Status.Addr
+}
+
 type TaskStatus struct {
 	ID           string
 	Name         string
@@ -91,17 +240,251 @@ type TaskStatus struct {
 	DesiredState string
 }
 
+// taskState returns the state of a task in lower case from its state in the
+// form used by `docker node ps` (e.g: "Running 5 minutes ago")
+func taskState(state string) string {
+	fields := strings.Fields(strings.ToLower(state))
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+// terminalStates are the states that a task never leaves
+var terminalStates = map[string]bool{
+	"complete": true,
+	"shutdown": true,
+	"failed":   true,
+	"rejected": true,
+	"orphaned": true,
+	"remove":   true,
+}
+
+// unstartedStates are the states of a task before it is started on a node
+var unstartedStates = map[string]bool{
+	"new":       true,
+	"allocated": true,
+	"pending":   true,
+	"assigned":  true,
+}
+
+// Terminal returns true if the task is in a state it never leaves (complete,
+// shutdown, failed, rejected, orphaned or remove)
+func (t TaskStatus) Terminal() bool {
+	return terminalStates[taskState(t.CurrentState)]
+}
+
+// Shutdown returns true if the task's current state is shutdown.
+//
+// Deprecated: tasks also stop in other states (e.g: failed), use Stopped
+// instead.
 func (t TaskStatus) Shutdown() bool {
-	return strings.HasPrefix(strings.ToLower(t.CurrentState), "shutdown")
+	return taskState(t.CurrentState) == "shutdown"
+}
+
+// Stopped returns true if the task is not running on its node and never will
+// be, either because it is in a terminal state or because it is no longer
+// meant to run and was never started
+func (t TaskStatus) Stopped() bool {
+	if t.Terminal() {
+		return true
+	}
+	return !t.desiredRunning() && unstartedStates[taskState(t.CurrentState)]
+}
+
+// desiredRunning returns true if the task is meant to be running (as
+// opposed to tasks that are being or have been shutdown)
+func (t TaskStatus) desiredRunning() bool {
+	return taskState(t.DesiredState) == "running"
+}
+
+// currentlyRunning returns true if the task is running
+func (t TaskStatus) currentlyRunning() bool {
+	return taskState(t.CurrentState) == "running"
+}
+
+// String returns the task's name, image, current state and error (if any)
+func (t TaskStatus) String() string {
+	s := fmt.Sprintf("%s (%s) %s", t.Name, t.Image, t.CurrentState)
+	if t.Error != "" {
+		s += ": " + t.Error
+	}
+	return s
 }
 
 type Tasks []TaskStatus
 
+// AllShutdown returns true if all of the tasks have stopped.
+//
+// Deprecated: use Remaining which also returns the tasks that have not
+// stopped.
 func (ts Tasks) AllShutdown() bool {
 	for _, t := range ts {
-		if !t.Shutdown() {
+		if !t.Stopped() {
 			return false
 		}
 	}
 	return true
 }
+
+// Remaining returns the tasks that have not stopped
+func (ts Tasks) Remaining() Tasks {
+	var remaining Tasks
+	for _, t := range ts {
+		if !t.Stopped() {
+			remaining = append(remaining, t)
+		}
+	}
+	return remaining
+}
+
+type ContainerSpec struct {
+	Image string
+}
+
+// ResourceRequirements are the resources a task is limited to and the
+// resources reserved for it on the node it runs on
+type ResourceRequirements struct {
+	Limits       *Resources `json:",omitempty"`
+	Reservations *Resources `json:",omitempty"`
+}
+
+// Placement controls which nodes a task may be scheduled on
+type Placement struct {
+	Constraints []string `json:",omitempty"`
+}
+
+type TaskSpec struct {
+	ContainerSpec ContainerSpec
+	Resources     *ResourceRequirements `json:",omitempty"`
+	Placement     *Placement            `json:",omitempty"`
+}
+
+type TaskState struct {
+	State   string
+	Message string
+	Err     string
+}
+
+// Task is a task in the cluster as returned by the Docker Engine API
+type Task struct {
+	ID           string
+	ServiceID    string
+	NodeID       string
+	Slot         int
+	Spec         TaskSpec
+	Status       TaskState
+	DesiredState string
+}
+
+// TaskStatus returns the task's status in the same form as `docker node ps`.
+// The API does not return service names so tasks are named after the ID of
+// the service they belong to.
+func (t Task) TaskStatus() TaskStatus {
+	name := t.ServiceID
+	if t.Slot > 0 {
+		name += fmt.Sprintf(".%d", t.Slot)
+	} else {
+		name += fmt.Sprintf(".%s", t.NodeID)
+	}
+
+	return TaskStatus{
+		ID:           t.ID,
+		Name:         name,
+		Image:        t.Spec.ContainerSpec.Image,
+		Error:        t.Status.Err,
+		Node:         t.NodeID,
+		CurrentState: capitalize(t.Status.State),
+		DesiredState: capitalize(t.DesiredState),
+	}
+}
+
+// ReplicatedService is the configuration of a replicated service
+type ReplicatedService struct {
+	Replicas *uint64 `json:",omitempty"`
+}
+
+// GlobalService is the configuration of a global service
+type GlobalService struct{}
+
+// ServiceMode is the mode of a service, either replicated or global
+type ServiceMode struct {
+	Replicated *ReplicatedService `json:",omitempty"`
+	Global     *GlobalService     `json:",omitempty"`
+}
+
+// ServiceSpec is the desired state of a service
+type ServiceSpec struct {
+	Name         string
+	Labels       map[string]string
+	Mode         ServiceMode
+	TaskTemplate TaskSpec
+}
+
+// Service is a service in the cluster as returned by `docker service inspect`
+type Service struct {
+	ID      string
+	Version ObjectVersion
+	Spec    ServiceSpec
+}
+
+// IsGlobal returns true if the service runs one task on every node
+func (s Service) IsGlobal() bool {
+	return s.Spec.Mode.Global != nil
+}
+
+type JoinTokens struct {
+	Worker  string
+	Manager string
+}
+
+// EncryptionConfig is the encryption configuration of the cluster
+type EncryptionConfig struct {
+	AutoLockManagers bool
+}
+
+// ExternalCA is an external certificate authority that issues the
+// certificates of nodes (instead of the cluster's own CA)
+type ExternalCA struct {
+	// Protocol is the protocol used to talk to the CA (only "cfssl" is
+	// supported by Docker)
+	Protocol string
+	URL      string
+	Options  map[string]string `json:",omitempty"`
+}
+
+// String returns the external CA in the form used by
+// `docker swarm update --external-ca`
+func (ca ExternalCA) String() string {
+	fields := []string{"protocol=" + ca.Protocol, "url=" + ca.URL}
+
+	keys := make([]string, 0, len(ca.Options))
+	for key := range ca.Options {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fields = append(fields, key+"="+ca.Options[key])
+	}
+
+	return strings.Join(fields, ",")
+}
+
+// TLSInfo is the information about the cluster's root CA
+type TLSInfo struct {
+	TrustRoot           string
+	CertIssuerSubject   string
+	CertIssuerPublicKey string
+}
+
+// SwarmDetails is the information about the cluster as returned by
+// `docker swarm inspect`. The Spec is kept as is so that it can be sent back
+// unchanged (or with only the fields being changed modified) when updating
+// the cluster.
+type SwarmDetails struct {
+	ID         string
+	Version    ObjectVersion
+	Spec       json.RawMessage
+	JoinTokens JoinTokens
+	TLSInfo    TLSInfo
+}
@@ -34,6 +34,15 @@ func ParseLabels(q string) (url.Values, error) {
 	return url.ParseQuery(q)
 }
 
+// capitalize returns s with the first letter in upper case (e.g: "ready"
+// becomes "Ready") as displayed by the docker CLI
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
 func HasString(a []string, x string) bool {
 	for _, n := range a {
 		if x == n {
@@ -0,0 +1,222 @@
+/*
+	go-swarm is a Go library and ccommand-line tool for managing the creation
+	and maintenance of Docker Swarm cluster.
+
+    Copyright (C) 2021 Sovereign Cloud Australia Pty Ltd
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as published
+    by the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package swarm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrEngineVersionTooOld is returned (wrapped) when a node's engine
+	// version is below the minimum engine version
+	ErrEngineVersionTooOld = errors.New("engine version below minimum")
+
+	// ErrEngineVersionSkew is returned (wrapped) when a node's engine version
+	// is more than one minor version apart from a manager's
+	ErrEngineVersionSkew = errors.New("engine version skew")
+)
+
+// EnginePolicy is the engine version policy of a Clusterfile
+type EnginePolicy struct {
+	// MinVersion is the minimum engine version of every node
+	MinVersion string `json:"min_version,omitempty"`
+
+	// Enforce refuses to join nodes that do not comply with the policy
+	Enforce bool `json:"enforce,omitempty"`
+}
+
+// Options returns the Options that apply the policy to a Manager
+func (p EnginePolicy) Options() []Option {
+	var options []Option
+	if p.MinVersion != "" {
+		options = append(options, WithMinEngineVersion(p.MinVersion))
+	}
+	if p.Enforce {
+		options = append(options, WithEngineVersionEnforcement())
+	}
+	return options
+}
+
+// WithMinEngineVersion sets the minimum engine version of every node. If
+// several minimum versions are given (e.g: by both a Clusterfile and a flag)
+// the highest applies.
+func WithMinEngineVersion(version string) Option {
+	return func(cfg *Config) error {
+		if _, _, err := parseVersion(version); err != nil {
+			return fmt.Errorf("invalid minimum engine version: %w", err)
+		}
+		if compareVersions(version, cfg.MinEngineVersion) > 0 {
+			cfg.MinEngineVersion = version
+		}
+		return nil
+	}
+}
+
+// WithEngineVersionEnforcement refuses to join nodes whose engine version is
+// below the minimum engine version or more than one minor version apart from
+// the managers. Otherwise a warning is logged and the node is joined.
+func WithEngineVersionEnforcement() Option {
+	return func(cfg *Config) error {
+		cfg.EnforceEngineVersion = true
+		return nil
+	}
+}
+
+// parseVersion returns the major and minor parts of an engine version such
+// as 20.10.12 or 19.03.15-ce
+func parseVersion(version string) (major, minor int, err error) {
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 2 {
+		return 0, 0, fmt.Errorf("error parsing version %q", version)
+	}
+	if major, err = strconv.Atoi(parts[0]); err != nil {
+		return 0, 0, fmt.Errorf("error parsing version %q: %w", version, err)
+	}
+	if minor, err = strconv.Atoi(strings.SplitN(parts[1], "-", 2)[0]); err != nil {
+		return 0, 0, fmt.Errorf("error parsing version %q: %w", version, err)
+	}
+	return major, minor, nil
+}
+
+// compareVersions compares two dotted versions numerically returning -1, 0
+// or 1 if a is older, the same or newer than b
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(strings.SplitN(as[i], "-", 2)[0])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(strings.SplitN(bs[i], "-", 2)[0])
+		}
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+	return 0
+}
+
+// skewed returns true if two versions are more than one minor version apart.
+// Versions with different major versions are always considered skewed.
+func skewed(a, b string) bool {
+	amajor, aminor, err := parseVersion(a)
+	if err != nil {
+		return false
+	}
+	bmajor, bminor, err := parseVersion(b)
+	if err != nil {
+		return false
+	}
+	if amajor != bmajor {
+		return true
+	}
+	return aminor-bminor > 1 || bminor-aminor > 1
+}
+
+// checkEngineVersion checks version against the minimum engine version and
+// the engine versions of the managers. Unknown (empty) versions are ignored.
+func (c *Config) checkEngineVersion(version string, managers []string) error {
+	if version == "" {
+		return nil
+	}
+
+	if c.MinEngineVersion != "" && compareVersions(version, c.MinEngineVersion) < 0 {
+		return fmt.Errorf("%w: %s is older than %s", ErrEngineVersionTooOld, version, c.MinEngineVersion)
+	}
+
+	for _, manager := range managers {
+		if manager != "" && skewed(version, manager) {
+			return fmt.Errorf(
+				"%w: %s is more than one minor version apart from manager version %s",
+				ErrEngineVersionSkew, version, manager,
+			)
+		}
+	}
+
+	return nil
+}
+
+// managerVersions returns the engine versions of the managers among nodes
+func managerVersions(nodes []NodeStatus) []string {
+	var versions []string
+	for _, node := range nodes {
+		if node.IsManager() {
+			versions = append(versions, node.EngineVersion)
+		}
+	}
+	return versions
+}
+
+// CheckEngineVersions checks the engine version of every node of the
+// cluster against the minimum engine version (see WithMinEngineVersion) and
+// the engine versions of the managers. The nodes that do not comply are
+// returned by node ID with the reason.
+func (m *Manager) CheckEngineVersions() (map[string]error, error) {
+	return m.CheckEngineVersionsContext(context.Background())
+}
+
+// CheckEngineVersionsContext is like CheckEngineVersions but the operation
+// is cancelled when ctx is done.
+func (m *Manager) CheckEngineVersionsContext(ctx context.Context) (map[string]error, error) {
+	nodes, err := m.GetNodesContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error getting nodes: %w", err)
+	}
+
+	versions := managerVersions(nodes)
+
+	issues := make(map[string]error)
+	for _, node := range nodes {
+		if err := m.config.checkEngineVersion(node.EngineVersion, versions); err != nil {
+			issues[node.ID] = err
+		}
+	}
+
+	return issues, nil
+}
+
+// checkJoin checks the engine version of the current node before it joins
+// the cluster, refusing to join it if the policy is enforced
+func (m *Manager) checkJoin(ctx context.Context, vm VMNode, managers []string) error {
+	info, err := m.GetInfoContext(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting node info: %w", err)
+	}
+
+	if err := m.config.checkEngineVersion(info.ServerVersion, managers); err != nil {
+		if m.config.EnforceEngineVersion {
+			return fmt.Errorf("error refusing to join %s: %w", vm.Hostname, err)
+		}
+		log.WithError(err).Warnf("node %s does not comply with the engine version policy", vm.Hostname)
+	}
+
+	return nil
+}
@@ -0,0 +1,62 @@
+/*
+	go-swarm is a Go library and ccommand-line tool for managing the creation
+	and maintenance of Docker Swarm cluster.
+
+    Copyright (C) 2021 Sovereign Cloud Australia Pty Ltd
+
+    This program is free software: you can redistribute it and/or modify
+    it under the terms of the GNU Affero General Public License as published
+    by the Free Software Foundation, either version 3 of the License, or
+    (at your option) any later version.
+
+    This program is distributed in the hope that it will be useful,
+    but WITHOUT ANY WARRANTY; without even the implied warranty of
+    MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+    GNU Affero General Public License for more details.
+
+    You should have received a copy of the GNU Affero General Public License
+    along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package swarm
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCompareVersions tests comparing engine versions numerically.
+func TestCompareVersions(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(0, compareVersions("20.10.12", "20.10.12"))
+	assert.Equal(1, compareVersions("20.10.12", "20.10.9"))
+	assert.Equal(-1, compareVersions("19.03.15-ce", "20.10.0"))
+	assert.Equal(1, compareVersions("20.10.0", ""))
+	assert.Equal(1, compareVersions("24.0.5", "23.0"))
+}
+
+// TestCheckEngineVersion tests checking an engine version against the
+// minimum engine version and the engine versions of the managers.
+func TestCheckEngineVersion(t *testing.T) {
+	assert := assert.New(t)
+
+	cfg := NewDefaultConfig()
+	managers := []string{"20.10.12", "20.10.7"}
+
+	assert.Nil(cfg.checkEngineVersion("20.10.1", managers))
+	assert.Nil(cfg.checkEngineVersion("20.9.1", managers))
+	assert.Nil(cfg.checkEngineVersion("", managers))
+	assert.True(errors.Is(cfg.checkEngineVersion("20.8.1", managers), ErrEngineVersionSkew))
+	assert.True(errors.Is(cfg.checkEngineVersion("19.03.15", managers), ErrEngineVersionSkew))
+
+	assert.Nil(WithMinEngineVersion("20.10.10")(cfg))
+	assert.Nil(WithMinEngineVersion("20.10.5")(cfg))
+	assert.Error(WithMinEngineVersion("latest")(cfg))
+	assert.Equal("20.10.10", cfg.MinEngineVersion)
+
+	assert.Nil(cfg.checkEngineVersion("20.10.12", managers))
+	assert.True(errors.Is(cfg.checkEngineVersion("20.10.7", managers), ErrEngineVersionTooOld))
+}